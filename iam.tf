@@ -5,6 +5,25 @@
 #
 data "aws_caller_identity" "current" {}
 
+data "aws_partition" "current" {}
+
+locals {
+  # Environment variables of the function, to grant the permissions of the features they enable, on the allowed
+  # secrets only for the features tagging the rotated secret
+  environment_values        = { for item in try(var.settings.environment.variables, []) : item.name => tostring(item.value) }
+  publish_version_parameter = trimprefix(try(local.environment_values["PUBLISH_VERSION_PARAMETER"], ""), "/")
+  event_bus_name            = try(local.environment_values["EVENT_BUS_NAME"], "")
+  rotation_lock             = contains(["true", "t", "1", "yes", "y"], lower(try(local.environment_values["ROTATION_LOCK"], "false"))) && length(try(var.settings.allowed_secrets, [])) > 0
+
+  # Secrets read besides the rotated ones: the Atlas API keys, the master secret and the TLS CA secrets
+  read_secrets = distinct([for name in concat(
+    [try(local.environment_values["MONGODB_ATLAS_SECRET_NAME"], ""), try(local.environment_values["MASTER_SECRET_ARN"], "")],
+    split(",", try(local.environment_values["ATLAS_API_KEY_SECRETS"], "")),
+    split(",", try(local.environment_values["TLS_CA_SECRETS"], "")),
+  ) : trimspace(name) if trimspace(name) != ""])
+  features_policy_enabled = local.publish_version_parameter != "" || local.event_bus_name != "" || local.rotation_lock || length(local.read_secrets) > 0
+}
+
 data "aws_iam_policy_document" "assume_role" {
   statement {
     effect = "Allow"
@@ -161,3 +180,71 @@ resource "aws_iam_role_policy" "custom" {
   role   = aws_iam_role.default_lambda_function.name
   policy = data.aws_iam_policy_document.custom[0].json
 }
+
+data "aws_iam_policy_document" "features" {
+  count = local.features_policy_enabled ? 1 : 0
+  dynamic "statement" {
+    for_each = local.publish_version_parameter != "" ? [1] : []
+    content {
+      sid    = "PublishVersionParameter"
+      effect = "Allow"
+      actions = [
+        "ssm:PutParameter",
+      ]
+      resources = [
+        startswith(local.publish_version_parameter, "arn:") ? local.publish_version_parameter : "arn:${data.aws_partition.current.partition}:ssm:${data.aws_region.current.id}:${data.aws_caller_identity.current.account_id}:parameter/${local.publish_version_parameter}"
+      ]
+    }
+  }
+  dynamic "statement" {
+    for_each = local.event_bus_name != "" ? [1] : []
+    content {
+      sid    = "PutRotationEvents"
+      effect = "Allow"
+      actions = [
+        "events:PutEvents",
+      ]
+      resources = [
+        startswith(local.event_bus_name, "arn:") ? local.event_bus_name : "arn:${data.aws_partition.current.partition}:events:${data.aws_region.current.id}:${data.aws_caller_identity.current.account_id}:event-bus/${local.event_bus_name}"
+      ]
+    }
+  }
+  dynamic "statement" {
+    for_each = length(local.read_secrets) > 0 ? [1] : []
+    content {
+      sid    = "ReadFunctionSecrets"
+      effect = "Allow"
+      actions = [
+        "secretsmanager:GetSecretValue",
+      ]
+      # A secret name matches the 6 characters Secrets Manager appends to the ARN of the secret
+      resources = [
+        for name in local.read_secrets : startswith(name, "arn:") ? name : "arn:${data.aws_partition.current.partition}:secretsmanager:${data.aws_region.current.id}:${data.aws_caller_identity.current.account_id}:secret:${name}-??????"
+      ]
+    }
+  }
+  dynamic "statement" {
+    for_each = local.rotation_lock ? [1] : []
+    content {
+      sid    = "RotationLockTag"
+      effect = "Allow"
+      actions = [
+        "secretsmanager:TagResource",
+        "secretsmanager:UntagResource",
+      ]
+      resources = var.settings.allowed_secrets
+      condition {
+        test     = "ForAllValues:StringEquals"
+        variable = "aws:TagKeys"
+        values   = ["SecretsRotationLock"]
+      }
+    }
+  }
+}
+
+resource "aws_iam_role_policy" "features" {
+  count  = local.features_policy_enabled ? 1 : 0
+  name   = "${local.function_name_short}-features-policy"
+  role   = aws_iam_role.default_lambda_function.name
+  policy = data.aws_iam_policy_document.features[0].json
+}
@@ -0,0 +1,107 @@
+// main.go
+//
+// rotate runs a single rotation step locally, for operators debugging a rotation against the real AWS and Atlas APIs.
+//
+//	rotate --secret-arn <arn> --step <step> --token <token>
+//
+// The step goes through rotation.HandleRequest, the same code path the Lambda runs, with the credentials and
+// environment variables of the local shell. A RotationResult JSON line is written to stdout when the step ends.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"mongodb-pwd-rotation-lambda/rotation"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout, rotation.HandleRequest); err != nil {
+		log.Fatalf("rotate: %v", err)
+	}
+}
+
+// run parses the arguments, runs the step through the handler and writes the RotationResult to out.
+func run(ctx context.Context, args []string, out io.Writer, handler func(context.Context, json.RawMessage) error) error {
+	event, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+	return dispatch(ctx, event, out, handler)
+}
+
+// parseArgs
+//
+// Parse the arguments of the rotate CLI into the event the Lambda would receive
+//
+//	Secrets Manager only runs a step for a version staged as AWSPENDING, so --token is required for every step but
+//	reconcile: createSecret takes the token of the pending version of a rotation started with rotate-secret, it is
+//	never generated here.
+//
+//	Flags:
+//	    --secret-arn: The secret ARN or other identifier (required)
+//	    --step: The rotation step to run, one of createSecret, setSecret, testSecret, finishSecret or reconcile (required)
+//	    --token: The ClientRequestToken of the AWSPENDING version (required, unused by reconcile)
+//
+//	Args:
+//	    args ([]string): The command line arguments
+//
+//	Returns:
+//	    SecretsManagerEvent: The rotation event
+//	    error: Error if the flags are invalid
+func parseArgs(args []string) (rotation.SecretsManagerEvent, error) {
+	var event rotation.SecretsManagerEvent
+	flags := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&event.SecretId, "secret-arn", "", "The secret ARN or other identifier")
+	flags.StringVar(&event.Step, "step", "", "The rotation step: createSecret, setSecret, testSecret, finishSecret or reconcile")
+	flags.StringVar(&event.ClientRequestToken, "token", "", "The ClientRequestToken of the AWSPENDING secret version")
+	if err := flags.Parse(args); err != nil {
+		return event, err
+	}
+	if flags.NArg() > 0 {
+		return event, fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if event.SecretId == "" {
+		return event, fmt.Errorf("--secret-arn is required")
+	}
+	switch event.Step {
+	case "createSecret", "setSecret", "testSecret", "finishSecret", rotation.ReconcileStep:
+	default:
+		return event, fmt.Errorf("--step must be one of createSecret, setSecret, testSecret, finishSecret or %v, got %q", rotation.ReconcileStep, event.Step)
+	}
+	if event.ClientRequestToken == "" && event.Step != rotation.ReconcileStep {
+		return event, fmt.Errorf("--token is required for %v, use the version staged as AWSPENDING by rotate-secret", event.Step)
+	}
+	return event, nil
+}
+
+// dispatch runs the event through the handler and writes the RotationResult.
+func dispatch(ctx context.Context, event rotation.SecretsManagerEvent, out io.Writer, handler func(context.Context, json.RawMessage) error) error {
+	rawEvent, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	start := time.Now()
+	err = handler(ctx, rawEvent)
+	result := rotation.RotationResult{
+		Step:       event.Step,
+		SecretName: event.SecretId,
+		VersionId:  event.ClientRequestToken,
+		Success:    err == nil,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if encodeErr := json.NewEncoder(out).Encode(result); encodeErr != nil {
+		return fmt.Errorf("failed to write result: %w", encodeErr)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+// main_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"mongodb-pwd-rotation-lambda/rotation"
+)
+
+const testToken = "22222222-2222-4222-8222-222222222222"
+
+func TestRun(t *testing.T) {
+	const arn = "arn:aws:secretsmanager:us-east-1:123456789012:secret:atlas-user"
+	tests := []struct {
+		name       string
+		args       []string
+		handlerErr error
+		wantEvent  rotation.SecretsManagerEvent
+		wantErr    string
+	}{
+		{
+			name:      "createSecret",
+			args:      []string{"--secret-arn", arn, "--step", "createSecret", "--token", testToken},
+			wantEvent: rotation.SecretsManagerEvent{SecretId: arn, Step: "createSecret", ClientRequestToken: testToken},
+		},
+		{
+			name:      "finishSecret",
+			args:      []string{"--secret-arn=" + arn, "--step=finishSecret", "--token=" + testToken},
+			wantEvent: rotation.SecretsManagerEvent{SecretId: arn, Step: "finishSecret", ClientRequestToken: testToken},
+		},
+		{
+			name:      "reconcile without token",
+			args:      []string{"--secret-arn", arn, "--step", rotation.ReconcileStep},
+			wantEvent: rotation.SecretsManagerEvent{SecretId: arn, Step: rotation.ReconcileStep},
+		},
+		{
+			name:       "step failure",
+			args:       []string{"--secret-arn", arn, "--step", "testSecret", "--token", testToken},
+			handlerErr: errors.New("login failed"),
+			wantEvent:  rotation.SecretsManagerEvent{SecretId: arn, Step: "testSecret", ClientRequestToken: testToken},
+			wantErr:    "login failed",
+		},
+		{name: "createSecret without token", args: []string{"--secret-arn", arn, "--step", "createSecret"}, wantErr: "--token is required"},
+		{name: "setSecret without token", args: []string{"--secret-arn", arn, "--step", "setSecret"}, wantErr: "--token is required"},
+		{name: "missing secret", args: []string{"--step", "setSecret", "--token", testToken}, wantErr: "--secret-arn is required"},
+		{name: "unknown step", args: []string{"--secret-arn", arn, "--step", "rotate", "--token", testToken}, wantErr: "--step must be one of"},
+		{name: "unknown flag", args: []string{"--secret-arn", arn, "--stage", "AWSPENDING"}, wantErr: "flag provided but not defined"},
+		{name: "extra argument", args: []string{"--secret-arn", arn, "--step", "setSecret", "--token", testToken, "now"}, wantErr: "unexpected arguments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []rotation.SecretsManagerEvent
+			handler := func(_ context.Context, rawEvent json.RawMessage) error {
+				var event rotation.SecretsManagerEvent
+				if err := json.Unmarshal(rawEvent, &event); err != nil {
+					t.Fatalf("handler received invalid event %s: %v", rawEvent, err)
+				}
+				calls = append(calls, event)
+				return tt.handlerErr
+			}
+			var out bytes.Buffer
+
+			err := run(context.Background(), tt.args, &out, handler)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("run() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+			if tt.wantEvent.Step == "" {
+				if len(calls) != 0 || out.Len() != 0 {
+					t.Fatalf("run() dispatched %+v and wrote %q, want nothing on invalid arguments", calls, out.String())
+				}
+				return
+			}
+			if len(calls) != 1 || calls[0] != tt.wantEvent {
+				t.Fatalf("run() dispatched %+v, want %+v", calls, tt.wantEvent)
+			}
+			var result rotation.RotationResult
+			if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+				t.Fatalf("run() wrote %q, want a RotationResult: %v", out.String(), err)
+			}
+			if result.Step != tt.wantEvent.Step || result.SecretName != tt.wantEvent.SecretId ||
+				result.VersionId != tt.wantEvent.ClientRequestToken || result.Success != (tt.handlerErr == nil) {
+				t.Errorf("run() result = %+v, want the step %v of %v", result, tt.wantEvent.Step, tt.wantEvent.SecretId)
+			}
+			if tt.handlerErr != nil && result.Error != tt.handlerErr.Error() {
+				t.Errorf("run() result error = %q, want %q", result.Error, tt.handlerErr.Error())
+			}
+		})
+	}
+}
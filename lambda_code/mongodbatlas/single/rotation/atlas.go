@@ -0,0 +1,288 @@
+// atlas.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// userChangePollInterval is the delay between two polls of the Atlas user changes, a variable so tests poll faster.
+var userChangePollInterval = 3 * time.Second
+
+// WaitForUserChangesApplied
+//
+// Wait until Atlas reports the user changes as applied on every cluster of the project
+//
+//	Atlas applies database user changes asynchronously, while a cluster reports a PENDING change status new
+//	credentials can't log in. When the clusters can't be listed, a fixed settle delay of USER_SETTLE_SECONDS
+//	(default 10) is applied instead, taken from the retry budget of the invocation and shortened to what is left of
+//	it (see RetryBudget).
+//
+//	Supported environment variables:
+//	    - USER_ACTIVE_TIMEOUT_SECONDS: maximum time to wait for the clusters, default 60
+//	    - USER_SETTLE_SECONDS: fallback settle delay, default 10
+//
+//	Args:
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    projectId (string): The Atlas project id
+//
+//	    clusterName (string): The cluster to wait for, all the project clusters when empty
+//
+//	Returns:
+//	    error: Error if the changes were not applied before the timeout
+func WaitForUserChangesApplied(ctx context.Context, mongoAdmin *admin.APIClient, projectId string, clusterName string) error {
+	clusterNames := []string{clusterName}
+	if clusterName == "" {
+		clusters, _, err := mongoAdmin.ClustersApi.ListClusters(ctx, projectId).Execute()
+		if err != nil {
+			settle := budgetWait(ctx, settings.UserSettle)
+			log.Printf("WaitForUserChangesApplied: Unable to list clusters of project %v, waiting %v: %v", projectId, settle, err)
+			return sleepContext(ctx, settle)
+		}
+		clusterNames = nil
+		for _, cluster := range clusters.GetResults() {
+			clusterNames = append(clusterNames, cluster.GetName())
+		}
+	}
+	timeout := settings.UserActiveTimeout
+	deadline := time.Now().Add(timeout)
+	for _, name := range clusterNames {
+		for {
+			status, _, err := mongoAdmin.ClustersApi.GetClusterStatus(ctx, projectId, name).Execute()
+			if err != nil {
+				return fmt.Errorf("failed to get status of cluster %v: %w", name, AtlasError(err))
+			}
+			if strings.EqualFold(status.GetChangeStatus(), "APPLIED") {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("user changes still %v on cluster %v after %v", status.GetChangeStatus(), name, timeout)
+			}
+			if err = spendRetry(ctx, userChangePollInterval); err != nil {
+				return fmt.Errorf("user changes still %v on cluster %v: %w", status.GetChangeStatus(), name, err)
+			}
+			log.Printf("WaitForUserChangesApplied: Cluster %v change status is %v, waiting", name, status.GetChangeStatus())
+			if err = sleepContext(ctx, userChangePollInterval); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WaitForCredentialPropagation
+//
+// Run the login check again while it fails with an authentication error, until the new credentials propagated
+//
+//	Atlas may accept logins with a new password some time after the user update returned, even once the clusters
+//	report the changes as applied. Secrets Manager has no error type making it retry a failed step sooner, a failed
+//	step is retried on the rotation own schedule, so this known eventual consistency case is waited for inside the
+//	step instead. Only authentication errors are retried, any other error is returned at once. The wait is bounded by
+//	PROPAGATION_TIMEOUT_SECONDS environment variable (default 60, 0 disables it) and stops finishDelayMargin before
+//	the Lambda deadline.
+//
+//	Args:
+//	    operation (string): The operation name used for logging
+//
+//	    fn (func() error): The login check
+//
+//	Returns:
+//	    error: The last error of the login check, nil once it succeeded
+func WaitForCredentialPropagation(ctx context.Context, operation string, fn func() error) error {
+	timeout := settings.PropagationTimeout
+	deadline := time.Now().Add(timeout)
+	if lambdaDeadline, ok := ctx.Deadline(); ok && lambdaDeadline.Add(-finishDelayMargin).Before(deadline) {
+		deadline = lambdaDeadline.Add(-finishDelayMargin)
+	}
+	for {
+		err := fn()
+		if err == nil || !IsMongoAuthError(err) {
+			return err
+		}
+		if time.Now().Add(userChangePollInterval).After(deadline) {
+			return fmt.Errorf("%w (credentials not accepted after waiting up to %v for propagation)", err, timeout)
+		}
+		if budgetErr := spendRetry(ctx, userChangePollInterval); budgetErr != nil {
+			return fmt.Errorf("%w (credentials not accepted yet: %w)", err, budgetErr)
+		}
+		log.Printf("%v: Credentials not accepted yet, waiting for propagation: %v", operation, err)
+		if err = sleepContext(ctx, userChangePollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// ConfirmUserUpdated
+//
+// Wait until Atlas returns the updated database user
+//
+//	The Atlas database user has no field telling whether an update was applied, a user just created (rotating
+//	username scheme, CREATE_USER_IF_MISSING) may also not be returned yet. The user is fetched again until Atlas
+//	returns it, then WaitForUserChangesApplied waits for the clusters, whose change status is what tells the change
+//	is effective. Waits at most USER_ACTIVE_TIMEOUT_SECONDS (default 60).
+//
+//	Args:
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    projectId (string): The Atlas project id
+//
+//	    authDatabase (string): The authentication database of the user
+//
+//	    username (string): The username
+//
+//	Returns:
+//	    error: Error if the user could not be fetched before the timeout
+func ConfirmUserUpdated(ctx context.Context, mongoAdmin *admin.APIClient, projectId string, authDatabase string, username string) error {
+	timeout := settings.UserActiveTimeout
+	deadline := time.Now().Add(timeout)
+	for {
+		_, resp, err := mongoAdmin.DatabaseUsersApi.GetDatabaseUser(ctx, projectId, authDatabase, username).Execute()
+		if err == nil {
+			return nil
+		}
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("failed to get user %v: %w", username, AtlasError(err))
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("user %v still not returned by Atlas after %v", username, timeout)
+		}
+		if err = spendRetry(ctx, userChangePollInterval); err != nil {
+			return fmt.Errorf("user %v still not returned by Atlas: %w", username, err)
+		}
+		log.Printf("ConfirmUserUpdated: User %v not returned yet by project %v, waiting", username, projectId)
+		if err = sleepContext(ctx, userChangePollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// AtlasError
+//
+// Add the Atlas API error code and detail to the error
+//
+//	The Atlas SDK error string usually carries only the HTTP status, the errorCode and detail fields of the
+//	response body explain what went wrong. The original error is kept wrapped.
+//
+//	Args:
+//	    err (error): The error returned by an Atlas SDK call
+//
+//	Returns:
+//	    error: The error with the Atlas error code and detail, or the original error if it is not an Atlas API error
+func AtlasError(err error) error {
+	apiError, ok := admin.AsError(err)
+	if !ok || apiError.GetErrorCode() == "" {
+		return err
+	}
+	if detail := apiError.GetDetail(); detail != "" {
+		return fmt.Errorf("%w [%v %v: %v]", err, apiError.GetError(), apiError.GetErrorCode(), detail)
+	}
+	return fmt.Errorf("%w [%v %v]", err, apiError.GetError(), apiError.GetErrorCode())
+}
+
+// ErrAtlasProjectAccess
+//
+// The Atlas API key is not allowed to access the project
+var ErrAtlasProjectAccess = errors.New("Atlas API key lacks access to the project")
+
+// AtlasProjectAccessError
+//
+// Classify an unauthorized or forbidden Atlas project call as a permissions problem
+//
+//	Organization API keys only reach the projects they were assigned to, a 401 or 403 on a project means the key
+//	must be added to the project with the Project Owner (or Project Database Access Admin) role.
+//
+//	Args:
+//	    err (error): The error returned by an Atlas SDK call on the project
+//
+//	    resp (*http.Response): The response of the call, may be nil
+//
+//	    projectId (string): The Atlas project id
+//
+//	Returns:
+//	    error: ErrAtlasProjectAccess with the remediation when the call was refused, otherwise AtlasError(err)
+func AtlasProjectAccessError(err error, resp *http.Response, projectId string) error {
+	if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		return fmt.Errorf("%w %v, add the API key to the project with the Project Owner or Project Database Access Admin role: %w",
+			ErrAtlasProjectAccess, projectId, AtlasError(err))
+	}
+	return AtlasError(err)
+}
+
+// ErrAtlasMaintenance
+//
+// The Atlas API is unavailable, usually during a maintenance window
+var ErrAtlasMaintenance = errors.New("Atlas appears to be in maintenance")
+
+// IsAtlasMaintenance
+//
+// Classify an Atlas call refused because Atlas is unavailable
+//
+//	Args:
+//	    err (error): The error returned by an Atlas SDK call
+//
+//	    resp (*http.Response): The response of the call, may be nil
+//
+//	Returns:
+//	    bool: True for a 503 Service Unavailable response or an Atlas error code mentioning maintenance
+func IsAtlasMaintenance(err error, resp *http.Response) bool {
+	if err == nil {
+		return false
+	}
+	if resp != nil && resp.StatusCode == http.StatusServiceUnavailable {
+		return true
+	}
+	apiError, ok := admin.AsError(err)
+	return ok && strings.Contains(strings.ToUpper(apiError.GetErrorCode()), "MAINTENANCE")
+}
+
+// RetryAtlasMaintenance
+//
+// Run an Atlas call retrying while Atlas is unavailable
+//
+//	The call is retried with RetryWithBackoff while IsAtlasMaintenance classifies its failure. When the retries
+//	are exhausted the error wraps ErrAtlasMaintenance and explains the rotation will be retried on schedule, so it
+//	is not mistaken for a credentials or permissions problem.
+//
+//	Args:
+//	    operation (string): The operation name used for logging
+//
+//	    fn (func() (*http.Response, error)): The Atlas call, returning its response and error
+//
+//	Returns:
+//	    error: nil on success, the maintenance error, or AtlasError of any other failure
+func RetryAtlasMaintenance(ctx context.Context, operation string, fn func() (*http.Response, error)) error {
+	var resp *http.Response
+	err := RetryWithBackoff(ctx, operation, func(err error) bool { return IsAtlasMaintenance(err, resp) }, func() error {
+		var err error
+		resp, err = fn()
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+	if IsAtlasMaintenance(err, resp) {
+		return fmt.Errorf("%w (%v unavailable after retries), the rotation will be retried on schedule: %w",
+			ErrAtlasMaintenance, operation, AtlasError(err))
+	}
+	return AtlasError(err)
+}
+
+// sleepContext
+//
+// Sleep for the given duration or until the context is done
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(duration):
+		return nil
+	}
+}
@@ -0,0 +1,388 @@
+// atlas_test.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/auth"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+// fastUserChangePolls shortens the Atlas user change poll interval for the test.
+func fastUserChangePolls(t *testing.T) {
+	t.Helper()
+	interval := userChangePollInterval
+	userChangePollInterval = time.Millisecond
+	t.Cleanup(func() { userChangePollInterval = interval })
+}
+
+// seedClusters adds the test project and its Cluster0 and Cluster1 clusters.
+func seedClusters(atlas *testutil.FakeAtlas) {
+	atlas.AddProject(testProjectId, "payments")
+	atlas.AddCluster(testProjectId, admin.ClusterDescription20240805{Name: admin.PtrString("Cluster0")})
+	atlas.AddCluster(testProjectId, admin.ClusterDescription20240805{Name: admin.PtrString("Cluster1")})
+}
+
+func TestWaitForUserChangesApplied(t *testing.T) {
+	tests := []struct {
+		name        string
+		clusterName string
+		statuses    map[string][]string
+		failures    []string
+		timeout     string
+		wantPolls   int
+		wantErr     string
+	}{
+		{name: "applied"},
+		{name: "pending then applied", statuses: map[string][]string{"Cluster0": {"PENDING", "PENDING", "APPLIED"}, "Cluster1": {"PENDING", "APPLIED"}},
+			wantPolls: 3},
+		{name: "still pending at timeout", statuses: map[string][]string{"Cluster1": {"PENDING"}}, timeout: "0",
+			wantErr: "user changes still PENDING on cluster Cluster1"},
+		{name: "only the secret cluster", clusterName: "Cluster0", statuses: map[string][]string{"Cluster1": {"PENDING"}}, timeout: "0"},
+		{name: "status failure", failures: []string{"GetClusterStatus"}, wantErr: "failed to get status of cluster"},
+		{name: "clusters not listed settle delay", failures: []string{"ListClusters"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fastUserChangePolls(t)
+			setEnv(t, map[string]string{"USER_ACTIVE_TIMEOUT_SECONDS": tt.timeout, "USER_SETTLE_SECONDS": "0"})
+			atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, seedClusters)
+			for clusterName, statuses := range tt.statuses {
+				atlas.SetClusterChangeStatus(testProjectId, clusterName, statuses...)
+			}
+			for _, operation := range tt.failures {
+				atlas.FailNext(operation, http.StatusInternalServerError)
+			}
+			logs := captureLog(t)
+
+			err := WaitForUserChangesApplied(context.Background(), mongoAdmin, testProjectId, tt.clusterName)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("WaitForUserChangesApplied() error = %v, want %q", err, tt.wantErr)
+			}
+			if polls := strings.Count(logs.String(), "change status is PENDING, waiting"); polls != tt.wantPolls {
+				t.Errorf("WaitForUserChangesApplied() waited %d times, want %d", polls, tt.wantPolls)
+			}
+		})
+	}
+}
+
+func TestWaitForCredentialPropagation(t *testing.T) {
+	otherErr := errors.New("connection refused")
+	tests := []struct {
+		name string
+		// authFailures is the number of authentication errors before the login succeeds, -1 for always
+		authFailures int
+		err          error
+		timeout      string
+		budget       string
+		deadline     time.Duration
+		wantCalls    int
+		wantErr      string
+	}{
+		{name: "accepted at once", wantCalls: 1},
+		{name: "accepted after propagation", authFailures: 2, wantCalls: 3},
+		{name: "other error not retried", err: otherErr, wantCalls: 1, wantErr: "connection refused"},
+		{name: "timeout", authFailures: -1, timeout: "0", wantCalls: 1, wantErr: "credentials not accepted after waiting"},
+		{name: "retry budget exhausted", authFailures: -1, budget: "1", wantCalls: 2, wantErr: ErrRetryBudgetExhausted.Error()},
+		// The wait stops finishDelayMargin before the Lambda deadline
+		{name: "lambda deadline", authFailures: -1, deadline: finishDelayMargin, wantCalls: 1, wantErr: "credentials not accepted after waiting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fastUserChangePolls(t)
+			setEnv(t, map[string]string{"PROPAGATION_TIMEOUT_SECONDS": tt.timeout, "RETRY_BUDGET_ATTEMPTS": tt.budget})
+			ctx := WithRetryBudget(context.Background())
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+			calls := 0
+			login := func() error {
+				calls++
+				if tt.authFailures < 0 || calls <= tt.authFailures {
+					return fmt.Errorf("failed to ping MongoDB: %w", &auth.Error{})
+				}
+				return tt.err
+			}
+
+			err := WaitForCredentialPropagation(ctx, "TestSecret", login)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("WaitForCredentialPropagation() error = %v, want %q", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("WaitForCredentialPropagation() ran the login %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestConfirmUserUpdated(t *testing.T) {
+	tests := []struct {
+		name      string
+		notFound  int
+		failure   int
+		timeout   string
+		budget    string
+		wantPolls int
+		wantErr   string
+	}{
+		{name: "returned at once"},
+		{name: "not returned then returned", notFound: 2, wantPolls: 2},
+		{name: "not returned at timeout", notFound: 1, timeout: "0", wantErr: "still not returned by Atlas after"},
+		{name: "retry budget exhausted", notFound: 2, budget: "1", wantPolls: 1, wantErr: ErrRetryBudgetExhausted.Error()},
+		{name: "server error", failure: http.StatusInternalServerError, wantErr: "failed to get user app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fastUserChangePolls(t)
+			setEnv(t, map[string]string{"USER_ACTIVE_TIMEOUT_SECONDS": tt.timeout, "RETRY_BUDGET_ATTEMPTS": tt.budget})
+			atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, seedClusters)
+			atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: "admin", Username: "app"})
+			for range tt.notFound {
+				atlas.FailNext("GetDatabaseUser", http.StatusNotFound)
+			}
+			if tt.failure != 0 {
+				atlas.FailNext("GetDatabaseUser", tt.failure)
+			}
+			logs := captureLog(t)
+
+			err := ConfirmUserUpdated(WithRetryBudget(context.Background()), mongoAdmin, testProjectId, "admin", "app")
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("ConfirmUserUpdated() error = %v, want %q", err, tt.wantErr)
+			}
+			if polls := strings.Count(logs.String(), "not returned yet by project"); polls != tt.wantPolls {
+				t.Errorf("ConfirmUserUpdated() waited %d times, want %d", polls, tt.wantPolls)
+			}
+		})
+	}
+}
+
+func TestSetSecretWaitsForUserActive(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		notFound int
+		timeout  string
+		wantErr  string
+	}{
+		{name: "pending then applied", statuses: []string{"PENDING", "APPLIED"}},
+		{name: "never applied", statuses: []string{"PENDING"}, timeout: "0", wantErr: "Failed waiting for user app"},
+		{name: "user returned late", statuses: []string{"APPLIED"}, notFound: 2},
+		{name: "user never returned", statuses: []string{"APPLIED"}, notFound: 1, timeout: "0", wantErr: "Failed confirming user app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fastUserChangePolls(t)
+			unsetEnv(t, "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING", "DEFAULT_AUTH_DATABASE")
+			setEnv(t, map[string]string{"WAIT_FOR_USER_ACTIVE": "true", "USER_ACTIVE_TIMEOUT_SECONDS": tt.timeout})
+			fields := map[string]string{"cluster_name": "Cluster0"}
+			smClient, atlas, mongoAdmin := newRotationFakes(t, fields, fields)
+			atlas.AddCluster(testProjectId, admin.ClusterDescription20240805{Name: admin.PtrString("Cluster0")})
+			atlas.SetClusterChangeStatus(testProjectId, "Cluster0", tt.statuses...)
+			if tt.notFound > 0 {
+				// SetSecret reads the user before updating it
+				atlas.SucceedNext("GetDatabaseUser")
+			}
+			for range tt.notFound {
+				atlas.FailNext("GetDatabaseUser", http.StatusNotFound)
+			}
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("SetSecret() error = %v, want %q", err, tt.wantErr)
+			}
+			if user, _ := atlas.User(testProjectId, "admin", "app"); user.GetPassword() != "new-password" {
+				t.Errorf("user password = %q, want the AWSPENDING password", user.GetPassword())
+			}
+		})
+	}
+}
+
+// atlasErrorTransport answers every Atlas request with the given status and body.
+type atlasErrorTransport struct {
+	status int
+	body   string
+}
+
+func (t atlasErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: t.status,
+		Status:     fmt.Sprintf("%d %s", t.status, http.StatusText(t.status)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(t.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestAtlasError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{name: "code and detail", status: http.StatusBadRequest,
+			body: `{"error": 400, "errorCode": "INVALID_ATTRIBUTE", "detail": "Invalid attribute password specified.", "reason": "Bad Request"}`,
+			want: "[400 INVALID_ATTRIBUTE: Invalid attribute password specified.]"},
+		{name: "code only", status: http.StatusNotFound, body: `{"error": 404, "errorCode": "USERNAME_NOT_FOUND"}`,
+			want: "[404 USERNAME_NOT_FOUND]"},
+		{name: "not an Atlas error body", status: http.StatusBadGateway, body: `<html>Bad Gateway</html>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mongoAdmin, err := admin.NewClient(
+				admin.UseBaseURL("https://atlas.fake.invalid"),
+				admin.UseHTTPClient(&http.Client{Transport: atlasErrorTransport{status: tt.status, body: tt.body}}),
+			)
+			if err != nil {
+				t.Fatalf("failed to create Atlas client: %v", err)
+			}
+			_, _, sdkErr := mongoAdmin.DatabaseUsersApi.GetDatabaseUser(context.Background(), testProjectId, "admin", "app").Execute()
+			if sdkErr == nil {
+				t.Fatalf("GetDatabaseUser() error = nil, want the %d response", tt.status)
+			}
+
+			err = AtlasError(sdkErr)
+			if !errors.Is(err, sdkErr) {
+				t.Errorf("AtlasError() = %v, want it to wrap %v", err, sdkErr)
+			}
+			if tt.want == "" && err != sdkErr {
+				t.Errorf("AtlasError() = %v, want the original error", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("AtlasError() = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+	if err := AtlasError(errPermanent); err != errPermanent {
+		t.Errorf("AtlasError() = %v, want a non Atlas error unchanged", err)
+	}
+}
+
+func TestSetSecretProjectAccess(t *testing.T) {
+	tests := []struct {
+		name       string
+		operation  string
+		status     int
+		wantAccess bool
+	}{
+		{name: "forbidden project", operation: "GetProject", status: http.StatusForbidden, wantAccess: true},
+		{name: "unauthorized project", operation: "GetProject", status: http.StatusUnauthorized, wantAccess: true},
+		{name: "forbidden user", operation: "GetDatabaseUser", status: http.StatusForbidden, wantAccess: true},
+		{name: "server error", operation: "GetProject", status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING")
+			setEnv(t, map[string]string{"RETRY_MAX_ATTEMPTS": "1"})
+			smClient, atlas, mongoAdmin := newRotationFakes(t, nil, nil)
+			atlas.FailNext(tt.operation, tt.status)
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if err == nil {
+				t.Fatalf("SetSecret() error = nil, want the %v failure", tt.operation)
+			}
+			if got := errors.Is(err, ErrAtlasProjectAccess); got != tt.wantAccess {
+				t.Errorf("errors.Is(err, ErrAtlasProjectAccess) = %v, want %v: %v", got, tt.wantAccess, err)
+			}
+			if got := strings.Contains(err.Error(), "Project Owner or Project Database Access Admin"); got != tt.wantAccess {
+				t.Errorf("SetSecret() error remediation = %v, want %v: %v", got, tt.wantAccess, err)
+			}
+			if user, _ := atlas.User(testProjectId, "admin", "app"); user.GetPassword() != "" {
+				t.Errorf("user password = %q, want the user left unchanged", user.GetPassword())
+			}
+		})
+	}
+}
+
+func TestAtlasProjectAccessErrorWithoutResponse(t *testing.T) {
+	err := errors.New("connection reset")
+	if got := AtlasProjectAccessError(err, nil, testProjectId); got != err {
+		t.Errorf("AtlasProjectAccessError() = %v, want the original error", got)
+	}
+}
+
+func TestIsAtlasMaintenance(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   bool
+	}{
+		{name: "service unavailable", status: http.StatusServiceUnavailable, body: `<html>Service Unavailable</html>`, want: true},
+		{name: "maintenance error code", status: http.StatusInternalServerError,
+			body: `{"error": 500, "errorCode": "CLUSTER_UNDER_MAINTENANCE", "detail": "Cluster is under maintenance."}`, want: true},
+		{name: "other server error", status: http.StatusInternalServerError, body: `{"error": 500, "errorCode": "UNEXPECTED_ERROR"}`},
+		{name: "client error", status: http.StatusBadRequest, body: `{"error": 400, "errorCode": "INVALID_ATTRIBUTE"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mongoAdmin, err := admin.NewClient(
+				admin.UseBaseURL("https://atlas.fake.invalid"),
+				admin.UseHTTPClient(&http.Client{Transport: atlasErrorTransport{status: tt.status, body: tt.body}}),
+			)
+			if err != nil {
+				t.Fatalf("failed to create Atlas client: %v", err)
+			}
+			_, resp, err := mongoAdmin.DatabaseUsersApi.GetDatabaseUser(context.Background(), testProjectId, "admin", "app").Execute()
+			if got := IsAtlasMaintenance(err, resp); got != tt.want {
+				t.Errorf("IsAtlasMaintenance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if IsAtlasMaintenance(nil, &http.Response{StatusCode: http.StatusServiceUnavailable}) {
+		t.Errorf("IsAtlasMaintenance(nil) = true, want false")
+	}
+}
+
+func TestSetSecretAtlasMaintenance(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []int
+		wantErr  bool
+		// maintenance is whether the error must wrap ErrAtlasMaintenance
+		maintenance bool
+	}{
+		{name: "unavailable once", failures: []int{http.StatusServiceUnavailable}},
+		{name: "unavailable until the retries are exhausted", failures: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}, wantErr: true, maintenance: true},
+		{name: "other failure not retried", failures: []int{http.StatusBadRequest}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING", "DEFAULT_AUTH_DATABASE")
+			setEnv(t, map[string]string{"RETRY_MAX_ATTEMPTS": "3", "RETRY_BASE_DELAY_MS": "1"})
+			smClient, atlas, mongoAdmin := newRotationFakes(t, nil, nil)
+			for _, status := range tt.failures {
+				atlas.FailNext("UpdateDatabaseUser", status)
+			}
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := errors.Is(err, ErrAtlasMaintenance); got != tt.maintenance {
+				t.Errorf("errors.Is(err, ErrAtlasMaintenance) = %v, want %v: %v", got, tt.maintenance, err)
+			}
+			if tt.maintenance && !strings.Contains(err.Error(), "retried on schedule") {
+				t.Errorf("SetSecret() error = %v, want the rotation schedule guidance", err)
+			}
+			want := "new-password"
+			if tt.wantErr {
+				want = ""
+			}
+			if user, _ := atlas.User(testProjectId, "admin", "app"); user.GetPassword() != want {
+				t.Errorf("user password = %q, want %q", user.GetPassword(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// budget.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRetryBudgetExhausted
+//
+// The retries of the invocation used up the RETRY_BUDGET_SECONDS or RETRY_BUDGET_ATTEMPTS budget
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudget
+//
+// Total retry waits and attempts allowed to all the retry loops of an invocation
+//
+//	Each retry loop alone is bounded, but a step going through several of them (Secrets Manager reads, Atlas
+//	maintenance, user propagation) could add up past the Lambda timeout. The budget is shared by all of them, once it
+//	is spent no loop retries anymore and the last error is returned. A zero limit is unlimited.
+type RetryBudget struct {
+	mu          sync.Mutex
+	maxWait     time.Duration
+	maxAttempts int
+	waited      time.Duration
+	attempts    int
+}
+
+// retryBudgetKey is the context key of the RetryBudget of the invocation
+type retryBudgetKey struct{}
+
+// WithRetryBudget
+//
+// Attach a new retry budget to the invocation context
+//
+//	Supported environment variables:
+//	    - RETRY_BUDGET_SECONDS: total time the retry loops may wait, default 0 (unlimited)
+//	    - RETRY_BUDGET_ATTEMPTS: total retries of the retry loops, default 0 (unlimited)
+//
+//	Returns:
+//	    context.Context: The context carrying the budget
+func WithRetryBudget(ctx context.Context) context.Context {
+	budget := &RetryBudget{
+		maxWait:     settings.RetryBudget,
+		maxAttempts: settings.RetryBudgetAttempts,
+	}
+	return context.WithValue(ctx, retryBudgetKey{}, budget)
+}
+
+// Spend
+//
+// Take one retry waiting the given duration from the budget
+//
+//	Args:
+//	    wait (time.Duration): The wait before the retry
+//
+//	Returns:
+//	    bool: False, and nothing is taken, when the retry would exceed the budget
+func (b *RetryBudget) Spend(wait time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.maxAttempts > 0 && b.attempts >= b.maxAttempts {
+		return false
+	}
+	if b.maxWait > 0 && b.waited+wait > b.maxWait {
+		return false
+	}
+	b.attempts++
+	b.waited += wait
+	return true
+}
+
+// Take
+//
+// Take a wait that is not a retry from the budget, capped at the wait time left
+//
+//	Fixed delays such as the USER_SETTLE_SECONDS fallback still use up the invocation time the budget bounds. They
+//	don't count as an attempt and are shortened instead of refused once the budget is spent.
+//
+//	Args:
+//	    wait (time.Duration): The wait requested
+//
+//	Returns:
+//	    time.Duration: The wait granted, at most the wait time left in the budget
+func (b *RetryBudget) Take(wait time.Duration) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.maxWait > 0 {
+		wait = max(min(wait, b.maxWait-b.waited), 0)
+	}
+	b.waited += wait
+	return wait
+}
+
+// budgetWait takes a wait that is not a retry from the budget of the context, when there is one, see Take.
+func budgetWait(ctx context.Context, wait time.Duration) time.Duration {
+	if budget, ok := ctx.Value(retryBudgetKey{}).(*RetryBudget); ok {
+		return budget.Take(wait)
+	}
+	return wait
+}
+
+// spendRetry takes a retry waiting the given duration from the budget of the context, when there is one.
+func spendRetry(ctx context.Context, wait time.Duration) error {
+	if budget, ok := ctx.Value(retryBudgetKey{}).(*RetryBudget); ok && !budget.Spend(wait) {
+		return ErrRetryBudgetExhausted
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// budget_test.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+func TestRetryBudgetSpend(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxWait     time.Duration
+		maxAttempts int
+		waits       []time.Duration
+		want        []bool
+	}{
+		{name: "unlimited", waits: []time.Duration{time.Minute, time.Minute, time.Minute}, want: []bool{true, true, true}},
+		{name: "attempts", maxAttempts: 2, waits: []time.Duration{time.Second, time.Second, time.Second}, want: []bool{true, true, false}},
+		{name: "wait", maxWait: 3 * time.Second, waits: []time.Duration{2 * time.Second, 2 * time.Second, time.Second}, want: []bool{true, false, true}},
+		{name: "wait exactly spent", maxWait: 2 * time.Second, waits: []time.Duration{time.Second, time.Second, time.Millisecond}, want: []bool{true, true, false}},
+		{name: "both limits", maxWait: 10 * time.Second, maxAttempts: 1, waits: []time.Duration{time.Second, time.Second}, want: []bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := &RetryBudget{maxWait: tt.maxWait, maxAttempts: tt.maxAttempts}
+			var got []bool
+			for _, wait := range tt.waits {
+				got = append(got, budget.Spend(wait))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Spend() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetTake(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxWait time.Duration
+		waits   []time.Duration
+		want    []time.Duration
+	}{
+		{name: "unlimited", waits: []time.Duration{time.Minute, time.Minute}, want: []time.Duration{time.Minute, time.Minute}},
+		{name: "capped at the wait left", maxWait: 15 * time.Second, waits: []time.Duration{10 * time.Second, 10 * time.Second, time.Second},
+			want: []time.Duration{10 * time.Second, 5 * time.Second, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := &RetryBudget{maxWait: tt.maxWait, maxAttempts: 1}
+			var got []time.Duration
+			for _, wait := range tt.waits {
+				got = append(got, budget.Take(wait))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Take() = %v, want %v", got, tt.want)
+			}
+			// The waits taken are charged to the retries, but don't count as attempts
+			if !budget.Spend(0) || (tt.maxWait > 0 && budget.Spend(time.Millisecond)) {
+				t.Errorf("Spend() after Take() = %+v, want the attempt left and no wait time", budget)
+			}
+		})
+	}
+}
+
+func TestWaitForUserChangesAppliedSettleBudget(t *testing.T) {
+	unsetEnv(t, "RETRY_BUDGET_ATTEMPTS")
+	setEnv(t, map[string]string{"USER_SETTLE_SECONDS": "30", "RETRY_BUDGET_SECONDS": "2"})
+	atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, seedClusters)
+	atlas.FailNext("ListClusters", http.StatusInternalServerError)
+	ctx := WithRetryBudget(context.Background())
+	if err := spendRetry(ctx, 2*time.Second); err != nil {
+		t.Fatalf("spendRetry() error = %v", err)
+	}
+	logs := captureLog(t)
+	start := time.Now()
+
+	if err := WaitForUserChangesApplied(ctx, mongoAdmin, testProjectId, ""); err != nil {
+		t.Fatalf("WaitForUserChangesApplied() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second || !strings.Contains(logs.String(), "waiting 0s") {
+		t.Errorf("WaitForUserChangesApplied() waited %v and logged %q, want no settle delay past the retry budget", elapsed, logs.String())
+	}
+}
+
+func TestWithRetryBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		spends  int
+		wantErr bool
+	}{
+		{name: "no budget in context", spends: 5},
+		{name: "unlimited", env: map[string]string{"RETRY_BUDGET_SECONDS": "0", "RETRY_BUDGET_ATTEMPTS": "0"}, spends: 5},
+		{name: "attempts spent", env: map[string]string{"RETRY_BUDGET_ATTEMPTS": "4"}, spends: 5, wantErr: true},
+		{name: "seconds spent", env: map[string]string{"RETRY_BUDGET_SECONDS": "1"}, spends: 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "RETRY_BUDGET_SECONDS", "RETRY_BUDGET_ATTEMPTS")
+			setEnv(t, tt.env)
+			ctx := context.Background()
+			if tt.env != nil {
+				ctx = WithRetryBudget(ctx)
+			}
+			var err error
+			for range tt.spends {
+				if err = spendRetry(ctx, 300*time.Millisecond); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("spendRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrRetryBudgetExhausted) {
+				t.Errorf("spendRetry() error = %v, want ErrRetryBudgetExhausted", err)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetSharedAcrossLoops(t *testing.T) {
+	retryable := errors.New("throttled")
+	tests := []struct {
+		name string
+		// budget is RETRY_BUDGET_ATTEMPTS
+		budget int
+		// wantBackoffRetries and wantUserPolls are the retries done by each loop
+		wantBackoffRetries int
+		wantUserPolls      int
+		wantExhausted      bool
+	}{
+		{name: "budget spent by the first loop", budget: 2, wantBackoffRetries: 2, wantExhausted: true},
+		{name: "budget left for the second loop", budget: 4, wantBackoffRetries: 3, wantUserPolls: 1, wantExhausted: true},
+		{name: "budget covering both loops", budget: 10, wantBackoffRetries: 3, wantUserPolls: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fastUserChangePolls(t)
+			setEnv(t, map[string]string{"RETRY_BUDGET_ATTEMPTS": strconv.Itoa(tt.budget), "RETRY_BUDGET_SECONDS": "0", "RETRY_MAX_ATTEMPTS": "4",
+				"RETRY_BASE_DELAY_MS": "1", "USER_ACTIVE_TIMEOUT_SECONDS": "60"})
+			atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, seedClusters)
+			atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: "admin", Username: "app"})
+			for range 5 {
+				atlas.FailNext("GetDatabaseUser", http.StatusNotFound)
+			}
+			ctx := WithRetryBudget(context.Background())
+			logs := captureLog(t)
+
+			// Both loops of the invocation take their retries from the same budget
+			_ = RetryWithBackoff(ctx, "GetSecretValue", func(error) bool { return true }, func() error { return retryable })
+			err := ConfirmUserUpdated(ctx, mongoAdmin, testProjectId, "admin", "app")
+			if errors.Is(err, ErrRetryBudgetExhausted) != tt.wantExhausted {
+				t.Errorf("ConfirmUserUpdated() error = %v, want ErrRetryBudgetExhausted %v", err, tt.wantExhausted)
+			}
+			backoffRetries := strings.Count(logs.String(), "failed with retryable error, retrying")
+			userPolls := strings.Count(logs.String(), "not returned yet by project")
+			if backoffRetries != tt.wantBackoffRetries || userPolls != tt.wantUserPolls {
+				t.Errorf("retries = %d and %d, want %d and %d", backoffRetries, userPolls, tt.wantBackoffRetries, tt.wantUserPolls)
+			}
+			if backoffRetries+userPolls > tt.budget {
+				t.Errorf("combined retries = %d, want at most the budget of %d", backoffRetries+userPolls, tt.budget)
+			}
+		})
+	}
+}
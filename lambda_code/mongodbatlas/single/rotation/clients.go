@@ -0,0 +1,109 @@
+// clients.go
+package rotation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// cachedAtlasClient is an Atlas API client created from a per-secret API key
+type cachedAtlasClient struct {
+	client    *admin.APIClient
+	publicKey string
+	expires   time.Time
+}
+
+var (
+	atlasClientsMu sync.Mutex
+	atlasClients   = map[string]cachedAtlasClient{}
+)
+
+// GetAtlasClientForSecret
+//
+// Get the MongoDB Atlas API client for the rotated secret
+//
+//	Secrets of different Atlas organizations can name the secret holding their API key in the
+//	'atlas_api_key_secret' field, one of ATLAS_API_KEY_SECRETS, otherwise the MONGODB_ATLAS_SECRET_NAME API key is
+//	used. Per-secret clients are cached for ATLAS_CLIENT_CACHE_TTL_SECONDS (default 300), so a rotated API key is
+//	picked up after the TTL. Called once the secret is described and checked enabled for rotation.
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    arn (string): The rotated secret ARN or other identifier
+//
+//	Returns:
+//	    admin.APIClient: MongoDB Atlas API client
+//	    error: Error if the API key secret could not be determined or the MongoDB Atlas API client initialized
+func GetAtlasClientForSecret(ctx context.Context, smClient SecretsManagerAPI, arn string) (*admin.APIClient, error) {
+	keySecretName, err := GetApiKeySecretName(ctx, smClient, arn)
+	if err != nil {
+		return nil, err
+	}
+	if keySecretName == "" {
+		return GetAtlasClient()
+	}
+
+	atlasClientsMu.Lock()
+	defer atlasClientsMu.Unlock()
+	if cached, ok := atlasClients[keySecretName]; ok && time.Now().Before(cached.expires) {
+		return cached.client, nil
+	}
+	mongoAdmin, publicKey, err := NewAtlasClientFromSecret(ctx, smClient, keySecretName)
+	if err != nil {
+		return nil, err
+	}
+	ttl := settings.AtlasClientCacheTTL
+	atlasClients[keySecretName] = cachedAtlasClient{
+		client:    mongoAdmin,
+		publicKey: publicKey,
+		expires:   time.Now().Add(ttl),
+	}
+	log.Printf("MongoDB Atlas API client initialized successfully with API key from %v", keySecretName)
+	return mongoAdmin, nil
+}
+
+// GetApiKeySecretName
+//
+// Get the name of the secret holding the Atlas API key for the rotated secret
+//
+//	The secret is only read when ATLAS_API_KEY_SECRETS is set. Its 'atlas_api_key_secret' field must then be empty
+//	or one of ATLAS_API_KEY_SECRETS, the secrets the module grants the function read access to, so a secret can't
+//	make the function use the API key of any other secret.
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    arn (string): The rotated secret ARN or other identifier
+//
+//	Returns:
+//	    string: The 'atlas_api_key_secret' field of the secret, empty when not set or ATLAS_API_KEY_SECRETS is not set
+//	    error: Error if the secret could not be read or names a secret not listed in ATLAS_API_KEY_SECRETS
+func GetApiKeySecretName(ctx context.Context, smClient SecretsManagerAPI, arn string) (string, error) {
+	if len(settings.AtlasApiKeySecrets) == 0 {
+		return "", nil
+	}
+	secretString, err := GetSecretString(ctx, smClient, RotationConfig{
+		arn:   &arn,
+		stage: "AWSCURRENT",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read atlas_api_key_secret of %v: %w", arn, err)
+	}
+	secretDict, err := ParseSecretDict(secretString)
+	if err != nil {
+		return "", fmt.Errorf("failed to read atlas_api_key_secret of %v: %w", arn, err)
+	}
+	keySecretName := strings.TrimSpace(secretDict["atlas_api_key_secret"])
+	if keySecretName != "" && !slices.Contains(settings.AtlasApiKeySecrets, keySecretName) {
+		return "", fmt.Errorf("atlas_api_key_secret %q of %v is not listed in ATLAS_API_KEY_SECRETS", keySecretName, arn)
+	}
+	return keySecretName, nil
+}
@@ -0,0 +1,145 @@
+// clients_test.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+// apiKeySecret is an Atlas API key credentials secret with the given public key.
+func apiKeySecret(t *testing.T, publicKey string) string {
+	t.Helper()
+	secretString, err := json.Marshal(map[string]string{"public_key": publicKey, "private_key": "private-" + publicKey})
+	if err != nil {
+		t.Fatalf("failed to marshal secret: %v", err)
+	}
+	return string(secretString)
+}
+
+// resetAtlasClients empties the per-secret client cache before and after the test.
+func resetAtlasClients(t *testing.T) {
+	t.Helper()
+	atlasClientsMu.Lock()
+	clear(atlasClients)
+	atlasClientsMu.Unlock()
+	t.Cleanup(func() {
+		atlasClientsMu.Lock()
+		clear(atlasClients)
+		atlasClientsMu.Unlock()
+	})
+}
+
+// cachedPublicKey returns the public key of the cached client for the given API key secret.
+func cachedPublicKey(keySecretName string) string {
+	atlasClientsMu.Lock()
+	defer atlasClientsMu.Unlock()
+	return atlasClients[keySecretName].publicKey
+}
+
+func TestGetAtlasClientForSecret(t *testing.T) {
+	const otherSecretArn = "arn:aws:secretsmanager:us-east-1:123456789012:secret:other-org-user"
+	tests := []struct {
+		name          string
+		arn           string
+		keySecret     string
+		ttl           string
+		rotateKey     bool
+		wantPublicKey string
+		wantSecondKey string
+	}{
+		{name: "secret api key", arn: testSecretArn, keySecret: "org1-api-key", ttl: "300", wantPublicKey: "org1key", wantSecondKey: "org1key"},
+		{name: "other organization", arn: otherSecretArn, keySecret: "org2-api-key", ttl: "300", wantPublicKey: "org2key", wantSecondKey: "org2key"},
+		{name: "cached until ttl", arn: testSecretArn, keySecret: "org1-api-key", ttl: "300", rotateKey: true, wantPublicKey: "org1key", wantSecondKey: "org1key"},
+		{name: "expired cache", arn: testSecretArn, keySecret: "org1-api-key", ttl: "0", rotateKey: true, wantPublicKey: "org1key", wantSecondKey: "rotatedkey"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetAtlasClients(t)
+			setEnv(t, map[string]string{"ATLAS_CLIENT_CACHE_TTL_SECONDS": tt.ttl, "ATLAS_API_KEY_SECRETS": "org1-api-key, org2-api-key"})
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret("org1-api-key", testCurrentToken, apiKeySecret(t, "org1key"))
+			smClient.AddSecret("org2-api-key", testCurrentToken, apiKeySecret(t, "org2key"))
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", map[string]string{"atlas_api_key_secret": "org1-api-key"}))
+			smClient.AddSecret(otherSecretArn, testCurrentToken, atlasSecret(t, "old-password", map[string]string{"atlas_api_key_secret": "org2-api-key"}))
+
+			mongoAdmin, err := GetAtlasClientForSecret(context.Background(), smClient, tt.arn)
+			if err != nil {
+				t.Fatalf("GetAtlasClientForSecret() error = %v", err)
+			}
+			if publicKey := cachedPublicKey(tt.keySecret); mongoAdmin == nil || publicKey != tt.wantPublicKey {
+				t.Fatalf("GetAtlasClientForSecret() public key = %q, want %q", publicKey, tt.wantPublicKey)
+			}
+			if tt.rotateKey {
+				smClient.PutVersion("org1-api-key", testPendingToken, apiKeySecret(t, "rotatedkey"), "AWSCURRENT")
+			}
+			if _, err = GetAtlasClientForSecret(context.Background(), smClient, tt.arn); err != nil {
+				t.Fatalf("GetAtlasClientForSecret() second call error = %v", err)
+			}
+			if publicKey := cachedPublicKey(tt.keySecret); publicKey != tt.wantSecondKey {
+				t.Errorf("GetAtlasClientForSecret() second call public key = %q, want %q", publicKey, tt.wantSecondKey)
+			}
+		})
+	}
+}
+
+func TestGetAtlasClientForSecretWithoutApiKey(t *testing.T) {
+	resetAtlasClients(t)
+	unsetEnv(t, "MONGODB_ATLAS_SECRET_NAME", "WARMUP_CLIENTS")
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+
+	_, err := GetAtlasClientForSecret(context.Background(), smClient, testSecretArn)
+	if err == nil || !strings.Contains(err.Error(), "atlas_api_key_secret") {
+		t.Errorf("GetAtlasClientForSecret() error = %v, want an error naming atlas_api_key_secret", err)
+	}
+}
+
+func TestGetApiKeySecretName(t *testing.T) {
+	tests := []struct {
+		name string
+		// allowed is ATLAS_API_KEY_SECRETS
+		allowed string
+		// field is the 'atlas_api_key_secret' of the rotated secret
+		field    string
+		failRead bool
+		want     string
+		wantErr  string
+	}{
+		// The secret is not read, the read failure is not reported
+		{name: "per-secret keys disabled", field: "org1-api-key", failRead: true, want: ""},
+		{name: "listed secret", allowed: "org1-api-key,org2-api-key", field: " org2-api-key ", want: "org2-api-key"},
+		{name: "no field", allowed: "org1-api-key", want: ""},
+		{name: "unlisted secret", allowed: "org1-api-key", field: "other-api-key", wantErr: "not listed in ATLAS_API_KEY_SECRETS"},
+		{name: "unreadable secret", allowed: "org1-api-key", field: "org1-api-key", failRead: true, wantErr: "failed to read atlas_api_key_secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "ATLAS_API_KEY_SECRETS")
+			setEnv(t, map[string]string{"ATLAS_API_KEY_SECRETS": tt.allowed})
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", map[string]string{"atlas_api_key_secret": tt.field}))
+			if tt.failRead {
+				smClient.FailNext("GetSecretValue", &types.InternalServiceError{Message: aws.String("internal error")})
+			}
+
+			got, err := GetApiKeySecretName(context.Background(), smClient, testSecretArn)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GetApiKeySecretName() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("GetApiKeySecretName() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetApiKeySecretName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
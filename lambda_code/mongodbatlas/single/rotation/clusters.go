@@ -0,0 +1,110 @@
+// clusters.go
+package rotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// GetClusters
+//
+// Get the per-cluster connection strings listed in the secret
+//
+//	The optional 'clusters' field holds a JSON object keyed by cluster name, each value an object with any of the
+//	'connection_string', 'connection_string_srv', 'private_connection_string' and 'private_connection_string_srv'
+//	fields of that cluster, for projects with several clusters.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    map[string]map[string]string: The connection strings by cluster name, empty if the field is not present
+//	    error: Error if the field could not be parsed
+func GetClusters(secretDict map[string]string) (map[string]map[string]string, error) {
+	clustersJson, ok := secretDict["clusters"]
+	if !ok || strings.TrimSpace(clustersJson) == "" {
+		return nil, nil
+	}
+	var clusters map[string]map[string]string
+	if err := json.Unmarshal([]byte(clustersJson), &clusters); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal clusters: %w", err)
+	}
+	return clusters, nil
+}
+
+// GenerateClustersConnectionStrings
+//
+// Regenerate the connection strings of every listed cluster with the new password
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary, the 'clusters' field is updated in place
+//
+//	    password (string): The password to use for the connection strings
+//
+//	Returns:
+//	    error: Error if the clusters could not be parsed or a connection string could not be generated
+func GenerateClustersConnectionStrings(secretDict map[string]string, password string) error {
+	clusters, err := GetClusters(secretDict)
+	if err != nil || len(clusters) == 0 {
+		return err
+	}
+	for name, cluster := range clusters {
+		cluster["username"] = secretDict["username"]
+		if _, err = GenerateConnectionStrings(cluster, password); err != nil {
+			return fmt.Errorf("cluster %v: %w", name, err)
+		}
+		delete(cluster, "username")
+	}
+	clustersJson, err := json.Marshal(clusters)
+	if err != nil {
+		return fmt.Errorf("failed to marshal clusters: %w", err)
+	}
+	secretDict["clusters"] = string(clustersJson)
+	return nil
+}
+
+// SelectClusterConnectionStrings
+//
+// Get the secret with the connection strings of the target cluster
+//
+//	When the secret lists 'clusters', the connection strings of the cluster named by 'cluster_name' (or of the only
+//	listed cluster) replace the top level connection strings, so the test connection reaches the target cluster.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    map[string]string: A copy of the secret with the cluster connection strings, or the secret itself when it
+//	                       lists no clusters
+//	    error: Error if the clusters could not be parsed or the target cluster is not listed
+func SelectClusterConnectionStrings(secretDict map[string]string) (map[string]string, error) {
+	clusters, err := GetClusters(secretDict)
+	if err != nil || len(clusters) == 0 {
+		return secretDict, err
+	}
+	clusterName := secretDict["cluster_name"]
+	if clusterName == "" {
+		if len(clusters) > 1 {
+			names := slices.Sorted(maps.Keys(clusters))
+			return nil, fmt.Errorf("secret lists several clusters (%v), set cluster_name to select one", strings.Join(names, ", "))
+		}
+		for name := range clusters {
+			clusterName = name
+		}
+	}
+	cluster, ok := clusters[clusterName]
+	if !ok {
+		return nil, fmt.Errorf("cluster %v is not listed in clusters", clusterName)
+	}
+	selected := maps.Clone(secretDict)
+	for _, key := range connectionStringKeys {
+		delete(selected, key)
+		if cluster[key] != "" {
+			selected[key] = cluster[key]
+		}
+	}
+	return selected, nil
+}
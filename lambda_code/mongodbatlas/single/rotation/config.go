@@ -0,0 +1,453 @@
+// config.go
+package rotation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Config
+//
+// The environment configuration of the function, loaded and validated once by LoadConfig
+//
+//	Each field is read from the environment variable named in its comment. A variable that is unset or empty takes
+//	the default of the field.
+type Config struct {
+	// MONGODB_ATLAS_SECRET_NAME, the Atlas API key secret of the secrets without 'atlas_api_key_secret'
+	AtlasSecretName string
+	// ATLAS_API_KEY_SECRETS, the secrets 'atlas_api_key_secret' may name, none to use AtlasSecretName for every secret
+	AtlasApiKeySecrets []string
+	// ATLAS_BASE_URL, empty for the SDK default
+	AtlasBaseURL string
+	// ATLAS_CLIENT_CACHE_TTL_SECONDS, default 300
+	AtlasClientCacheTTL time.Duration
+	// ATLAS_RATE_LIMIT_PACING
+	AtlasRateLimitPacing bool
+	// ATLAS_RATE_LIMIT_MIN_REMAINING, default defaultRateLimitMinRemaining
+	AtlasRateLimitMinRemaining int
+	// ATLAS_RATE_LIMIT_DELAY_MS, default defaultRateLimitDelayMs
+	AtlasRateLimitDelay time.Duration
+	// USER_SETTLE_SECONDS, default 10
+	UserSettle time.Duration
+	// USER_ACTIVE_TIMEOUT_SECONDS, default 60
+	UserActiveTimeout time.Duration
+	// PROPAGATION_TIMEOUT_SECONDS, default 60
+	PropagationTimeout time.Duration
+	// WAIT_FOR_USER_ACTIVE
+	WaitForUserActive bool
+	// VERIFY_PROJECT_NAME
+	VerifyProjectName bool
+	// CREATE_USER_IF_MISSING
+	CreateUserIfMissing bool
+	// EXPECTED_ROLES_STRICT
+	ExpectedRolesStrict bool
+
+	// DEFAULT_ENGINE, lower case
+	DefaultEngine string
+	// DEFAULT_AUTH_DATABASE, empty for "admin"
+	DefaultAuthDatabase string
+	// FIELD_NAME_MAP, nil when unset
+	FieldNameMap map[string]string
+	// LAST_ROTATED_FIELD
+	LastRotatedField string
+	// DERIVED_TOKEN_FIELD
+	DerivedTokenField string
+	// DERIVED_TOKEN_MODE, random (default) or hmac
+	DerivedTokenMode string
+	// DERIVED_TOKEN_HMAC_KEY
+	DerivedTokenHmacKey string
+	// LOGGABLE_FIELDS
+	LoggableFields []string
+
+	// PASSWORD_LENGTH, default 32
+	PasswordLength int
+	// PASSWORD_PREFIX
+	PasswordPrefix string
+	// PASSWORD_SUFFIX
+	PasswordSuffix string
+	// EXCLUDE_CHARACTERS, nil for the default set
+	ExcludeCharacters *string
+	// EXCLUDE_CHARACTERS_BY_ENGINE, default set depending on the engine instead of the strict set for all of them
+	ExcludeCharactersByEngine bool
+	// EXCLUDE_NUMBERS
+	ExcludeNumbers bool
+	// EXCLUDE_PUNCTUATION
+	ExcludePunctuation bool
+	// EXCLUDE_UPPERCASE
+	ExcludeUppercase bool
+	// EXCLUDE_LOWERCASE
+	ExcludeLowercase bool
+	// REQUIRE_EACH_INCLUDED_TYPE
+	RequireEachIncludedType bool
+	// PASSWORD_MAX_ATTEMPTS, default maxPasswordAttempts
+	PasswordMaxAttempts int
+	// PASSWORD_MIN_UPPERCASE
+	PasswordMinUppercase int
+	// PASSWORD_MIN_LOWERCASE
+	PasswordMinLowercase int
+	// PASSWORD_MIN_DIGITS
+	PasswordMinDigits int
+	// PASSWORD_MIN_SYMBOLS
+	PasswordMinSymbols int
+	// PASSWORD_HISTORY_SIZE
+	PasswordHistorySize int
+
+	// ENABLED_STEPS, nil when every step is enabled
+	EnabledSteps []string
+	// PRECHECK_CURRENT
+	PrecheckCurrent bool
+	// CLEANUP_STALE_PENDING
+	CleanupStalePending bool
+	// KEEP_PREVIOUS_PASSWORD
+	KeepPreviousPassword bool
+	// STRICT_CONNECTION_STRINGS, default true
+	StrictConnectionStrings bool
+	// MASTER_SECRET_ARN
+	MasterSecretArn string
+	// TLS_CA_SECRETS, the secrets a 'tls_ca' of 'secretsmanager:<secret>' may reference
+	TlsCaSecrets []string
+	// SKIP_TEST_SECRET
+	SkipTestSecret bool
+	// USE_EPHEMERAL_TEST_USER
+	UseEphemeralTestUser bool
+	// ENABLE_ROLLBACK
+	EnableRollback bool
+	// VERIFY_OLD_REVOKED
+	VerifyOldRevoked bool
+	// VERIFY_OLD_REVOKED_STRICT
+	VerifyOldRevokedStrict bool
+	// FINISH_DELAY_SECONDS
+	FinishDelay time.Duration
+	// ALLOW_ROTATION_WHEN_UNKNOWN
+	AllowRotationWhenUnknown bool
+	// ROTATION_LOCK
+	RotationLock bool
+	// ROTATION_LOCK_TTL_SECONDS, default defaultRotationLockTtlSeconds
+	RotationLockTTL time.Duration
+	// RECONCILE_MIN_AGE_SECONDS, default defaultReconcileMinAgeSeconds
+	ReconcileMinAge time.Duration
+
+	// CONNECTION_PRIORITY, default defaultConnectionPriority
+	ConnectionPriority []string
+	// CONNECTION_OPTIONS_OVERRIDE
+	ConnectionOptionsOverride bool
+	// CONNECT_TIMEOUT_SECONDS, default 5
+	ConnectTimeout time.Duration
+	// MONGODB_APP_NAME, default defaultAppName
+	AppName string
+	// WARM_TEST_CONNECTION
+	WarmTestConnection bool
+	// CONNECTION_METRICS
+	ConnectionMetrics bool
+	// WARMUP_CLIENTS
+	WarmupClients bool
+
+	// RETRY_MAX_ATTEMPTS, default defaultRetryMaxAttempts
+	RetryMaxAttempts int
+	// RETRY_BASE_DELAY_MS, default defaultRetryBaseDelayMs
+	RetryBaseDelay time.Duration
+	// RETRY_BUDGET_SECONDS, 0 for no limit
+	RetryBudget time.Duration
+	// RETRY_BUDGET_ATTEMPTS, 0 for no limit
+	RetryBudgetAttempts int
+	// AWS_RETRY_MODE, empty for the SDK default
+	AWSRetryMode aws.RetryMode
+	// AWS_MAX_ATTEMPTS, 0 for the SDK default
+	AWSMaxAttempts int
+
+	// RESULT_LOG
+	ResultLog bool
+	// DEBUG_EVENT
+	DebugEvent bool
+	// SLOW_STEP_THRESHOLD_SECONDS, 0 for no threshold
+	SlowStepThreshold time.Duration
+	// METRICS_NAMESPACE, default SecretsRotation
+	MetricsNamespace string
+	// PUBLISH_VERSION_PARAMETER
+	PublishVersionParameter string
+	// EVENT_BUS_NAME
+	EventBusName string
+}
+
+var (
+	// settings is the configuration of the function, loaded by loadSettings at init
+	settings Config
+	// configErr is the error of the configuration loaded by loadSettings, nil when it is valid
+	configErr error
+)
+
+// configLoader reads the environment variables of LoadConfig, collecting every problem found.
+type configLoader struct {
+	errs []error
+}
+
+// lookup returns the value of the variable, ok is false when it is unset or empty.
+func (l *configLoader) lookup(name string) (string, bool) {
+	value := os.Getenv(name)
+	return value, strings.TrimSpace(value) != ""
+}
+
+// string returns the trimmed value of the variable, defaultValue when it is unset or empty.
+func (l *configLoader) string(name string, defaultValue string) string {
+	if value, ok := l.lookup(name); ok {
+		return strings.TrimSpace(value)
+	}
+	return defaultValue
+}
+
+// list returns the trimmed non-empty items of the comma separated variable, nil when it is unset or empty.
+func (l *configLoader) list(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// bool returns the variable as a boolean, defaultValue when it is unset or empty. Only true, t, 1, yes and y read as
+// true, any other value reads as false, with a warning when it isn't false, f, 0, no or n.
+func (l *configLoader) bool(name string, defaultValue bool) bool {
+	value, ok := l.lookup(name)
+	if !ok {
+		return defaultValue
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "t", "1", "yes", "y":
+		return true
+	case "false", "f", "0", "no", "n":
+		return false
+	}
+	l.warn("%v %q is not a boolean, read as false", name, value)
+	return false
+}
+
+// int returns the variable as an integer, defaultValue when it is unset, empty, not an integer or below minimum.
+func (l *configLoader) int(name string, defaultValue int, minimum int) int {
+	value, ok := l.lookup(name)
+	if !ok {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%v %q is not an integer", name, value))
+		return defaultValue
+	}
+	if intValue < minimum {
+		l.errs = append(l.errs, fmt.Errorf("%v %d is below the minimum %d", name, intValue, minimum))
+		return defaultValue
+	}
+	return intValue
+}
+
+// duration returns the integer variable in the given unit, see int.
+func (l *configLoader) duration(name string, unit time.Duration, defaultValue int, minimum int) time.Duration {
+	return time.Duration(l.int(name, defaultValue, minimum)) * unit
+}
+
+// warn logs a value that is ignored without making the configuration invalid.
+func (l *configLoader) warn(format string, args ...any) {
+	log.Printf("WARNING: LoadConfig: "+format, args...)
+}
+
+// check records err as a problem of the configuration, if any.
+func (l *configLoader) check(err error) {
+	if err != nil {
+		l.errs = append(l.errs, err)
+	}
+}
+
+// LoadConfig
+//
+// Load and validate the environment configuration of the function
+//
+//	Every environment variable is read at once so a misconfiguration is reported when the function starts, instead
+//	of surfacing mid-rotation: integers must parse and must not be below their minimum, and PASSWORD_LENGTH,
+//	DEFAULT_ENGINE, ATLAS_BASE_URL, DERIVED_TOKEN_*, LAST_ROTATED_FIELD, FIELD_NAME_MAP, ENABLED_STEPS and AWS_* must
+//	hold supported values. A boolean that doesn't parse reads as false, an EXCLUDE_CHARACTERS Secrets Manager would
+//	refuse is replaced by the default set and unknown CONNECTION_PRIORITY keys are ignored, all with a warning.
+//	MONGODB_ATLAS_SECRET_NAME is optional as secrets may name their own API key secret in 'atlas_api_key_secret', a
+//	secret with neither fails when its Atlas client is created.
+//
+//	Returns:
+//	    Config: The configuration, the invalid values replaced by their default
+//	    error: Error joining every problem found, nil when the configuration is valid
+func LoadConfig() (Config, error) {
+	l := &configLoader{}
+	c := Config{
+		AtlasSecretName:            l.string("MONGODB_ATLAS_SECRET_NAME", ""),
+		AtlasApiKeySecrets:         l.list("ATLAS_API_KEY_SECRETS"),
+		AtlasClientCacheTTL:        l.duration("ATLAS_CLIENT_CACHE_TTL_SECONDS", time.Second, 300, 0),
+		AtlasRateLimitPacing:       l.bool("ATLAS_RATE_LIMIT_PACING", false),
+		AtlasRateLimitMinRemaining: l.int("ATLAS_RATE_LIMIT_MIN_REMAINING", defaultRateLimitMinRemaining, 0),
+		AtlasRateLimitDelay:        l.duration("ATLAS_RATE_LIMIT_DELAY_MS", time.Millisecond, defaultRateLimitDelayMs, 0),
+		UserSettle:                 l.duration("USER_SETTLE_SECONDS", time.Second, 10, 0),
+		UserActiveTimeout:          l.duration("USER_ACTIVE_TIMEOUT_SECONDS", time.Second, 60, 0),
+		PropagationTimeout:         l.duration("PROPAGATION_TIMEOUT_SECONDS", time.Second, 60, 0),
+		WaitForUserActive:          l.bool("WAIT_FOR_USER_ACTIVE", false),
+		VerifyProjectName:          l.bool("VERIFY_PROJECT_NAME", false),
+		CreateUserIfMissing:        l.bool("CREATE_USER_IF_MISSING", false),
+		ExpectedRolesStrict:        l.bool("EXPECTED_ROLES_STRICT", false),
+
+		DefaultEngine:       strings.ToLower(l.string("DEFAULT_ENGINE", "")),
+		DefaultAuthDatabase: l.string("DEFAULT_AUTH_DATABASE", ""),
+		LastRotatedField:    l.string("LAST_ROTATED_FIELD", ""),
+		DerivedTokenField:   l.string("DERIVED_TOKEN_FIELD", ""),
+		DerivedTokenMode:    strings.ToLower(l.string("DERIVED_TOKEN_MODE", "random")),
+		DerivedTokenHmacKey: os.Getenv("DERIVED_TOKEN_HMAC_KEY"),
+		LoggableFields:      l.list("LOGGABLE_FIELDS"),
+
+		PasswordLength:            l.int("PASSWORD_LENGTH", 32, 1),
+		PasswordPrefix:            os.Getenv("PASSWORD_PREFIX"),
+		PasswordSuffix:            os.Getenv("PASSWORD_SUFFIX"),
+		ExcludeCharactersByEngine: l.bool("EXCLUDE_CHARACTERS_BY_ENGINE", false),
+		ExcludeNumbers:            l.bool("EXCLUDE_NUMBERS", false),
+		ExcludePunctuation:        l.bool("EXCLUDE_PUNCTUATION", false),
+		ExcludeUppercase:          l.bool("EXCLUDE_UPPERCASE", false),
+		ExcludeLowercase:          l.bool("EXCLUDE_LOWERCASE", false),
+		RequireEachIncludedType:   l.bool("REQUIRE_EACH_INCLUDED_TYPE", false),
+		PasswordMaxAttempts:       l.int("PASSWORD_MAX_ATTEMPTS", maxPasswordAttempts, 1),
+		PasswordMinUppercase:      l.int("PASSWORD_MIN_UPPERCASE", 0, 0),
+		PasswordMinLowercase:      l.int("PASSWORD_MIN_LOWERCASE", 0, 0),
+		PasswordMinDigits:         l.int("PASSWORD_MIN_DIGITS", 0, 0),
+		PasswordMinSymbols:        l.int("PASSWORD_MIN_SYMBOLS", 0, 0),
+		PasswordHistorySize:       l.int("PASSWORD_HISTORY_SIZE", 0, 0),
+
+		EnabledSteps:             l.list("ENABLED_STEPS"),
+		PrecheckCurrent:          l.bool("PRECHECK_CURRENT", false),
+		CleanupStalePending:      l.bool("CLEANUP_STALE_PENDING", false),
+		KeepPreviousPassword:     l.bool("KEEP_PREVIOUS_PASSWORD", false),
+		StrictConnectionStrings:  l.bool("STRICT_CONNECTION_STRINGS", true),
+		MasterSecretArn:          l.string("MASTER_SECRET_ARN", ""),
+		TlsCaSecrets:             l.list("TLS_CA_SECRETS"),
+		SkipTestSecret:           l.bool("SKIP_TEST_SECRET", false),
+		UseEphemeralTestUser:     l.bool("USE_EPHEMERAL_TEST_USER", false),
+		EnableRollback:           l.bool("ENABLE_ROLLBACK", false),
+		VerifyOldRevoked:         l.bool("VERIFY_OLD_REVOKED", false),
+		VerifyOldRevokedStrict:   l.bool("VERIFY_OLD_REVOKED_STRICT", false),
+		FinishDelay:              l.duration("FINISH_DELAY_SECONDS", time.Second, 0, 0),
+		AllowRotationWhenUnknown: l.bool("ALLOW_ROTATION_WHEN_UNKNOWN", false),
+		RotationLock:             l.bool("ROTATION_LOCK", false),
+		RotationLockTTL:          l.duration("ROTATION_LOCK_TTL_SECONDS", time.Second, defaultRotationLockTtlSeconds, 1),
+		ReconcileMinAge:          l.duration("RECONCILE_MIN_AGE_SECONDS", time.Second, defaultReconcileMinAgeSeconds, 0),
+
+		ConnectionPriority:        l.list("CONNECTION_PRIORITY"),
+		ConnectionOptionsOverride: l.bool("CONNECTION_OPTIONS_OVERRIDE", false),
+		ConnectTimeout:            l.duration("CONNECT_TIMEOUT_SECONDS", time.Second, 5, 1),
+		AppName:                   l.string("MONGODB_APP_NAME", defaultAppName),
+		WarmTestConnection:        l.bool("WARM_TEST_CONNECTION", false),
+		ConnectionMetrics:         l.bool("CONNECTION_METRICS", false),
+		WarmupClients:             l.bool("WARMUP_CLIENTS", false),
+
+		RetryMaxAttempts:    l.int("RETRY_MAX_ATTEMPTS", defaultRetryMaxAttempts, 1),
+		RetryBaseDelay:      l.duration("RETRY_BASE_DELAY_MS", time.Millisecond, defaultRetryBaseDelayMs, 0),
+		RetryBudget:         l.duration("RETRY_BUDGET_SECONDS", time.Second, 0, 0),
+		RetryBudgetAttempts: l.int("RETRY_BUDGET_ATTEMPTS", 0, 0),
+		AWSMaxAttempts:      l.int("AWS_MAX_ATTEMPTS", 0, 1),
+
+		ResultLog:               l.bool("RESULT_LOG", false),
+		DebugEvent:              l.bool("DEBUG_EVENT", false),
+		SlowStepThreshold:       l.duration("SLOW_STEP_THRESHOLD_SECONDS", time.Second, 0, 0),
+		MetricsNamespace:        l.string("METRICS_NAMESPACE", "SecretsRotation"),
+		PublishVersionParameter: l.string("PUBLISH_VERSION_PARAMETER", ""),
+		EventBusName:            l.string("EVENT_BUS_NAME", ""),
+	}
+
+	var err error
+	c.AtlasBaseURL, err = GetAtlasBaseURL()
+	l.check(err)
+	c.FieldNameMap, err = GetFieldNameMap()
+	l.check(err)
+	if value, ok := l.lookup("AWS_RETRY_MODE"); ok {
+		c.AWSRetryMode, err = aws.ParseRetryMode(strings.TrimSpace(value))
+		if err != nil {
+			l.check(fmt.Errorf("AWS_RETRY_MODE %q must be standard or adaptive", value))
+		}
+	}
+	if affixes := utf8.RuneCountInString(c.PasswordPrefix) + utf8.RuneCountInString(c.PasswordSuffix); c.PasswordLength <= affixes {
+		l.check(fmt.Errorf("PASSWORD_LENGTH %d must be longer than PASSWORD_PREFIX and PASSWORD_SUFFIX combined", c.PasswordLength))
+	}
+	if excludeCharacters, ok := os.LookupEnv("EXCLUDE_CHARACTERS"); ok {
+		switch {
+		case !utf8.ValidString(excludeCharacters):
+			l.warn("EXCLUDE_CHARACTERS is not valid UTF-8, the default set is used")
+		case utf8.RuneCountInString(excludeCharacters) > maxExcludeCharactersLength:
+			l.warn("EXCLUDE_CHARACTERS is %d characters long, over the limit of %d, the default set is used",
+				utf8.RuneCountInString(excludeCharacters), maxExcludeCharactersLength)
+		default:
+			c.ExcludeCharacters = &excludeCharacters
+		}
+	}
+	if c.DefaultEngine != "" && !slices.Contains(supportedEngines, c.DefaultEngine) {
+		l.check(fmt.Errorf("DEFAULT_ENGINE %q is not one of %v", c.DefaultEngine, strings.Join(supportedEngines, ", ")))
+	}
+	switch c.DerivedTokenMode {
+	case "random":
+	case "hmac":
+		if c.DerivedTokenHmacKey == "" {
+			l.check(fmt.Errorf("DERIVED_TOKEN_HMAC_KEY is required with DERIVED_TOKEN_MODE hmac"))
+		}
+	default:
+		l.check(fmt.Errorf("DERIVED_TOKEN_MODE %q is not random or hmac", c.DerivedTokenMode))
+	}
+	for name, field := range map[string]string{"DERIVED_TOKEN_FIELD": c.DerivedTokenField, "LAST_ROTATED_FIELD": c.LastRotatedField} {
+		if field != "" && IsRotationField(field) {
+			l.check(fmt.Errorf("%v %q is a field used by the rotation", name, field))
+		}
+	}
+	steps := []string{"createSecret", "setSecret", "testSecret", "finishSecret", ReconcileStep}
+	for _, step := range c.EnabledSteps {
+		if !slices.ContainsFunc(steps, func(known string) bool { return strings.EqualFold(known, step) }) {
+			l.check(fmt.Errorf("ENABLED_STEPS lists unknown step %q", step))
+		}
+	}
+	var priority []string
+	for _, key := range c.ConnectionPriority {
+		if !slices.Contains(defaultConnectionPriority, key) {
+			l.warn("CONNECTION_PRIORITY lists unknown connection string key %q, ignored", key)
+		} else if !slices.Contains(priority, key) {
+			priority = append(priority, key)
+		}
+	}
+	c.ConnectionPriority = priority
+	if len(c.ConnectionPriority) == 0 {
+		c.ConnectionPriority = slices.Clone(defaultConnectionPriority)
+	}
+	return c, errors.Join(l.errs...)
+}
+
+// loadSettings
+//
+// Load the configuration of the function into settings, see LoadConfig
+//
+//	Called once at init, the configuration error is logged and kept for CheckConfig.
+func loadSettings() {
+	settings, configErr = LoadConfig()
+	if configErr != nil {
+		configErr = fmt.Errorf("invalid configuration: %w", configErr)
+		log.Printf("%v", configErr)
+	}
+}
+
+// CheckConfig
+//
+// Get the error of the configuration loaded at init, see LoadConfig
+//
+//	The function still starts with an invalid configuration, HandleRequest and ForceRotation return the error on
+//	every invocation so no rotation runs with it, and the self test reports it.
+//
+//	Returns:
+//	    error: The configuration error, nil when the configuration is valid
+func CheckConfig() error {
+	return configErr
+}
@@ -0,0 +1,193 @@
+// config_test.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configVariables are the environment variables the tests of LoadConfig set, unset before each of them.
+var configVariables = []string{
+	"MONGODB_ATLAS_SECRET_NAME", "ATLAS_API_KEY_SECRETS", "ATLAS_BASE_URL", "ROTATION_LOCK", "SKIP_TEST_SECRET", "STRICT_CONNECTION_STRINGS",
+	"RETRY_MAX_ATTEMPTS", "FINISH_DELAY_SECONDS", "CONNECT_TIMEOUT_SECONDS", "USER_ACTIVE_TIMEOUT_SECONDS",
+	"PASSWORD_LENGTH", "PASSWORD_PREFIX", "PASSWORD_SUFFIX", "EXCLUDE_CHARACTERS", "EXCLUDE_CHARACTERS_BY_ENGINE", "DEFAULT_ENGINE",
+	"DERIVED_TOKEN_FIELD", "DERIVED_TOKEN_MODE", "DERIVED_TOKEN_HMAC_KEY", "LAST_ROTATED_FIELD", "FIELD_NAME_MAP",
+	"ENABLED_STEPS", "CONNECTION_PRIORITY", "LOGGABLE_FIELDS", "TLS_CA_SECRETS", "AWS_RETRY_MODE", "AWS_MAX_ATTEMPTS",
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables map[string]string
+		// check verifies the loaded configuration, nil for none
+		check func(t *testing.T, c Config)
+		// wantErrs are the problems expected in the error, one per line
+		wantErrs []string
+		// wantWarnings are logged for the values ignored without making the configuration invalid
+		wantWarnings []string
+	}{
+		{name: "defaults", variables: map[string]string{}, check: func(t *testing.T, c Config) {
+			if c.AtlasSecretName != "" || c.RotationLock || !c.StrictConnectionStrings || c.PasswordLength != 32 ||
+				c.ConnectTimeout != 5*time.Second || c.RetryMaxAttempts != defaultRetryMaxAttempts || c.DerivedTokenMode != "random" ||
+				c.ExcludeCharacters != nil || c.ExcludeCharactersByEngine || c.EnabledSteps != nil || !slices.Equal(c.ConnectionPriority, defaultConnectionPriority) {
+				t.Errorf("LoadConfig() = %+v, want the defaults", c)
+			}
+		}},
+		{name: "empty values take the defaults", variables: map[string]string{"STRICT_CONNECTION_STRINGS": "", "CONNECT_TIMEOUT_SECONDS": " ",
+			"DERIVED_TOKEN_MODE": ""}, check: func(t *testing.T, c Config) {
+			if !c.StrictConnectionStrings || c.ConnectTimeout != 5*time.Second || c.DerivedTokenMode != "random" {
+				t.Errorf("LoadConfig() = %+v, want the defaults", c)
+			}
+		}},
+		{name: "valid values", variables: map[string]string{"MONGODB_ATLAS_SECRET_NAME": "atlas-api-key", "ROTATION_LOCK": "Yes",
+			"STRICT_CONNECTION_STRINGS": "no", "RETRY_MAX_ATTEMPTS": " 4 ", "USER_ACTIVE_TIMEOUT_SECONDS": "0", "PASSWORD_LENGTH": "24",
+			"PASSWORD_PREFIX": "Ab1", "EXCLUDE_CHARACTERS": "", "DEFAULT_ENGINE": "DocumentDB", "ATLAS_BASE_URL": "https://cloud.mongodbgov.com",
+			"DERIVED_TOKEN_MODE": "hmac", "DERIVED_TOKEN_HMAC_KEY": "key", "ENABLED_STEPS": "createSecret, testSecret,reconcile",
+			"CONNECTION_PRIORITY": "connection_string, connection_string", "LOGGABLE_FIELDS": "team, ,owner", "ATLAS_API_KEY_SECRETS": "org1-api-key, org2-api-key", "AWS_RETRY_MODE": "adaptive",
+			"AWS_MAX_ATTEMPTS": "5"},
+			check: func(t *testing.T, c Config) {
+				if c.AtlasSecretName != "atlas-api-key" || !c.RotationLock || c.StrictConnectionStrings || c.RetryMaxAttempts != 4 ||
+					c.UserActiveTimeout != 0 || c.PasswordLength != 24 || c.PasswordPrefix != "Ab1" || c.ExcludeCharacters == nil ||
+					*c.ExcludeCharacters != "" || c.DefaultEngine != "documentdb" || c.AtlasBaseURL != "https://cloud.mongodbgov.com" ||
+					c.DerivedTokenMode != "hmac" || c.AWSRetryMode != "adaptive" || c.AWSMaxAttempts != 5 {
+					t.Errorf("LoadConfig() = %+v, want the values set", c)
+				}
+				if want := []string{"createSecret", "testSecret", "reconcile"}; !slices.Equal(c.EnabledSteps, want) {
+					t.Errorf("LoadConfig() EnabledSteps = %v, want %v", c.EnabledSteps, want)
+				}
+				if want := []string{"connection_string"}; !slices.Equal(c.ConnectionPriority, want) {
+					t.Errorf("LoadConfig() ConnectionPriority = %v, want %v", c.ConnectionPriority, want)
+				}
+				if want := []string{"team", "owner"}; !slices.Equal(c.LoggableFields, want) {
+					t.Errorf("LoadConfig() LoggableFields = %v, want %v", c.LoggableFields, want)
+				}
+				if want := []string{"org1-api-key", "org2-api-key"}; !slices.Equal(c.AtlasApiKeySecrets, want) {
+					t.Errorf("LoadConfig() AtlasApiKeySecrets = %v, want %v", c.AtlasApiKeySecrets, want)
+				}
+			}},
+		{name: "invalid boolean reads as false", variables: map[string]string{"ROTATION_LOCK": "maybe", "STRICT_CONNECTION_STRINGS": "always"},
+			check: func(t *testing.T, c Config) {
+				if c.RotationLock || c.StrictConnectionStrings {
+					t.Errorf("LoadConfig() = %+v, want the invalid booleans false", c)
+				}
+			},
+			wantWarnings: []string{`ROTATION_LOCK "maybe" is not a boolean, read as false`, `STRICT_CONNECTION_STRINGS "always" is not a boolean, read as false`}},
+		{name: "integer below minimum", variables: map[string]string{"RETRY_MAX_ATTEMPTS": "0"}, wantErrs: []string{"RETRY_MAX_ATTEMPTS 0 is below the minimum 1"}},
+		{name: "not an integer", variables: map[string]string{"FINISH_DELAY_SECONDS": "1m"}, wantErrs: []string{`FINISH_DELAY_SECONDS "1m" is not an integer`}},
+		{name: "password length not an integer", variables: map[string]string{"PASSWORD_LENGTH": "abc"}, wantErrs: []string{`PASSWORD_LENGTH "abc" is not an integer`}},
+		{name: "password length within affixes", variables: map[string]string{"PASSWORD_LENGTH": "6", "PASSWORD_PREFIX": "abc", "PASSWORD_SUFFIX": "xyz"},
+			wantErrs: []string{"PASSWORD_LENGTH 6 must be longer than"}},
+		{name: "password length within multi-byte affixes", variables: map[string]string{"PASSWORD_LENGTH": "4", "PASSWORD_PREFIX": "éé", "PASSWORD_SUFFIX": "€"},
+			check: func(t *testing.T, c Config) {
+				if c.PasswordLength != 4 {
+					t.Errorf("LoadConfig() PasswordLength = %d, want 4", c.PasswordLength)
+				}
+			}},
+		{name: "exclude characters not utf-8", variables: map[string]string{"EXCLUDE_CHARACTERS": "ab\xff"}, check: checkDefaultExcludeCharacters,
+			wantWarnings: []string{"EXCLUDE_CHARACTERS is not valid UTF-8, the default set is used"}},
+		{name: "exclude characters too long", variables: map[string]string{"EXCLUDE_CHARACTERS": strings.Repeat("a", maxExcludeCharactersLength+1)},
+			check: checkDefaultExcludeCharacters, wantWarnings: []string{"EXCLUDE_CHARACTERS is 4097 characters long, over the limit of 4096, the default set is used"}},
+		{name: "exclude characters multi-byte too long", variables: map[string]string{"EXCLUDE_CHARACTERS": strings.Repeat("é", maxExcludeCharactersLength+1)},
+			check: checkDefaultExcludeCharacters, wantWarnings: []string{"EXCLUDE_CHARACTERS is 4097 characters long"}},
+		{name: "exclude characters multi-byte at the limit", variables: map[string]string{"EXCLUDE_CHARACTERS": strings.Repeat("é", maxExcludeCharactersLength)},
+			check: func(t *testing.T, c Config) {
+				if c.ExcludeCharacters == nil || *c.ExcludeCharacters != strings.Repeat("é", maxExcludeCharactersLength) {
+					t.Errorf("LoadConfig() ExcludeCharacters = %v, want the value set", c.ExcludeCharacters)
+				}
+			}},
+		{name: "unsupported default engine", variables: map[string]string{"DEFAULT_ENGINE": "Postgres"}, wantErrs: []string{`DEFAULT_ENGINE "postgres" is not one of`}},
+		{name: "invalid atlas base url", variables: map[string]string{"ATLAS_BASE_URL": "cloud.mongodbgov.com"}, wantErrs: []string{"ATLAS_BASE_URL"}},
+		{name: "hmac without key", variables: map[string]string{"DERIVED_TOKEN_MODE": "HMAC"}, wantErrs: []string{"DERIVED_TOKEN_HMAC_KEY is required"}},
+		{name: "unknown derived token mode", variables: map[string]string{"DERIVED_TOKEN_MODE": "sha1"}, wantErrs: []string{`DERIVED_TOKEN_MODE "sha1"`}},
+		{name: "derived token in a rotation field", variables: map[string]string{"DERIVED_TOKEN_FIELD": "password"},
+			wantErrs: []string{`DERIVED_TOKEN_FIELD "password" is a field used by the rotation`}},
+		{name: "last rotated in a rotation field", variables: map[string]string{"LAST_ROTATED_FIELD": "username"},
+			wantErrs: []string{`LAST_ROTATED_FIELD "username" is a field used by the rotation`}},
+		{name: "invalid field name map", variables: map[string]string{"FIELD_NAME_MAP": "{"}, wantErrs: []string{"FIELD_NAME_MAP"}},
+		{name: "unknown enabled step", variables: map[string]string{"ENABLED_STEPS": "createSecret,rotateSecret"}, wantErrs: []string{`ENABLED_STEPS lists unknown step "rotateSecret"`}},
+		{name: "unknown connection string key ignored", variables: map[string]string{"CONNECTION_PRIORITY": "host,connection_string_srv"},
+			check: func(t *testing.T, c Config) {
+				if want := []string{"connection_string_srv"}; !slices.Equal(c.ConnectionPriority, want) {
+					t.Errorf("LoadConfig() ConnectionPriority = %v, want %v", c.ConnectionPriority, want)
+				}
+			},
+			wantWarnings: []string{`CONNECTION_PRIORITY lists unknown connection string key "host", ignored`}},
+		{name: "unknown aws retry mode", variables: map[string]string{"AWS_RETRY_MODE": "fast"}, wantErrs: []string{`AWS_RETRY_MODE "fast" must be standard or adaptive`}},
+		{name: "aws max attempts below minimum", variables: map[string]string{"AWS_MAX_ATTEMPTS": "0"}, wantErrs: []string{"AWS_MAX_ATTEMPTS 0 is below the minimum 1"}},
+		{name: "every problem reported", variables: map[string]string{"FINISH_DELAY_SECONDS": "1m", "PASSWORD_LENGTH": "abc", "RETRY_MAX_ATTEMPTS": "0",
+			"DEFAULT_ENGINE": "postgres"},
+			wantErrs: []string{`FINISH_DELAY_SECONDS "1m"`, `PASSWORD_LENGTH "abc"`, "RETRY_MAX_ATTEMPTS 0", `DEFAULT_ENGINE "postgres"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, configVariables...)
+			setEnv(t, tt.variables)
+			logs := captureLog(t)
+			got, err := LoadConfig()
+			for _, want := range tt.wantWarnings {
+				if !strings.Contains(logs.String(), "WARNING: LoadConfig: "+want) {
+					t.Errorf("LoadConfig() logged %q, want the warning %q", logs.String(), want)
+				}
+			}
+			if (err != nil) != (len(tt.wantErrs) > 0) {
+				t.Fatalf("LoadConfig() error = %v, want %d problems", err, len(tt.wantErrs))
+			}
+			if tt.check != nil {
+				tt.check(t, got)
+			}
+			if err == nil {
+				return
+			}
+			problems := strings.Split(err.Error(), "\n")
+			if len(problems) != len(tt.wantErrs) {
+				t.Errorf("LoadConfig() reported %d problems, want %d: %v", len(problems), len(tt.wantErrs), err)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("LoadConfig() error = %v, want it to report %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// checkDefaultExcludeCharacters verifies an invalid EXCLUDE_CHARACTERS was replaced by the default set.
+func checkDefaultExcludeCharacters(t *testing.T, c Config) {
+	t.Helper()
+	if c.ExcludeCharacters != nil {
+		t.Errorf("LoadConfig() ExcludeCharacters = %q, want the default set", *c.ExcludeCharacters)
+	}
+	if got := GetExcludeCharacters("mongodbatlas"); got != strictExcludeCharacters {
+		t.Errorf("GetExcludeCharacters() = %q, want the default set %q", got, strictExcludeCharacters)
+	}
+}
+
+func TestHandleRequestInvalidConfig(t *testing.T) {
+	unsetEnv(t, configVariables...)
+	setEnv(t, map[string]string{"RETRY_MAX_ATTEMPTS": "0", "DEFAULT_ENGINE": "postgres"})
+	transport := fakeAWS(t, nil)
+	event, err := json.Marshal(SecretsManagerEvent{SecretId: testSecretArn, ClientRequestToken: testPendingToken, Step: "createSecret"})
+	if err != nil {
+		t.Fatalf("failed to marshal event: %v", err)
+	}
+
+	// The configuration is loaded once, the error is the same on every invocation
+	for range 2 {
+		err = HandleRequest(context.Background(), event)
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid configuration: ") ||
+			!strings.Contains(err.Error(), "RETRY_MAX_ATTEMPTS") || !strings.Contains(err.Error(), "DEFAULT_ENGINE") {
+			t.Fatalf("HandleRequest() error = %v, want every configuration problem", err)
+		}
+	}
+	if !errors.Is(err, CheckConfig()) {
+		t.Errorf("HandleRequest() error = %v, want the configuration error", err)
+	}
+	if calls := transport.Calls(); len(calls) != 0 {
+		t.Errorf("HandleRequest() made AWS calls %v with an invalid configuration", calls)
+	}
+}
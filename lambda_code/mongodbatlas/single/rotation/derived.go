@@ -0,0 +1,47 @@
+// derived.go
+package rotation
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// SetDerivedToken
+//
+// Store a token derived from the rotation in the secret
+//
+//	For applications authenticating with a bearer token next to the database password. When DERIVED_TOKEN_FIELD
+//	environment variable names a field, a token is written to it on every rotation:
+//	    - DERIVED_TOKEN_MODE=random (default): 32 random bytes, hex encoded
+//	    - DERIVED_TOKEN_MODE=hmac: HMAC-SHA256 of the new password with the DERIVED_TOKEN_HMAC_KEY key, hex encoded
+//
+//	A field used by the rotation, an unknown mode or a missing key are refused by LoadConfig.
+//
+//	Args:
+//	    secretDict (map[string]string): The pending secret dictionary, updated in place
+//
+//	    password (string): The new password
+//
+//	Returns:
+//	    error: Error if the token could not be generated
+func SetDerivedToken(secretDict map[string]string, password string) error {
+	field := settings.DerivedTokenField
+	if field == "" {
+		return nil
+	}
+	if settings.DerivedTokenMode == "hmac" {
+		mac := hmac.New(sha256.New, []byte(settings.DerivedTokenHmacKey))
+		mac.Write([]byte(password))
+		secretDict[field] = hex.EncodeToString(mac.Sum(nil))
+		return nil
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return fmt.Errorf("failed to generate derived token: %w", err)
+	}
+	secretDict[field] = hex.EncodeToString(token)
+	return nil
+}
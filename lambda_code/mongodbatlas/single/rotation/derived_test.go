@@ -0,0 +1,75 @@
+// derived_test.go
+package rotation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+// hmacToken is the expected hmac mode token of the password.
+func hmacToken(key string, password string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSetDerivedToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantLen int
+		wantErr bool
+	}{
+		{name: "disabled"},
+		{name: "random by default", env: map[string]string{"DERIVED_TOKEN_FIELD": "api_token"}, wantLen: 64},
+		{name: "random", env: map[string]string{"DERIVED_TOKEN_FIELD": "api_token", "DERIVED_TOKEN_MODE": "Random"}, wantLen: 64},
+		{name: "hmac", env: map[string]string{"DERIVED_TOKEN_FIELD": "api_token", "DERIVED_TOKEN_MODE": "hmac", "DERIVED_TOKEN_HMAC_KEY": "k3y"},
+			want: hmacToken("k3y", "new-password")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "DERIVED_TOKEN_FIELD", "DERIVED_TOKEN_MODE", "DERIVED_TOKEN_HMAC_KEY")
+			setEnv(t, tt.env)
+			secretDict := map[string]string{"username": "app", "password": "new-password"}
+
+			err := SetDerivedToken(secretDict, "new-password")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetDerivedToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			token, ok := secretDict["api_token"]
+			if tt.want == "" && tt.wantLen == 0 {
+				if ok {
+					t.Errorf("api_token = %q, want no token", token)
+				}
+				return
+			}
+			if tt.want != "" && token != tt.want {
+				t.Errorf("api_token = %q, want %q", token, tt.want)
+			}
+			if _, decodeErr := hex.DecodeString(token); tt.wantLen != 0 && (len(token) != tt.wantLen || decodeErr != nil) {
+				t.Errorf("api_token = %q, want %d hex characters", token, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestCreateSecretDerivedToken(t *testing.T) {
+	unsetEnv(t, "PASSWORD_LENGTH", "PASSWORD_PREFIX", "PASSWORD_SUFFIX", "PRECHECK_CURRENT", "CLEANUP_STALE_PENDING", "KEEP_PREVIOUS_PASSWORD")
+	setEnv(t, map[string]string{"DERIVED_TOKEN_FIELD": "api_token", "DERIVED_TOKEN_MODE": "hmac", "DERIVED_TOKEN_HMAC_KEY": "k3y"})
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", map[string]string{"api_token": hmacToken("k3y", "old-password")}))
+
+	if err := CreateSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken); err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	pendingDict := stagedDict(t, smClient, "AWSPENDING")
+	if want := hmacToken("k3y", pendingDict["password"]); pendingDict["api_token"] != want {
+		t.Errorf("pending api_token = %q, want the hmac of the new password %q", pendingDict["api_token"], want)
+	}
+}
@@ -0,0 +1,128 @@
+// ephemeral.go
+package rotation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// ephemeralUserLifetime is the Atlas deleteAfterDate of the test user, so Atlas removes it even if the cleanup fails.
+const ephemeralUserLifetime = time.Hour
+
+// TestWithEphemeralUser
+//
+// Test the secret connectivity with a short-lived copy of the secret user
+//
+//	A temporary Atlas user is created with the roles and scopes of the secret user and a random password in every
+//	project and authentication database the secret user is rotated in, the secret connection strings are tested
+//	with it as the pending credential is (see testConnections), and the users are deleted whether the test succeeded
+//	or not. The users are also created with a deleteAfterDate so Atlas expires them should the cleanup fail.
+//
+//	Enabled with USE_EPHEMERAL_TEST_USER environment variable, applies only to the mongodbatlas engine. It runs in
+//	addition to the test of the pending credential, never in place of it.
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    error: Error if a test user could not be created, could not login, or could not be deleted
+func TestWithEphemeralUser(ctx context.Context, smClient SecretsManagerAPI, mongoAdmin *admin.APIClient, secretDict map[string]string) (err error) {
+	secretDict = maps.Clone(secretDict)
+	if err = ResolveSecretProjectId(ctx, mongoAdmin, secretDict); err != nil {
+		return err
+	}
+	authDatabases, err := GetAuthDatabases(secretDict)
+	if err != nil {
+		return err
+	}
+	var targets []AtlasUserTarget
+	for _, authDatabase := range authDatabases {
+		databaseTargets, err := GetAtlasUserTargets(ctx, mongoAdmin, secretDict, secretDict["username"], authDatabase)
+		if err != nil {
+			return fmt.Errorf("failed to get user %v: %w", secretDict["username"], err)
+		}
+		targets = append(targets, databaseTargets...)
+	}
+	suffix := make([]byte, 4)
+	if _, err = rand.Read(suffix); err != nil {
+		return fmt.Errorf("failed to generate test username suffix: %w", err)
+	}
+	password, err := GetRandomPassword(ctx, smClient, GetEngine(secretDict))
+	if err != nil {
+		return fmt.Errorf("failed to generate test password: %w", err)
+	}
+	testDict := maps.Clone(secretDict)
+	testDict["username"] = fmt.Sprintf("%s_test_%s", secretDict["username"], hex.EncodeToString(suffix))
+	deleteAfter := time.Now().Add(ephemeralUserLifetime)
+
+	var created []AtlasUserTarget
+	defer func() {
+		for _, target := range created {
+			_, resp, deleteErr := mongoAdmin.DatabaseUsersApi.DeleteDatabaseUser(ctx, target.projectId, target.user.DatabaseName, testDict["username"]).Execute()
+			if deleteErr != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+				log.Printf("TestSecret: Failed to delete temporary test user %v in project %v, Atlas expires it at %v: %v", testDict["username"], target.projectId, deleteAfter, AtlasError(deleteErr))
+				if err == nil {
+					err = fmt.Errorf("failed to delete test user %v in project %v: %w", testDict["username"], target.projectId, AtlasError(deleteErr))
+				}
+				continue
+			}
+			log.Printf("TestSecret: Deleted temporary test user %v in %v of project %v", testDict["username"], target.user.DatabaseName, target.projectId)
+		}
+	}()
+	for _, target := range targets {
+		testUser := admin.NewCloudDatabaseUser(target.user.DatabaseName, target.projectId, testDict["username"])
+		testUser.Password = &password
+		testUser.Roles = target.user.Roles
+		testUser.Scopes = target.user.Scopes
+		testUser.Description = admin.PtrString("Temporary rotation test user for " + secretDict["username"])
+		testUser.DeleteAfterDate = admin.PtrTime(deleteAfter)
+		if _, _, err = mongoAdmin.DatabaseUsersApi.CreateDatabaseUser(ctx, target.projectId, testUser).Execute(); err != nil {
+			return fmt.Errorf("failed to create test user %v in project %v: %w", testDict["username"], target.projectId, AtlasError(err))
+		}
+		created = append(created, target)
+		log.Printf("TestSecret: Created temporary test user %v in %v of project %v", testDict["username"], target.user.DatabaseName, target.projectId)
+	}
+
+	waited := map[string]bool{}
+	for _, target := range created {
+		if waited[target.projectId] {
+			continue
+		}
+		waited[target.projectId] = true
+		clusterName := ""
+		if target.projectId == secretDict["project_id"] {
+			clusterName = secretDict["cluster_name"]
+		}
+		if err = WaitForUserChangesApplied(ctx, mongoAdmin, target.projectId, clusterName); err != nil {
+			return fmt.Errorf("failed waiting for test user %v in project %v: %w", testDict["username"], target.projectId, err)
+		}
+	}
+	for _, key := range connectionStringKeys {
+		if !hasConnectionString(testDict, key) {
+			delete(testDict, key)
+			continue
+		}
+		if _, err = GenerateConnectionString(key, testDict, password); err != nil {
+			return err
+		}
+	}
+	if err = GenerateProjectsConnectionStrings(testDict, password); err != nil {
+		return err
+	}
+	if err = testConnections(ctx, testDict); err != nil {
+		return fmt.Errorf("failed to login with test user %v: %w", testDict["username"], err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// events.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventbridgetypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+const (
+	// rotationEventSource is the source of the events put by the function
+	rotationEventSource = "secrets-rotation"
+	// rotationCompletedDetailType is the detail-type of the event put after finishSecret
+	rotationCompletedDetailType = "SecretRotationCompleted"
+)
+
+// RotationEventDetail
+//
+// Detail of the rotation events, holding no secret material
+type RotationEventDetail struct {
+	SecretName string `json:"secret_name"`
+	Engine     string `json:"engine"`
+	VersionId  string `json:"version_id"`
+}
+
+// PublishRotationEvent
+//
+// Put a SecretRotationCompleted event on the EventBridge bus named by EVENT_BUS_NAME
+//
+//	For event-driven consumers reacting to rotations. The module grants the Lambda role events:PutEvents on the bus
+//	when EVENT_BUS_NAME is set in settings.environment.variables.
+//
+//	Args:
+//	    arn (string): The secret ARN or other identifier
+//
+//	    engine (string): The secret engine
+//
+//	    token (string): The promoted version id
+//
+//	Returns:
+//	    error: Error if the event could not be put, nothing is done when EVENT_BUS_NAME is unset
+func PublishRotationEvent(ctx context.Context, arn string, engine string, token string) error {
+	busName := settings.EventBusName
+	if busName == "" {
+		return nil
+	}
+	detail, err := json.Marshal(RotationEventDetail{SecretName: arn, Engine: engine, VersionId: token})
+	if err != nil {
+		return fmt.Errorf("failed to marshal rotation event of %v: %w", arn, err)
+	}
+	eventsClient := eventbridge.NewFromConfig(cfg)
+	output, err := eventsClient.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []eventbridgetypes.PutEventsRequestEntry{{
+			EventBusName: &busName,
+			Source:       aws.String(rotationEventSource),
+			DetailType:   aws.String(rotationCompletedDetailType),
+			Detail:       aws.String(string(detail)),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put rotation event of %v on bus %v: %w", arn, busName, err)
+	}
+	if output.FailedEntryCount > 0 && len(output.Entries) > 0 {
+		return fmt.Errorf("failed to put rotation event of %v on bus %v: %v: %v", arn, busName,
+			aws.ToString(output.Entries[0].ErrorCode), aws.ToString(output.Entries[0].ErrorMessage))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// events_test.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+func TestFinishSecretRotationEvent(t *testing.T) {
+	accessDenied := awsResponse{status: http.StatusBadRequest, body: `{"__type": "AccessDeniedException", "message": "not authorized to perform events:PutEvents"}`}
+	failedEntry := awsResponse{body: `{"FailedEntryCount": 1, "Entries": [{"ErrorCode": "InternalFailure", "ErrorMessage": "try again"}]}`}
+	tests := []struct {
+		name      string
+		busName   string
+		response  awsResponse
+		wantCalls int
+		wantLog   string
+	}{
+		{name: "disabled"},
+		{name: "blank bus name", busName: "  "},
+		{name: "published", busName: "rotations", response: awsResponse{body: `{"FailedEntryCount": 0, "Entries": [{"EventId": "1"}]}`}, wantCalls: 1},
+		{name: "put failure", busName: "rotations", response: accessDenied, wantCalls: 1, wantLog: "failed to put rotation event"},
+		{name: "failed entry", busName: "rotations", response: failedEntry, wantCalls: 1, wantLog: "InternalFailure: try again"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "FINISH_DELAY_SECONDS", "ENABLE_ROLLBACK", "VERIFY_OLD_REVOKED", "PUBLISH_VERSION_PARAMETER", "DEFAULT_ENGINE")
+			setEnv(t, map[string]string{"EVENT_BUS_NAME": tt.busName})
+			transport := fakeAWS(t, map[string]awsResponse{"PutEvents": tt.response})
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+			smClient.PutVersion(testSecretArn, testPendingToken, atlasSecret(t, "new-password", nil), "AWSPENDING")
+			logs := captureLog(t)
+
+			// A failure to put the event doesn't fail the rotation
+			if err := FinishSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken); err != nil {
+				t.Fatalf("FinishSecret() error = %v", err)
+			}
+			if _, currentVersion, _ := smClient.Version(testSecretArn, "AWSCURRENT"); currentVersion != testPendingToken {
+				t.Errorf("AWSCURRENT version = %q, want %q", currentVersion, testPendingToken)
+			}
+			calls := transport.Calls()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("AWS calls = %v, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantCalls > 0 {
+				var input struct {
+					Entries []struct {
+						EventBusName, Source, DetailType, Detail string
+					}
+				}
+				if err := json.Unmarshal([]byte(calls[0].body), &input); err != nil {
+					t.Fatalf("PutEvents body %q is not valid: %v", calls[0].body, err)
+				}
+				if len(input.Entries) != 1 {
+					t.Fatalf("PutEvents entries = %+v, want one", input.Entries)
+				}
+				entry := input.Entries[0]
+				if entry.EventBusName != tt.busName || entry.Source != "secrets-rotation" || entry.DetailType != "SecretRotationCompleted" {
+					t.Errorf("PutEvents entry = %+v, want a SecretRotationCompleted event on %v", entry, tt.busName)
+				}
+				var detail map[string]string
+				if err := json.Unmarshal([]byte(entry.Detail), &detail); err != nil {
+					t.Fatalf("event detail %q is not valid: %v", entry.Detail, err)
+				}
+				want := map[string]string{"secret_name": testSecretArn, "engine": "mongodbatlas", "version_id": testPendingToken}
+				if len(detail) != len(want) {
+					t.Errorf("event detail = %v, want %v", detail, want)
+				}
+				for key, value := range want {
+					if detail[key] != value {
+						t.Errorf("event detail %v = %q, want %q", key, detail[key], value)
+					}
+				}
+				if strings.Contains(entry.Detail, "password") {
+					t.Errorf("event detail %q holds secret material", entry.Detail)
+				}
+			}
+			if tt.wantLog != "" && !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("FinishSecret() logs = %q, want %q", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
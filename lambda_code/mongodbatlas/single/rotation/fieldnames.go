@@ -0,0 +1,67 @@
+// fieldnames.go
+package rotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GetFieldNameMap
+//
+// Get the secret field names that differ from the ones used by the rotation
+//
+//	FIELD_NAME_MAP environment variable holds a JSON object mapping the rotation field names to the names used in
+//	the secrets, e.g. {"username": "user", "password": "pass", "connection_string": "conn"}. Fields not listed keep
+//	their names.
+//
+//	Returns:
+//	    map[string]string: The secret field name by rotation field name, empty when FIELD_NAME_MAP is not set
+//	    error: Error if FIELD_NAME_MAP is not a JSON object of strings
+func GetFieldNameMap() (map[string]string, error) {
+	value := strings.TrimSpace(os.Getenv("FIELD_NAME_MAP"))
+	if value == "" {
+		return nil, nil
+	}
+	var fieldNames map[string]string
+	if err := json.Unmarshal([]byte(value), &fieldNames); err != nil {
+		return nil, fmt.Errorf("invalid FIELD_NAME_MAP: %w", err)
+	}
+	return fieldNames, nil
+}
+
+// ToRotationFields
+//
+// Rename the secret fields to the rotation field names, in place
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary as stored
+func ToRotationFields(secretDict map[string]string) {
+	for field, secretField := range settings.FieldNameMap {
+		if value, ok := secretDict[secretField]; ok && secretField != field {
+			secretDict[field] = value
+			delete(secretDict, secretField)
+		}
+	}
+}
+
+// ToSecretFields
+//
+// Rename the rotation fields back to the secret field names
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary with the rotation field names
+//
+//	Returns:
+//	    map[string]string: A copy of the dictionary with the secret field names
+func ToSecretFields(secretDict map[string]string) map[string]string {
+	output := make(map[string]string, len(secretDict))
+	for field, value := range secretDict {
+		if secretField, ok := settings.FieldNameMap[field]; ok {
+			field = secretField
+		}
+		output[field] = value
+	}
+	return output
+}
@@ -0,0 +1,194 @@
+// force.go
+package rotation
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// ForceRotationStep
+//
+// Timing of one rotation step run by ForceRotation
+type ForceRotationStep struct {
+	Step       string    `json:"step"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// ForceRotationResult
+//
+// Outcome of a forced rotation, for callers displaying what was done
+type ForceRotationResult struct {
+	// VersionId is the ClientRequestToken of the new version
+	VersionId string `json:"version_id"`
+	// Engine is the engine of the rotated secret, resolved after createSecret
+	Engine string `json:"engine,omitempty"`
+	// Steps are the steps that ran, in order, the last one being the failed step on error
+	Steps []ForceRotationStep `json:"steps"`
+}
+
+// ForceRotation
+//
+// Rotate the secret immediately, running the four rotation steps in sequence
+//
+//	This mirrors what the Secrets Manager rotation state machine does: a new ClientRequestToken is generated,
+//	createSecret stages it as AWSPENDING, then setSecret, testSecret and finishSecret run in order. A secret with
+//	another version staged AWSPENDING is refused, a rotation may still be running for it.
+//
+//	The first step that fails stops the sequence. Secrets Manager never retries the forced version, so it can't be
+//	left AWSPENDING, every later rotation would fail on it: when createSecret or setSecret failed the database still
+//	uses the current password (setSecret reverts the users it updated) and the AWSPENDING stage is removed from the
+//	forced version. When testSecret or finishSecret failed the database may already use the forced version, it is
+//	left AWSPENDING and the error asks to run the reconcile step (see Reconcile), which completes or rolls back the
+//	rotation once the version is RECONCILE_MIN_AGE_SECONDS old.
+//
+//	The guards of HandleRequest apply: the environment configuration must be valid, every step must be listed in
+//	ENABLED_STEPS when it is set, the secret must be enabled for rotation (see CheckRotationEnabled), and with
+//	ROTATION_LOCK the rotation lock is taken before createSecret and released once the sequence ends, also when a
+//	step failed since the forced version is not retried.
+//	As for an invocation, the retry loops of the four steps share one retry budget (see WithRetryBudget).
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    arn (string): The secret ARN or other identifier
+//
+//	Returns:
+//	    ForceRotationResult: The new version, the resolved engine and the timing of every step that ran, also on error
+//	    error: Error of the first failed step
+func ForceRotation(ctx context.Context, smClient SecretsManagerAPI, mongoAdmin *admin.APIClient, arn string) (ForceRotationResult, error) {
+	var result ForceRotationResult
+	if err := CheckConfig(); err != nil {
+		return result, err
+	}
+	ctx = WithRetryBudget(ctx)
+	steps := []struct {
+		name string
+		run  func(context.Context, SecretsManagerAPI, *admin.APIClient, string, string) error
+	}{
+		{"createSecret", CreateSecret},
+		{"setSecret", SetSecret},
+		{"testSecret", TestSecret},
+		{"finishSecret", FinishSecret},
+	}
+	for _, step := range steps {
+		if err := CheckStepEnabled(step.name); err != nil {
+			return result, fmt.Errorf("ForceRotation: %w", err)
+		}
+	}
+	secret, err := DescribeSecretWithRetry(ctx, smClient, arn)
+	if err != nil {
+		return result, fmt.Errorf("ForceRotation: Failed to describe secret %v: %w", arn, err)
+	}
+	if err = CheckRotationEnabled(secret, arn); err != nil {
+		return result, fmt.Errorf("ForceRotation: %w", err)
+	}
+	for versionId, stages := range secret.VersionIdsToStages {
+		if slices.Contains(stages, "AWSPENDING") && !slices.Contains(stages, "AWSCURRENT") {
+			return result, fmt.Errorf("ForceRotation: Version %v of %v is already staged AWSPENDING, wait for its rotation to end or run the %v step",
+				versionId, arn, ReconcileStep)
+		}
+	}
+	token, err := NewClientRequestToken()
+	if err != nil {
+		return result, err
+	}
+	result.VersionId = token
+	if settings.RotationLock {
+		if err = AcquireRotationLock(ctx, smClient, secret, arn, token); err != nil {
+			return result, fmt.Errorf("ForceRotation: %w", err)
+		}
+		defer func() {
+			if err := ReleaseRotationLock(context.WithoutCancel(ctx), smClient, arn); err != nil {
+				log.Printf("ForceRotation: %v", err)
+			}
+		}()
+	}
+	log.Printf("ForceRotation: Rotating %v with version %v", arn, token)
+	for _, step := range steps {
+		timing := ForceRotationStep{Step: step.name, StartedAt: time.Now()}
+		err = step.run(ctx, smClient, mongoAdmin, arn, token)
+		timing.FinishedAt = time.Now()
+		result.Steps = append(result.Steps, timing)
+		if err != nil {
+			err = fmt.Errorf("ForceRotation: %v failed: %w", step.name, err)
+			if step.name == "createSecret" || step.name == "setSecret" {
+				if dropErr := DropForcedVersion(context.WithoutCancel(ctx), smClient, arn, token); dropErr != nil {
+					log.Printf("ForceRotation: %v", dropErr)
+				}
+				return result, err
+			}
+			return result, fmt.Errorf("%w; version %v is left AWSPENDING as the database may already use it, run the %v step to complete or roll back the rotation",
+				err, token, ReconcileStep)
+		}
+		if step.name == "createSecret" {
+			pendingDict, err := GetSecretDict(ctx, smClient, RotationConfig{arn: &arn, stage: "AWSPENDING", token: &token})
+			if err != nil {
+				log.Printf("WARNING: ForceRotation: Failed to resolve the engine of %v: %v", arn, err)
+				continue
+			}
+			result.Engine = GetEngine(pendingDict)
+		}
+	}
+	return result, nil
+}
+
+// DropForcedVersion
+//
+// Remove the AWSPENDING stage of a forced version that did not change the database
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    arn (string): The secret ARN or other identifier
+//
+//	    token (string): The ClientRequestToken of the forced version
+//
+//	Returns:
+//	    error: Error if the secret could not be described or the stage removed, nil when the version was not staged
+func DropForcedVersion(ctx context.Context, smClient SecretsManagerAPI, arn string, token string) error {
+	secret, err := DescribeSecretWithRetry(ctx, smClient, arn)
+	if err != nil {
+		return fmt.Errorf("failed to describe secret %v to drop version %v: %w", arn, token, err)
+	}
+	if !slices.Contains(secret.VersionIdsToStages[token], "AWSPENDING") {
+		return nil
+	}
+	_, err = smClient.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            &arn,
+		VersionStage:        aws.String("AWSPENDING"),
+		RemoveFromVersionId: &token,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove AWSPENDING from version %v of %v: %w", token, arn, err)
+	}
+	log.Printf("ForceRotation: Removed AWSPENDING from version %v of %v", token, arn)
+	return nil
+}
+
+// NewClientRequestToken
+//
+// Generate a random (version 4) UUID to use as ClientRequestToken
+//
+//	Returns:
+//	    string: The UUID
+//	    error: Error if the random bytes could not be read
+func NewClientRequestToken() (string, error) {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return "", fmt.Errorf("failed to generate client request token: %w", err)
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
+}
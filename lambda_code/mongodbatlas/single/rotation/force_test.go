@@ -0,0 +1,261 @@
+// force_test.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+// unsetForceRotationEnv clears the environment variables changing the course of a forced rotation.
+func unsetForceRotationEnv(t *testing.T) {
+	t.Helper()
+	unsetEnv(t, "ENABLED_STEPS", "ROTATION_LOCK", "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME",
+		"EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING", "DEFAULT_AUTH_DATABASE", "PRECHECK_CURRENT", "CLEANUP_STALE_PENDING",
+		"KEEP_PREVIOUS_PASSWORD", "VERIFY_OLD_REVOKED", "FINISH_DELAY_SECONDS", "PASSWORD_PREFIX", "PASSWORD_SUFFIX",
+		"PASSWORD_LENGTH", "USE_EPHEMERAL_TEST_USER", "ENABLE_ROLLBACK", "ALLOW_ROTATION_WHEN_UNKNOWN")
+}
+
+// newForceRotationFakes creates the fakes holding the current version of the test secret and its Atlas user.
+func newForceRotationFakes(t *testing.T) (*testutil.FakeSecretsManager, *testutil.FakeAtlas, *admin.APIClient) {
+	t.Helper()
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+	atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, testutil.WithProjectUsers(testProjectId, "payments",
+		admin.CloudDatabaseUser{DatabaseName: "admin", Username: "app", Password: admin.PtrString("old-password")}))
+	return smClient, atlas, mongoAdmin
+}
+
+func TestForceRotation(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// setup injects the failures of the case
+		setup       func(*testutil.FakeSecretsManager, *testutil.FakeAtlas)
+		wantSteps   []string
+		wantErr     string
+		wantRotated bool
+		// wantPending is true when a version other than AWSCURRENT is left AWSPENDING
+		wantPending bool
+	}{
+		{name: "all steps", env: map[string]string{"SKIP_TEST_SECRET": "true"},
+			wantSteps: []string{"createSecret", "setSecret", "testSecret", "finishSecret"}, wantRotated: true},
+		{name: "setSecret failure stops the sequence", env: map[string]string{"SKIP_TEST_SECRET": "true"},
+			setup: func(_ *testutil.FakeSecretsManager, atlas *testutil.FakeAtlas) {
+				atlas.FailNext("UpdateDatabaseUser", http.StatusBadRequest)
+			},
+			wantSteps: []string{"createSecret", "setSecret"}, wantErr: "setSecret failed"},
+		{name: "finishSecret failure asks for the reconcile step", env: map[string]string{"SKIP_TEST_SECRET": "true"},
+			setup: func(smClient *testutil.FakeSecretsManager, _ *testutil.FakeAtlas) {
+				smClient.FailNext("UpdateSecretVersionStage", errors.New("access denied"))
+			},
+			wantSteps: []string{"createSecret", "setSecret", "testSecret", "finishSecret"}, wantErr: "run the reconcile step",
+			wantPending: true},
+		{name: "another version pending", setup: func(smClient *testutil.FakeSecretsManager, _ *testutil.FakeAtlas) {
+			smClient.PutVersion(testSecretArn, testPendingToken, atlasSecret(t, "pending-password", nil), "AWSPENDING")
+		}, wantErr: "is already staged AWSPENDING", wantPending: true},
+		{name: "rotation disabled", setup: func(smClient *testutil.FakeSecretsManager, _ *testutil.FakeAtlas) {
+			smClient.SetRotationEnabled(testSecretArn, aws.Bool(false))
+		}, wantErr: "ForceRotation"},
+		{name: "step not enabled", env: map[string]string{"ENABLED_STEPS": "createSecret,setSecret,testSecret"},
+			wantErr: "finishSecret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetForceRotationEnv(t)
+			unsetEnv(t, "SKIP_TEST_SECRET")
+			setEnv(t, tt.env)
+			smClient, atlas, mongoAdmin := newForceRotationFakes(t)
+			if tt.setup != nil {
+				tt.setup(smClient, atlas)
+			}
+
+			result, err := ForceRotation(context.Background(), smClient, mongoAdmin, testSecretArn)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("ForceRotation() error = %v, want %q", err, tt.wantErr)
+			}
+			var steps []string
+			for _, step := range result.Steps {
+				steps = append(steps, step.Step)
+			}
+			if !slices.Equal(steps, tt.wantSteps) {
+				t.Errorf("ForceRotation() steps = %v, want %v", steps, tt.wantSteps)
+			}
+			_, currentVersion, _ := smClient.Version(testSecretArn, "AWSCURRENT")
+			if rotated := currentVersion != testCurrentToken; rotated != tt.wantRotated {
+				t.Errorf("AWSCURRENT version = %v, want rotated = %v", currentVersion, tt.wantRotated)
+			}
+			// finishSecret failed after the database took the forced password
+			if user, _ := atlas.User(testProjectId, "admin", "app"); (user.GetPassword() != "old-password") != (tt.wantRotated || tt.wantErr == "run the reconcile step") {
+				t.Errorf("user password = %q, want rotated = %v", user.GetPassword(), tt.wantRotated)
+			}
+			if _, pendingVersion, ok := smClient.Version(testSecretArn, "AWSPENDING"); (ok && pendingVersion != currentVersion) != tt.wantPending {
+				t.Errorf("AWSPENDING version = %q, want pending = %v", pendingVersion, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestForceRotationResult(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup injects the failures of the case
+		setup func(*testutil.FakeSecretsManager, *testutil.FakeAtlas)
+		// wantStage is the stage of the version returned, "" for no version
+		wantStage  string
+		wantEngine string
+		wantSteps  int
+	}{
+		{name: "rotated", wantStage: "AWSCURRENT", wantEngine: "mongodbatlas", wantSteps: 4},
+		{name: "failed after setSecret", setup: func(smClient *testutil.FakeSecretsManager, _ *testutil.FakeAtlas) {
+			smClient.FailNext("UpdateSecretVersionStage", errors.New("access denied"))
+		}, wantStage: "AWSPENDING", wantEngine: "mongodbatlas", wantSteps: 4},
+		{name: "failed before the rotation", setup: func(smClient *testutil.FakeSecretsManager, _ *testutil.FakeAtlas) {
+			smClient.SetRotationEnabled(testSecretArn, aws.Bool(false))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetForceRotationEnv(t)
+			setEnv(t, map[string]string{"SKIP_TEST_SECRET": "true"})
+			smClient, atlas, mongoAdmin := newForceRotationFakes(t)
+			if tt.setup != nil {
+				tt.setup(smClient, atlas)
+			}
+			start := time.Now()
+
+			result, _ := ForceRotation(context.Background(), smClient, mongoAdmin, testSecretArn)
+			end := time.Now()
+			wantVersion := ""
+			if tt.wantStage != "" {
+				_, wantVersion, _ = smClient.Version(testSecretArn, tt.wantStage)
+			}
+			if result.VersionId != wantVersion {
+				t.Errorf("ForceRotation() version = %q, want the %v version %q", result.VersionId, tt.wantStage, wantVersion)
+			}
+			if tt.wantStage != "" && ValidateClientRequestToken(result.VersionId) != nil {
+				t.Errorf("ForceRotation() version = %q, want a valid ClientRequestToken", result.VersionId)
+			}
+			if result.Engine != tt.wantEngine {
+				t.Errorf("ForceRotation() engine = %q, want %q", result.Engine, tt.wantEngine)
+			}
+			if len(result.Steps) != tt.wantSteps {
+				t.Fatalf("ForceRotation() steps = %+v, want %d", result.Steps, tt.wantSteps)
+			}
+			// The steps ran one after the other within the call
+			previous := start
+			for _, step := range result.Steps {
+				if step.StartedAt.Before(previous) || step.FinishedAt.Before(step.StartedAt) || step.FinishedAt.After(end) {
+					t.Errorf("ForceRotation() step %v ran from %v to %v, want it after %v and before %v", step.Step, step.StartedAt, step.FinishedAt, previous, end)
+				}
+				previous = step.FinishedAt
+			}
+		})
+	}
+}
+
+func TestForceRotationReleasesLock(t *testing.T) {
+	otherLock := "3f1c2d4e-5a6b-4c7d-8e9f-0a1b2c3d4e5f|" + time.Now().UTC().Format(time.RFC3339)
+	tests := []struct {
+		name string
+		// setup injects the failures of the case
+		setup    func(*testutil.FakeSecretsManager, *testutil.FakeAtlas)
+		wantErr  string
+		wantLock string
+	}{
+		{name: "rotated"},
+		{name: "setSecret failure", setup: func(_ *testutil.FakeSecretsManager, atlas *testutil.FakeAtlas) {
+			atlas.FailNext("UpdateDatabaseUser", http.StatusBadRequest)
+		}, wantErr: "setSecret failed"},
+		{name: "finishSecret failure", setup: func(smClient *testutil.FakeSecretsManager, _ *testutil.FakeAtlas) {
+			smClient.FailNext("UpdateSecretVersionStage", errors.New("access denied"))
+		}, wantErr: "finishSecret failed"},
+		{name: "lock held by another rotation", setup: func(smClient *testutil.FakeSecretsManager, _ *testutil.FakeAtlas) {
+			_, _ = smClient.TagResource(context.Background(), &secretsmanager.TagResourceInput{
+				SecretId: aws.String(testSecretArn),
+				Tags:     []types.Tag{{Key: aws.String(rotationLockTag), Value: aws.String(otherLock)}},
+			})
+		}, wantErr: "is being rotated by version", wantLock: otherLock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetForceRotationEnv(t)
+			setEnv(t, map[string]string{"ROTATION_LOCK": "true", "SKIP_TEST_SECRET": "true"})
+			smClient, atlas, mongoAdmin := newForceRotationFakes(t)
+			if tt.setup != nil {
+				tt.setup(smClient, atlas)
+			}
+
+			_, err := ForceRotation(context.Background(), smClient, mongoAdmin, testSecretArn)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("ForceRotation() error = %v, want %q", err, tt.wantErr)
+			}
+			// Only the lock taken by the forced rotation is released, whatever the outcome of the steps
+			if lock := smClient.Tags(testSecretArn)[rotationLockTag]; lock != tt.wantLock {
+				t.Errorf("rotation lock = %q, want %q", lock, tt.wantLock)
+			}
+		})
+	}
+}
+
+func TestForceRotationRetryBudget(t *testing.T) {
+	tests := []struct {
+		name          string
+		budget        string
+		wantExhausted bool
+	}{
+		{name: "budget spent", budget: "2", wantExhausted: true},
+		{name: "budget left", budget: "10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetForceRotationEnv(t)
+			fastUserChangePolls(t)
+			setEnv(t, map[string]string{"SKIP_TEST_SECRET": "true", "WAIT_FOR_USER_ACTIVE": "true", "USER_ACTIVE_TIMEOUT_SECONDS": "60",
+				"RETRY_BUDGET_ATTEMPTS": tt.budget, "RETRY_BUDGET_SECONDS": "0"})
+			smClient, atlas, mongoAdmin := newForceRotationFakes(t)
+			// setSecret reads the user before updating it, the update is then not returned for a few polls
+			atlas.SucceedNext("GetDatabaseUser")
+			for range 3 {
+				atlas.FailNext("GetDatabaseUser", http.StatusNotFound)
+			}
+
+			_, err := ForceRotation(context.Background(), smClient, mongoAdmin, testSecretArn)
+			if errors.Is(err, ErrRetryBudgetExhausted) != tt.wantExhausted {
+				t.Errorf("ForceRotation() error = %v, want ErrRetryBudgetExhausted %v", err, tt.wantExhausted)
+			}
+		})
+	}
+}
+
+func TestNewClientRequestToken(t *testing.T) {
+	seen := map[string]bool{}
+	for range 100 {
+		token, err := NewClientRequestToken()
+		if err != nil {
+			t.Fatalf("NewClientRequestToken() error = %v", err)
+		}
+		if err = ValidateClientRequestToken(token); err != nil {
+			t.Fatalf("NewClientRequestToken() = %q: %v", token, err)
+		}
+		// Version 4 and RFC 4122 variant
+		if token[14] != '4' || !strings.ContainsRune("89ab", rune(token[19])) {
+			t.Errorf("NewClientRequestToken() = %q, want a version 4 UUID", token)
+		}
+		if seen[token] {
+			t.Errorf("NewClientRequestToken() returned %q twice", token)
+		}
+		seen[token] = true
+	}
+}
@@ -0,0 +1,156 @@
+// helpers_test.go
+package rotation
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+// unsetEnv unsets the environment variables for the duration of the test, they are restored on cleanup. The
+// configuration is loaded again with them, and again once they are restored.
+func unsetEnv(t *testing.T, names ...string) {
+	t.Helper()
+	// Registered before t.Setenv, so it runs after the variables are restored
+	t.Cleanup(loadSettings)
+	for _, name := range names {
+		t.Setenv(name, "")
+		if err := os.Unsetenv(name); err != nil {
+			t.Fatalf("failed to unset %v: %v", name, err)
+		}
+	}
+	loadSettings()
+}
+
+// setEnv sets the environment variables for the duration of the test, they are restored on cleanup. The
+// configuration is loaded again with them, and again once they are restored.
+func setEnv(t *testing.T, variables map[string]string) {
+	t.Helper()
+	t.Cleanup(loadSettings)
+	for name, value := range variables {
+		t.Setenv(name, value)
+	}
+	loadSettings()
+}
+
+// captureLog redirects the standard logger to a buffer for the duration of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	output, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(output)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+// stagedDict parses the version of the test secret holding the staging label.
+func stagedDict(t *testing.T, smClient *testutil.FakeSecretsManager, stage string) map[string]string {
+	t.Helper()
+	secretString, _, ok := smClient.Version(testSecretArn, stage)
+	if !ok {
+		t.Fatalf("no version of %v staged %v", testSecretArn, stage)
+	}
+	secretDict, err := ParseSecretDict(secretString)
+	if err != nil {
+		t.Fatalf("failed to parse %v secret: %v", stage, err)
+	}
+	return secretDict
+}
+
+// awsResponse is the answer of the fake AWS endpoint to an operation, with status 0 meaning 200.
+type awsResponse struct {
+	status int
+	body   string
+}
+
+// awsCall is an AWS API call received by the fake AWS endpoint.
+type awsCall struct {
+	operation string
+	body      string
+}
+
+// fakeAWSTransport answers the AWS JSON protocol calls by operation name and records them.
+type fakeAWSTransport struct {
+	mu        sync.Mutex
+	responses map[string]awsResponse
+	calls     []awsCall
+}
+
+func (f *fakeAWSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, operation, _ := strings.Cut(req.Header.Get("X-Amz-Target"), ".")
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, awsCall{operation: operation, body: string(body)})
+	response, ok := f.responses[operation]
+	if !ok {
+		response = awsResponse{status: http.StatusBadRequest, body: `{"__type": "ValidationException", "message": "unexpected ` + operation + ` call"}`}
+	}
+	if response.status == 0 {
+		response.status = http.StatusOK
+	}
+	return &http.Response{
+		StatusCode: response.status,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		Body:       io.NopCloser(strings.NewReader(response.body)),
+		Request:    req,
+	}, nil
+}
+
+// Calls returns the calls received so far.
+func (f *fakeAWSTransport) Calls() []awsCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]awsCall(nil), f.calls...)
+}
+
+// fakeAWS points the AWS configuration of the package to a fake endpoint answering the given operations, so the
+// clients created from it don't reach AWS. The configuration is restored on cleanup.
+func fakeAWS(t *testing.T, responses map[string]awsResponse) *fakeAWSTransport {
+	t.Helper()
+	transport := &fakeAWSTransport{responses: responses}
+	previous := cfg
+	cfg = aws.Config{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		HTTPClient:       &http.Client{Transport: transport},
+		RetryMaxAttempts: 1,
+	}
+	t.Cleanup(func() { cfg = previous })
+	return transport
+}
+
+// fakePings replaces the ping of the GetConnection candidates for the duration of the test, so no database is needed.
+// The pings fail with the given errors in turn, a nil error or any later ping succeeds. The pings done are counted.
+func fakePings(t *testing.T, failures ...error) *int {
+	t.Helper()
+	var pings int
+	previous := pingConnection
+	pingConnection = func(context.Context, *mongo.Client) error {
+		pings++
+		if pings <= len(failures) {
+			return failures[pings-1]
+		}
+		return nil
+	}
+	t.Cleanup(func() { pingConnection = previous })
+	return &pings
+}
@@ -0,0 +1,118 @@
+// history.go
+package rotation
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// passwordHistoryField is the secret field holding the salted hashes of the previous passwords.
+const passwordHistoryField = "password_history"
+
+// GetPasswordHistory
+//
+// Get the salted hashes of the previous passwords stored in the secret
+//
+//	The 'password_history' field holds a JSON list of "salt:hash" entries, newest first, where hash is the
+//	HMAC-SHA256 of the password keyed by the random salt, both base64 encoded. No password is stored in clear.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    []string: The history entries, empty if the field is not present
+//	    error: Error if the field could not be parsed
+func GetPasswordHistory(secretDict map[string]string) ([]string, error) {
+	historyJson := strings.TrimSpace(secretDict[passwordHistoryField])
+	if historyJson == "" {
+		return nil, nil
+	}
+	var history []string
+	if err := json.Unmarshal([]byte(historyJson), &history); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %v: %w", passwordHistoryField, err)
+	}
+	return history, nil
+}
+
+// PasswordInHistory
+//
+// Check if the password matches any entry of the history
+//
+//	Args:
+//	    history ([]string): The history entries
+//
+//	    password (string): The password to check
+//
+//	Returns:
+//	    bool: True if the password was used before
+func PasswordInHistory(history []string, password string) bool {
+	for _, entry := range history {
+		encodedSalt, encodedHash, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+		if err != nil {
+			continue
+		}
+		hash, err := base64.StdEncoding.DecodeString(encodedHash)
+		if err != nil {
+			continue
+		}
+		if hmac.Equal(hash, hashPassword(salt, password)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPasswordHistory
+//
+// Record the password in the secret history, keeping only the newest entries
+//
+//	The history size is read from PASSWORD_HISTORY_SIZE environment variable. When it is 0 or unset the history is
+//	disabled and the field is removed from the secret.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary, updated in place
+//
+//	    history ([]string): The current history entries
+//
+//	    password (string): The password to record
+//
+//	Returns:
+//	    error: Error if the salt could not be generated or the history could not be marshalled
+func AddPasswordHistory(secretDict map[string]string, history []string, password string) error {
+	size := settings.PasswordHistorySize
+	if size <= 0 {
+		delete(secretDict, passwordHistoryField)
+		return nil
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate password history salt: %w", err)
+	}
+	entry := base64.StdEncoding.EncodeToString(salt) + ":" + base64.StdEncoding.EncodeToString(hashPassword(salt, password))
+	history = append([]string{entry}, history...)
+	if len(history) > size {
+		history = history[:size]
+	}
+	historyJson, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %v: %w", passwordHistoryField, err)
+	}
+	secretDict[passwordHistoryField] = string(historyJson)
+	return nil
+}
+
+// hashPassword returns the HMAC-SHA256 of the password keyed by the salt.
+func hashPassword(salt []byte, password string) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,164 @@
+// history_test.go
+package rotation
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// passwordHistory records the passwords, oldest first, in a history of the given size.
+func passwordHistory(t *testing.T, size string, passwords ...string) map[string]string {
+	t.Helper()
+	setEnv(t, map[string]string{"PASSWORD_HISTORY_SIZE": size})
+	secretDict := map[string]string{}
+	for _, password := range passwords {
+		history, err := GetPasswordHistory(secretDict)
+		if err != nil {
+			t.Fatalf("GetPasswordHistory() error = %v", err)
+		}
+		if err = AddPasswordHistory(secretDict, history, password); err != nil {
+			t.Fatalf("AddPasswordHistory() error = %v", err)
+		}
+	}
+	return secretDict
+}
+
+func TestAddPasswordHistory(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      string
+		passwords []string
+		want      []string
+		wantOut   []string
+	}{
+		{name: "disabled", size: "", passwords: []string{"first", "second"}, wantOut: []string{"first", "second"}},
+		{name: "within size", size: "3", passwords: []string{"first", "second"}, want: []string{"first", "second"}},
+		{name: "trimmed to the newest", size: "2", passwords: []string{"first", "second", "third"}, want: []string{"second", "third"}, wantOut: []string{"first"}},
+		{name: "single entry", size: "1", passwords: []string{"first", "second"}, want: []string{"second"}, wantOut: []string{"first"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretDict := passwordHistory(t, tt.size, tt.passwords...)
+			history, err := GetPasswordHistory(secretDict)
+			if err != nil {
+				t.Fatalf("GetPasswordHistory() error = %v", err)
+			}
+			if len(history) != len(tt.want) {
+				t.Errorf("history has %d entries, want %d", len(history), len(tt.want))
+			}
+			for _, password := range tt.want {
+				if !PasswordInHistory(history, password) {
+					t.Errorf("password %q not found in the history", password)
+				}
+			}
+			for _, password := range tt.wantOut {
+				if PasswordInHistory(history, password) {
+					t.Errorf("password %q still in the history", password)
+				}
+			}
+			for _, password := range tt.passwords {
+				if strings.Contains(secretDict[passwordHistoryField], password) {
+					t.Errorf("history %q holds password %q in clear", secretDict[passwordHistoryField], password)
+				}
+			}
+		})
+	}
+}
+
+func TestPasswordHistoryDisabledRemovesField(t *testing.T) {
+	secretDict := passwordHistory(t, "2", "first")
+	setEnv(t, map[string]string{"PASSWORD_HISTORY_SIZE": "0"})
+	history, err := GetPasswordHistory(secretDict)
+	if err != nil {
+		t.Fatalf("GetPasswordHistory() error = %v", err)
+	}
+	if err = AddPasswordHistory(secretDict, history, "second"); err != nil {
+		t.Fatalf("AddPasswordHistory() error = %v", err)
+	}
+	if _, ok := secretDict[passwordHistoryField]; ok {
+		t.Errorf("AddPasswordHistory() kept the %v field with the history disabled", passwordHistoryField)
+	}
+}
+
+func TestGetPasswordHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   string
+		want    int
+		wantErr bool
+	}{
+		{name: "no field"},
+		{name: "empty list", field: "[]"},
+		{name: "entries", field: `["c2FsdA==:aGFzaA==", "c2FsdA==:aGFzaA=="]`, want: 2},
+		{name: "not a list", field: `"c2FsdA==:aGFzaA=="`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := GetPasswordHistory(map[string]string{passwordHistoryField: tt.field})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetPasswordHistory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(history) != tt.want {
+				t.Errorf("GetPasswordHistory() = %v, want %d entries", history, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordInHistorySkipsInvalidEntries(t *testing.T) {
+	history, err := GetPasswordHistory(passwordHistory(t, "2", "used-password"))
+	if err != nil {
+		t.Fatalf("GetPasswordHistory() error = %v", err)
+	}
+	history = append([]string{"no-separator", "!!!:aGFzaA==", "c2FsdA==:!!!"}, history...)
+	if !PasswordInHistory(history, "used-password") {
+		t.Errorf("PasswordInHistory() = false, want the valid entry to match")
+	}
+	if PasswordInHistory(history, "other-password") {
+		t.Errorf("PasswordInHistory() = true for a password not in the history")
+	}
+}
+
+func TestGetNewPasswordNotInHistory(t *testing.T) {
+	history, err := GetPasswordHistory(passwordHistory(t, "3", "older-password", "used-password"))
+	if err != nil {
+		t.Fatalf("GetPasswordHistory() error = %v", err)
+	}
+	unsetEnv(t, "PASSWORD_MAX_ATTEMPTS")
+	smClient := newScriptedPasswords(t, "used-password", "older-password", "new-password")
+
+	got, err := GetNewPassword(context.Background(), smClient, "mongodbatlas", "old-password", history)
+	if err != nil {
+		t.Fatalf("GetNewPassword() error = %v", err)
+	}
+	if got != "new-password" || smClient.calls != 3 {
+		t.Errorf("GetNewPassword() = %q after %d passwords, want new-password after 3", got, smClient.calls)
+	}
+}
+
+func TestCreateSecretPasswordHistory(t *testing.T) {
+	unsetEnv(t, "PRECHECK_CURRENT", "CLEANUP_STALE_PENDING", "KEEP_PREVIOUS_PASSWORD", "PASSWORD_MAX_ATTEMPTS", "DEFAULT_ENGINE")
+	fields := passwordHistory(t, "2", "oldest-password", "older-password")
+	smClient := newScriptedPasswords(t, "older-password", "new-password")
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", fields))
+
+	if err := CreateSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken); err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	pendingDict := stagedDict(t, smClient.FakeSecretsManager, "AWSPENDING")
+	if pendingDict["password"] != "new-password" {
+		t.Errorf("pending password = %q, want the first one not in the history", pendingDict["password"])
+	}
+	history, err := GetPasswordHistory(pendingDict)
+	if err != nil {
+		t.Fatalf("GetPasswordHistory() error = %v", err)
+	}
+	// The replaced password joins the history and the oldest entry is dropped
+	if len(history) != 2 || !PasswordInHistory(history, "old-password") || !PasswordInHistory(history, "older-password") {
+		t.Errorf("pending history has %d entries, want old-password and older-password", len(history))
+	}
+	if PasswordInHistory(history, "oldest-password") {
+		t.Errorf("pending history kept the oldest password")
+	}
+}
@@ -0,0 +1,58 @@
+// hostport.go
+package rotation
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultMongoPort is the port used when a host and port secret has no 'port' field
+const defaultMongoPort = 27017
+
+// ExpandHostPortSecret
+//
+// Derive a connection string for a secret holding only separate 'host' and 'port' fields
+//
+//	Secrets of self-managed MongoDB often have no connection string or URL field at all. For them 'connection_string'
+//	is built from 'host', 'port' (27017 when absent), 'username', 'password' and the authentication database of
+//	GetAuthDatabase, so the rotation steps can work with them as with any other secret. The derived field is removed
+//	again before the secret is stored, see CreateSecret. A 'host' already holding a port is used as is.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary, updated in place
+//
+//	Returns:
+//	    []string: The derived fields, empty if the secret has a connection string, a URL or no host
+//	    error: Error if the port is not valid
+func ExpandHostPortSecret(secretDict map[string]string) ([]string, error) {
+	host := strings.TrimSpace(secretDict["host"])
+	if host == "" {
+		return nil, nil
+	}
+	for _, key := range slices.Concat(connectionStringKeys, urlKeys, []string{"uri"}) {
+		if strings.TrimSpace(secretDict[key]) != "" {
+			return nil, nil
+		}
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		port := defaultMongoPort
+		if portValue := strings.TrimSpace(secretDict["port"]); portValue != "" {
+			port, err = strconv.Atoi(portValue)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("invalid port %q: must be an integer between 1 and 65535", portValue)
+			}
+		}
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	connString := fmt.Sprintf("mongodb://%s/?authSource=%s", host, url.QueryEscape(GetAuthDatabase(secretDict)))
+	connString, err := ReplaceConnectionStringCredentials(connString, secretDict["username"], secretDict["password"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid host: %w", err)
+	}
+	secretDict["connection_string"] = connString
+	return []string{"connection_string"}, nil
+}
@@ -0,0 +1,344 @@
+//go:build integration
+
+// integration_test.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+const (
+	integrationMongoImage    = "mongo:7"
+	integrationRootUser      = "root"
+	integrationRootPassword  = "root-password"
+	integrationAuthDatabase  = "admin"
+	integrationCurrentSecret = "old-password"
+)
+
+// integrationMongo is a MongoDB container with authentication enabled, started once per test function.
+type integrationMongo struct {
+	host string
+	port string
+	root *mongo.Client
+}
+
+// startIntegrationMongo starts the MongoDB container, it is terminated on cleanup.
+func startIntegrationMongo(t *testing.T) *integrationMongo {
+	t.Helper()
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        integrationMongoImage,
+			ExposedPorts: []string{"27017/tcp"},
+			Env: map[string]string{
+				"MONGO_INITDB_ROOT_USERNAME": integrationRootUser,
+				"MONGO_INITDB_ROOT_PASSWORD": integrationRootPassword,
+			},
+			// The entrypoint starts a temporary server to create the root user, then the final one
+			WaitingFor: wait.ForLog("Waiting for connections").WithOccurrence(2),
+		},
+		Started: true,
+	})
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("failed to start MongoDB container: %v", err)
+	}
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("failed to get MongoDB container host: %v", err)
+	}
+	port, err := container.MappedPort(ctx, "27017/tcp")
+	if err != nil {
+		t.Fatalf("failed to get MongoDB container port: %v", err)
+	}
+	rootUri := fmt.Sprintf("mongodb://%s:%s@%s:%s/?authSource=%s", integrationRootUser, integrationRootPassword,
+		host, port.Port(), integrationAuthDatabase)
+	root, err := mongo.Connect(options.Client().ApplyURI(rootUri))
+	if err != nil {
+		t.Fatalf("failed to connect to MongoDB as root: %v", err)
+	}
+	t.Cleanup(func() { _ = root.Disconnect(context.Background()) })
+	return &integrationMongo{host: host, port: port.Port(), root: root}
+}
+
+// seedUser creates a readWrite user in the admin database with the given password.
+func (m *integrationMongo) seedUser(t *testing.T, username string, password string) {
+	t.Helper()
+	err := m.root.Database(integrationAuthDatabase).RunCommand(context.Background(), bson.D{
+		{Key: "createUser", Value: username},
+		{Key: "pwd", Value: password},
+		{Key: "roles", Value: bson.A{bson.D{{Key: "role", Value: "readWrite"}, {Key: "db", Value: "app"}}}},
+	}).Err()
+	if err != nil {
+		t.Fatalf("failed to seed user %v: %v", username, err)
+	}
+}
+
+// canLogin tells whether the user can authenticate with the password.
+func (m *integrationMongo) canLogin(t *testing.T, username string, password string) bool {
+	t.Helper()
+	uri := fmt.Sprintf("mongodb://%s:%s@%s:%s/?authSource=%s", url.QueryEscape(username), url.QueryEscape(password),
+		m.host, m.port, integrationAuthDatabase)
+	conn, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to create MongoDB client: %v", err)
+	}
+	defer func() { _ = conn.Disconnect(context.Background()) }()
+	return conn.Ping(context.Background(), nil) == nil
+}
+
+// secret is a documentdb secret of the user, reaching the container with host and port or a connection string.
+func (m *integrationMongo) secret(t *testing.T, shape string, username string, password string) string {
+	t.Helper()
+	secretDict := map[string]string{
+		"engine":   "documentdb",
+		"username": username,
+		"password": password,
+	}
+	switch shape {
+	case "host":
+		secretDict["host"] = m.host
+		secretDict["port"] = m.port
+	case "connection_string":
+		secretDict["connection_string"] = fmt.Sprintf("mongodb://%s:%s@%s:%s/?authSource=%s",
+			url.QueryEscape(username), url.QueryEscape(password), m.host, m.port, integrationAuthDatabase)
+	default:
+		t.Fatalf("unknown secret shape %q", shape)
+	}
+	secretString, err := json.Marshal(secretDict)
+	if err != nil {
+		t.Fatalf("failed to marshal secret: %v", err)
+	}
+	return string(secretString)
+}
+
+func TestIntegrationTestSecret(t *testing.T) {
+	unsetEnv(t, "SKIP_TEST_SECRET", "WARM_TEST_CONNECTION", "CONNECTION_OPTIONS_OVERRIDE", "DEFAULT_ENGINE")
+	mongoContainer := startIntegrationMongo(t)
+	tests := []struct {
+		name            string
+		shape           string
+		password        string
+		pendingPassword string
+		wantErr         bool
+	}{
+		{name: "host and port", shape: "host", password: "pending-password", pendingPassword: "pending-password"},
+		{name: "connection string", shape: "connection_string", password: "pending-password", pendingPassword: "pending-password"},
+		{name: "reserved characters", shape: "host", password: "p@ss:w/rd?%#", pendingPassword: "p@ss:w/rd?%#"},
+		{name: "connection string reserved characters", shape: "connection_string", password: "p@ss:w/rd?%#", pendingPassword: "p@ss:w/rd?%#"},
+		{name: "wrong password", shape: "host", password: "pending-password", pendingPassword: "other-password", wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username := fmt.Sprintf("testsecret%d", i)
+			mongoContainer.seedUser(t, username, tt.password)
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, mongoContainer.secret(t, tt.shape, username, integrationCurrentSecret))
+			smClient.PutVersion(testSecretArn, testPendingToken, mongoContainer.secret(t, tt.shape, username, tt.pendingPassword), "AWSPENDING")
+
+			err := TestSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TestSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), tt.pendingPassword) {
+				t.Errorf("TestSecret() error leaks the password: %v", err)
+			}
+		})
+	}
+}
+
+func TestIntegrationSetSecretOverConnection(t *testing.T) {
+	unsetEnv(t, "MASTER_SECRET_ARN", "WARM_TEST_CONNECTION", "CONNECTION_OPTIONS_OVERRIDE", "DEFAULT_ENGINE")
+	mongoContainer := startIntegrationMongo(t)
+	tests := []struct {
+		name            string
+		shape           string
+		seededPassword  string
+		pendingPassword string
+		wantErr         bool
+	}{
+		{name: "host and port", shape: "host", seededPassword: integrationCurrentSecret, pendingPassword: "new-password"},
+		{name: "connection string", shape: "connection_string", seededPassword: integrationCurrentSecret, pendingPassword: "new-password"},
+		{name: "reserved characters", shape: "connection_string", seededPassword: integrationCurrentSecret, pendingPassword: "n3w@pass:w/rd?%#"},
+		{name: "pending already set", shape: "host", seededPassword: "new-password", pendingPassword: "new-password"},
+		{name: "no working credential", shape: "host", seededPassword: "unknown-password", pendingPassword: "new-password", wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username := fmt.Sprintf("setsecret%d", i)
+			mongoContainer.seedUser(t, username, tt.seededPassword)
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, mongoContainer.secret(t, tt.shape, username, integrationCurrentSecret))
+			smClient.PutVersion(testSecretArn, testPendingToken, mongoContainer.secret(t, tt.shape, username, tt.pendingPassword), "AWSPENDING")
+
+			err := SetSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			wantPassword := tt.pendingPassword
+			if tt.wantErr {
+				wantPassword = tt.seededPassword
+			}
+			if !mongoContainer.canLogin(t, username, wantPassword) {
+				t.Errorf("user %v can't login with %q after SetSecret", username, wantPassword)
+			}
+			if wantPassword != integrationCurrentSecret && mongoContainer.canLogin(t, username, integrationCurrentSecret) {
+				t.Errorf("user %v can still login with the AWSCURRENT password after SetSecret", username)
+			}
+		})
+	}
+}
+
+func TestIntegrationSetSecretWithMasterSecret(t *testing.T) {
+	unsetEnv(t, "WARM_TEST_CONNECTION", "CONNECTION_OPTIONS_OVERRIDE", "DEFAULT_ENGINE")
+	mongoContainer := startIntegrationMongo(t)
+	tests := []struct {
+		name           string
+		masterPassword string
+		wantErr        bool
+	}{
+		{name: "master changes the password", masterPassword: integrationRootPassword},
+		{name: "wrong master password", masterPassword: "wrong-password", wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"MASTER_SECRET_ARN": testMasterArn})
+			username := fmt.Sprintf("master%d", i)
+			// Neither version of the secret can login, only the master can change the password
+			mongoContainer.seedUser(t, username, "unknown-password")
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, mongoContainer.secret(t, "host", username, integrationCurrentSecret))
+			smClient.PutVersion(testSecretArn, testPendingToken, mongoContainer.secret(t, "host", username, "new-password"), "AWSPENDING")
+			smClient.AddSecret(testMasterArn, testCurrentToken, fmt.Sprintf(`{"username": %q, "password": %q}`, integrationRootUser, tt.masterPassword))
+
+			err := SetSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := mongoContainer.canLogin(t, username, "new-password"); got == tt.wantErr {
+				t.Errorf("user %v can login with the AWSPENDING password = %v, want %v", username, got, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIntegrationVerifyPreviousRevoked(t *testing.T) {
+	unsetEnv(t, "FINISH_DELAY_SECONDS", "ENABLE_ROLLBACK", "WARM_TEST_CONNECTION", "CONNECTION_OPTIONS_OVERRIDE", "DEFAULT_ENGINE")
+	mongoContainer := startIntegrationMongo(t)
+	tests := []struct {
+		name    string
+		strict  string
+		wantErr bool
+	}{
+		{name: "warning only", strict: "false"},
+		{name: "strict", strict: "true", wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"VERIFY_OLD_REVOKED": "true", "VERIFY_OLD_REVOKED_STRICT": tt.strict})
+			username := fmt.Sprintf("revoked%d", i)
+			// The password change never reached the database, the AWSCURRENT password still logs in
+			mongoContainer.seedUser(t, username, integrationCurrentSecret)
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, mongoContainer.secret(t, "host", username, integrationCurrentSecret))
+			smClient.PutVersion(testSecretArn, testPendingToken, mongoContainer.secret(t, "host", username, "new-password"), "AWSPENDING")
+			logs := captureLog(t)
+
+			err := FinishSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FinishSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrPreviousNotRevoked) {
+				t.Errorf("FinishSecret() error = %v, want ErrPreviousNotRevoked", err)
+			}
+			if !tt.wantErr && !strings.Contains(logs.String(), "WARNING: finishSecret: "+ErrPreviousNotRevoked.Error()) {
+				t.Errorf("FinishSecret() logs = %q, want the previous credential warning", logs.String())
+			}
+		})
+	}
+}
+
+func TestIntegrationTestSecretHostOverride(t *testing.T) {
+	unsetEnv(t, "SKIP_TEST_SECRET", "USE_EPHEMERAL_TEST_USER", "WARM_TEST_CONNECTION", "CONNECTION_OPTIONS_OVERRIDE", "DEFAULT_ENGINE")
+	mongoContainer := startIntegrationMongo(t)
+	mongoContainer.seedUser(t, "bastion", "pending-password")
+	// The app facing host only resolves from the application network, the Lambda reaches the container through the override
+	secretString := func(password string) string {
+		secretString, err := json.Marshal(map[string]string{
+			"engine":             "documentdb",
+			"username":           "bastion",
+			"password":           password,
+			"connection_string":  "mongodb://bastion:" + url.QueryEscape(password) + "@docdb.app.invalid:27017/?authSource=admin",
+			"test_host_override": mongoContainer.host + ":" + mongoContainer.port,
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal secret: %v", err)
+		}
+		return string(secretString)
+	}
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, secretString(integrationCurrentSecret))
+	smClient.PutVersion(testSecretArn, testPendingToken, secretString("pending-password"), "AWSPENDING")
+
+	if err := TestSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken); err != nil {
+		t.Fatalf("TestSecret() error = %v", err)
+	}
+	if got, _, _ := smClient.Version(testSecretArn, "AWSPENDING"); got != secretString("pending-password") {
+		t.Errorf("pending secret = %q, want it unchanged", got)
+	}
+}
+
+func TestIntegrationReconcile(t *testing.T) {
+	unsetEnv(t, "FINISH_DELAY_SECONDS", "ENABLE_ROLLBACK", "VERIFY_OLD_REVOKED", "WARM_TEST_CONNECTION", "CONNECTION_OPTIONS_OVERRIDE", "DEFAULT_ENGINE")
+	mongoContainer := startIntegrationMongo(t)
+	tests := []struct {
+		name string
+		// databasePassword is the password the database user has when the rotation got stuck
+		databasePassword string
+		want             ReconcileAction
+		wantCurrent      string
+		wantPending      string
+	}{
+		{name: "stuck before finishSecret", databasePassword: "new-password", want: ReconcileCompleted, wantCurrent: testPendingToken},
+		{name: "stuck before setSecret", databasePassword: integrationCurrentSecret, want: ReconcileRolledBack, wantCurrent: testCurrentToken},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username := fmt.Sprintf("stuck%d", i)
+			mongoContainer.seedUser(t, username, tt.databasePassword)
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, mongoContainer.secret(t, "host", username, integrationCurrentSecret))
+			smClient.PutVersion(testSecretArn, testPendingToken, mongoContainer.secret(t, "host", username, "new-password"), "AWSPENDING")
+			smClient.SetCreatedDate(testSecretArn, testPendingToken, time.Now().Add(-2*time.Hour))
+
+			got, err := Reconcile(context.Background(), smClient, nil, describeTestSecret(t, smClient))
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Reconcile() = %q, want %q", got, tt.want)
+			}
+			if _, currentVersion, _ := smClient.Version(testSecretArn, "AWSCURRENT"); currentVersion != tt.wantCurrent {
+				t.Errorf("AWSCURRENT version = %q, want %q", currentVersion, tt.wantCurrent)
+			}
+			if _, pendingVersion, _ := smClient.Version(testSecretArn, "AWSPENDING"); pendingVersion != tt.wantPending {
+				t.Errorf("AWSPENDING version = %q, want %q", pendingVersion, tt.wantPending)
+			}
+		})
+	}
+}
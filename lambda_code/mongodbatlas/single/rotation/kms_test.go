@@ -0,0 +1,47 @@
+// kms_test.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+func TestKmsAccessError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantKmsMsg bool
+	}{
+		{"decryption failure", &smtypes.DecryptionFailure{Message: aws.String("unable to decrypt")}, true},
+		{"kms access denied", &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "Access to KMS is not allowed"}, true},
+		{"other access denied", &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized to perform secretsmanager:GetSecretValue"}, false},
+		{"not found", &smtypes.ResourceNotFoundException{Message: aws.String("secret not found")}, false},
+		{"plain error", errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+			smClient.FailNext("GetSecretValue", tt.err)
+
+			_, err := GetSecretString(context.Background(), smClient, RotationConfig{arn: aws.String(testSecretArn), stage: "AWSCURRENT"})
+			if err == nil {
+				t.Fatalf("GetSecretString() error = nil, want the GetSecretValue error")
+			}
+			if !strings.Contains(err.Error(), tt.err.Error()) {
+				t.Errorf("GetSecretString() error = %v, want it to contain %v", err, tt.err)
+			}
+			if got := strings.Contains(err.Error(), "kms:Decrypt"); got != tt.wantKmsMsg {
+				t.Errorf("GetSecretString() error mentions kms:Decrypt = %v, want %v: %v", got, tt.wantKmsMsg, err)
+			}
+		})
+	}
+}
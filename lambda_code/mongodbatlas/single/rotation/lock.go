@@ -0,0 +1,145 @@
+// lock.go
+package rotation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+const (
+	rotationLockTag               = "SecretsRotationLock"
+	defaultRotationLockTtlSeconds = 900
+)
+
+// AcquireRotationLock
+//
+// Take the advisory rotation lock of the secret for the rotation version
+//
+//	The lock is the SecretsRotationLock tag of the secret, holding the version token and the time it was taken
+//	('<token>|<RFC3339 time>'). A lock of another version younger than ROTATION_LOCK_TTL_SECONDS (default 900)
+//	refuses the step, an older one is considered left by a crashed rotation and taken over. The lock of the same
+//	version is refreshed on every step.
+//
+//	Tagging is a plain write: two rotations finding the secret unlocked both set the tag and the last one wins. The
+//	secret is described again once tagged and the step only goes on when the tag still names the version, the
+//	rotation whose tag was overwritten backs off as if it had found the lock taken.
+//
+//	Enabled with ROTATION_LOCK environment variable. The module grants the Lambda role secretsmanager:TagResource and
+//	secretsmanager:UntagResource of the lock tag on settings.allowed_secrets when it is set in
+//	settings.environment.variables, no grant is made without allowed secrets.
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    secret (*secretsmanager.DescribeSecretOutput): The secret metadata, holding the current tags
+//
+//	    arn (string): The secret ARN or other identifier
+//
+//	    token (string): The ClientRequestToken of the rotation version
+//
+//	Returns:
+//	    error: Error if another rotation holds the lock or the tag could not be set
+func AcquireRotationLock(ctx context.Context, smClient SecretsManagerAPI, secret *secretsmanager.DescribeSecretOutput, arn string, token string) error {
+	if lockToken, lockTime, locked := rotationLock(secret); locked && lockToken != token {
+		if rotationLockFresh(lockTime) {
+			return fmt.Errorf("secret %v is being rotated by version %v since %v", arn, lockToken, lockTime)
+		}
+		log.Printf("AcquireRotationLock: Taking over expired lock of version %v on %v", lockToken, arn)
+	}
+	value := token + "|" + time.Now().UTC().Format(time.RFC3339)
+	_, err := smClient.TagResource(ctx, &secretsmanager.TagResourceInput{
+		SecretId: &arn,
+		Tags:     []types.Tag{{Key: aws.String(rotationLockTag), Value: &value}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set rotation lock on %v: %w", arn, err)
+	}
+	locked, err := DescribeSecretWithRetry(ctx, smClient, arn)
+	if err != nil {
+		return fmt.Errorf("failed to check rotation lock on %v: %w", arn, err)
+	}
+	if !HoldsRotationLock(locked, token) {
+		lockToken, lockTime, _ := rotationLock(locked)
+		return fmt.Errorf("secret %v is being rotated by version %v since %v", arn, lockToken, lockTime)
+	}
+	return nil
+}
+
+// RotationLocked
+//
+// Tell whether a rotation of any version holds the rotation lock of the secret
+//
+//	Args:
+//	    secret (*secretsmanager.DescribeSecretOutput): The secret metadata, holding the current tags
+//
+//	Returns:
+//	    string: The version holding the lock
+//	    bool: True if the lock is younger than ROTATION_LOCK_TTL_SECONDS
+func RotationLocked(secret *secretsmanager.DescribeSecretOutput) (string, bool) {
+	lockToken, lockTime, locked := rotationLock(secret)
+	return lockToken, locked && rotationLockFresh(lockTime)
+}
+
+// HoldsRotationLock
+//
+// Tell whether the rotation lock of the secret is held by the rotation version
+//
+//	Args:
+//	    secret (*secretsmanager.DescribeSecretOutput): The secret metadata, holding the current tags
+//
+//	    token (string): The ClientRequestToken of the rotation version
+//
+//	Returns:
+//	    bool: True if the SecretsRotationLock tag names the version
+func HoldsRotationLock(secret *secretsmanager.DescribeSecretOutput, token string) bool {
+	lockToken, _, locked := rotationLock(secret)
+	return locked && lockToken == token
+}
+
+// ReleaseRotationLock
+//
+// Remove the advisory rotation lock of the secret once the rotation finished or one of its steps failed
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    arn (string): The secret ARN or other identifier
+//
+//	Returns:
+//	    error: Error if the tag could not be removed
+func ReleaseRotationLock(ctx context.Context, smClient SecretsManagerAPI, arn string) error {
+	_, err := smClient.UntagResource(ctx, &secretsmanager.UntagResourceInput{
+		SecretId: &arn,
+		TagKeys:  []string{rotationLockTag},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove rotation lock from %v: %w", arn, err)
+	}
+	return nil
+}
+
+// rotationLock splits the SecretsRotationLock tag of the secret into the version token and the time it was taken.
+func rotationLock(secret *secretsmanager.DescribeSecretOutput) (string, string, bool) {
+	for _, tag := range secret.Tags {
+		if tag.Key == nil || *tag.Key != rotationLockTag || tag.Value == nil {
+			continue
+		}
+		lockToken, lockTime, _ := strings.Cut(*tag.Value, "|")
+		return lockToken, lockTime, true
+	}
+	return "", "", false
+}
+
+// rotationLockFresh tells whether a lock taken at lockTime is younger than ROTATION_LOCK_TTL_SECONDS.
+func rotationLockFresh(lockTime string) bool {
+	lockedAt, err := time.Parse(time.RFC3339, lockTime)
+	ttl := settings.RotationLockTTL
+	return err == nil && time.Since(lockedAt) < ttl
+}
@@ -0,0 +1,224 @@
+// lock_test.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+// tagRotationLock sets the rotation lock tag of the test secret.
+func tagRotationLock(t *testing.T, smClient *testutil.FakeSecretsManager, value string) {
+	t.Helper()
+	_, err := smClient.TagResource(context.Background(), &secretsmanager.TagResourceInput{
+		SecretId: aws.String(testSecretArn),
+		Tags:     []types.Tag{{Key: aws.String(rotationLockTag), Value: aws.String(value)}},
+	})
+	if err != nil {
+		t.Fatalf("TagResource() error = %v", err)
+	}
+}
+
+func TestAcquireRotationLock(t *testing.T) {
+	const otherToken = "6f1e2d3c-4b5a-4978-8a9b-0c1d2e3f4a5b"
+	fresh := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+	expired := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	tests := []struct {
+		name    string
+		lock    string
+		ttl     string
+		wantErr bool
+	}{
+		{name: "no lock"},
+		{name: "same version", lock: testPendingToken + "|" + fresh},
+		{name: "fresh lock of another version", lock: otherToken + "|" + fresh, wantErr: true},
+		{name: "expired lock of another version", lock: otherToken + "|" + expired},
+		{name: "lock younger than a custom ttl", lock: otherToken + "|" + expired, ttl: "7200", wantErr: true},
+		{name: "lock without time", lock: otherToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "ROTATION_LOCK_TTL_SECONDS")
+			if tt.ttl != "" {
+				setEnv(t, map[string]string{"ROTATION_LOCK_TTL_SECONDS": tt.ttl})
+			}
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+			if tt.lock != "" {
+				tagRotationLock(t, smClient, tt.lock)
+			}
+			before := time.Now().UTC().Truncate(time.Second)
+
+			err := AcquireRotationLock(context.Background(), smClient, describeTestSecret(t, smClient), testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AcquireRotationLock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			lock := smClient.Tags(testSecretArn)[rotationLockTag]
+			if tt.wantErr {
+				if lock != tt.lock {
+					t.Errorf("lock = %q, want the lock of the other version kept %q", lock, tt.lock)
+				}
+				return
+			}
+			lockToken, lockTime, _ := strings.Cut(lock, "|")
+			lockedAt, err := time.Parse(time.RFC3339, lockTime)
+			if lockToken != testPendingToken || err != nil || lockedAt.Before(before) {
+				t.Errorf("lock = %q, want %v locked now", lock, testPendingToken)
+			}
+		})
+	}
+}
+
+// lockRace is a Secrets Manager fake holding every TagResource call until all the acquirers tagged the secret.
+type lockRace struct {
+	*testutil.FakeSecretsManager
+	tagged sync.WaitGroup
+}
+
+func (r *lockRace) TagResource(ctx context.Context, params *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error) {
+	output, err := r.FakeSecretsManager.TagResource(ctx, params, optFns...)
+	r.tagged.Done()
+	r.tagged.Wait()
+	return output, err
+}
+
+func TestAcquireRotationLockConcurrent(t *testing.T) {
+	unsetEnv(t, "ROTATION_LOCK_TTL_SECONDS")
+	tokens := []string{testPendingToken, "6f1e2d3c-4b5a-4978-8a9b-0c1d2e3f4a5b"}
+	smClient := &lockRace{FakeSecretsManager: testutil.NewFakeSecretsManager()}
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+	smClient.tagged.Add(len(tokens))
+	// Both acquirers find the secret unlocked
+	secret := describeTestSecret(t, smClient.FakeSecretsManager)
+
+	errs := make([]error, len(tokens))
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = AcquireRotationLock(context.Background(), smClient, secret, testSecretArn, token)
+		}()
+	}
+	wg.Wait()
+	lockToken, _, _ := strings.Cut(smClient.Tags(testSecretArn)[rotationLockTag], "|")
+	for i, token := range tokens {
+		if holder := token == lockToken; (errs[i] == nil) != holder {
+			t.Errorf("AcquireRotationLock(%v) error = %v, lock held by %v", token, errs[i], lockToken)
+		}
+	}
+}
+
+func TestReleaseRotationLock(t *testing.T) {
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+	tagRotationLock(t, smClient, testPendingToken+"|"+time.Now().UTC().Format(time.RFC3339))
+
+	if err := ReleaseRotationLock(context.Background(), smClient, testSecretArn); err != nil {
+		t.Fatalf("ReleaseRotationLock() error = %v", err)
+	}
+	if lock, ok := smClient.Tags(testSecretArn)[rotationLockTag]; ok {
+		t.Errorf("lock = %q, want it removed", lock)
+	}
+}
+
+func TestRunRotationStepRotationLock(t *testing.T) {
+	unsetEnv(t, "ROTATION_LOCK_TTL_SECONDS", "ENABLED_STEPS", "SKIP_TEST_SECRET", "FINISH_DELAY_SECONDS", "PRECHECK_CURRENT",
+		"CLEANUP_STALE_PENDING", "KEEP_PREVIOUS_PASSWORD", "PASSWORD_LENGTH", "PASSWORD_PREFIX", "PASSWORD_SUFFIX", "DEFAULT_ENGINE")
+	setEnv(t, map[string]string{"ROTATION_LOCK": "true", "SKIP_TEST_SECRET": "true"})
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+	smClient.StageVersion(testSecretArn, testPendingToken, "AWSPENDING")
+
+	for _, step := range []string{"createSecret", "testSecret", "finishSecret"} {
+		if err := RunRotationStep(context.Background(), smClient, nil, describeTestSecret(t, smClient), step, testSecretArn, testPendingToken); err != nil {
+			t.Fatalf("RunRotationStep(%v) error = %v", step, err)
+		}
+		lock, locked := smClient.Tags(testSecretArn)[rotationLockTag]
+		if wantLocked := step != "finishSecret"; locked != wantLocked || (locked && !strings.HasPrefix(lock, testPendingToken+"|")) {
+			t.Errorf("after %v lock = %q, locked = %v, want %v", step, lock, locked, wantLocked)
+		}
+	}
+
+	// A second rotation started while the first one holds the lock is refused
+	const otherToken = "6f1e2d3c-4b5a-4978-8a9b-0c1d2e3f4a5b"
+	tagRotationLock(t, smClient, testPendingToken+"|"+time.Now().UTC().Format(time.RFC3339))
+	smClient.StageVersion(testSecretArn, otherToken, "AWSPENDING")
+	err := RunRotationStep(context.Background(), smClient, nil, describeTestSecret(t, smClient), "createSecret", testSecretArn, otherToken)
+	if err == nil || !strings.Contains(err.Error(), "is being rotated by version "+testPendingToken) {
+		t.Errorf("RunRotationStep() error = %v, want the lock to refuse the step", err)
+	}
+}
+
+func TestRunRotationStepReleasesLockOnFailure(t *testing.T) {
+	unsetEnv(t, "ROTATION_LOCK_TTL_SECONDS", "ENABLED_STEPS", "PRECHECK_CURRENT", "CLEANUP_STALE_PENDING",
+		"KEEP_PREVIOUS_PASSWORD", "PASSWORD_LENGTH", "PASSWORD_PREFIX", "PASSWORD_SUFFIX", "DEFAULT_ENGINE")
+	setEnv(t, map[string]string{"ROTATION_LOCK": "true"})
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+	smClient.StageVersion(testSecretArn, testPendingToken, "AWSPENDING")
+	smClient.FailNext("PutSecretValue", errors.New("service unavailable"))
+
+	err := RunRotationStep(context.Background(), smClient, nil, describeTestSecret(t, smClient), "createSecret", testSecretArn, testPendingToken)
+	if err == nil || !strings.Contains(err.Error(), "service unavailable") {
+		t.Fatalf("RunRotationStep() error = %v, want the PutSecretValue error", err)
+	}
+	if lock, ok := smClient.Tags(testSecretArn)[rotationLockTag]; ok {
+		t.Errorf("lock = %q, want it released after the failed step", lock)
+	}
+}
+
+func TestRunRotationStepUnknownStepTakesNoLock(t *testing.T) {
+	unsetEnv(t, "ROTATION_LOCK_TTL_SECONDS", "ENABLED_STEPS")
+	setEnv(t, map[string]string{"ROTATION_LOCK": "true"})
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+	smClient.StageVersion(testSecretArn, testPendingToken, "AWSPENDING")
+
+	err := RunRotationStep(context.Background(), smClient, nil, describeTestSecret(t, smClient), "rotateSecret", testSecretArn, testPendingToken)
+	if err == nil || !strings.Contains(err.Error(), "unrecognized step parameter") {
+		t.Fatalf("RunRotationStep() error = %v, want the step to be refused", err)
+	}
+	if lock, ok := smClient.Tags(testSecretArn)[rotationLockTag]; ok {
+		t.Errorf("lock = %q, want no lock taken for an unknown step", lock)
+	}
+}
+
+func TestRunRotationStepAlreadyCurrentReleasesLock(t *testing.T) {
+	const otherToken = "6f1e2d3c-4b5a-4978-8a9b-0c1d2e3f4a5b"
+	tests := []struct {
+		name       string
+		lockToken  string
+		wantLocked bool
+	}{
+		{name: "held by the promoted version", lockToken: testPendingToken, wantLocked: false},
+		{name: "held by another version", lockToken: otherToken, wantLocked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "ROTATION_LOCK_TTL_SECONDS", "ENABLED_STEPS")
+			setEnv(t, map[string]string{"ROTATION_LOCK": "true"})
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+			smClient.PutVersion(testSecretArn, testPendingToken, atlasSecret(t, "new-password", nil), "AWSCURRENT")
+			tagRotationLock(t, smClient, tt.lockToken+"|"+time.Now().UTC().Format(time.RFC3339))
+
+			// A finishSecret retried after the promotion, e.g. because removing the lock failed
+			if err := RunRotationStep(context.Background(), smClient, nil, describeTestSecret(t, smClient), "finishSecret", testSecretArn, testPendingToken); err != nil {
+				t.Fatalf("RunRotationStep() error = %v", err)
+			}
+			if _, locked := smClient.Tags(testSecretArn)[rotationLockTag]; locked != tt.wantLocked {
+				t.Errorf("locked = %v, want %v", locked, tt.wantLocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// master.go
+package rotation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// LoginWithMasterSecret
+//
+// Login to the database with the master (superuser) secret
+//
+//	The master secret is read at its AWSCURRENT stage. Connection strings and URL fields missing from the master
+//	secret are taken from the pending secret, and all of them are regenerated with the master username and password.
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    masterArn (string): The master secret ARN or other identifier
+//
+//	    pendingDict (map[string]string): The AWSPENDING secret dictionary
+//
+//	Returns:
+//	    *mongo.Client: The connection authenticated as the master user
+//	    error: Error if the master secret could not be read or could not login
+func LoginWithMasterSecret(ctx context.Context, smClient SecretsManagerAPI, masterArn string, pendingDict map[string]string) (*mongo.Client, error) {
+	masterString, err := GetSecretString(ctx, smClient, RotationConfig{
+		arn:   &masterArn,
+		stage: "AWSCURRENT",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get master secret: %w", err)
+	}
+	masterDict, err := ParseSecretDict(masterString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse master secret: %w", err)
+	}
+	if masterDict["username"] == "" || masterDict["password"] == "" {
+		return nil, fmt.Errorf("master secret must contain username and password")
+	}
+	connDict := map[string]string{}
+	for _, key := range slices.Concat(connectionStringKeys, urlKeys) {
+		if value, ok := pendingDict[key]; ok {
+			connDict[key] = value
+		}
+	}
+	maps.Copy(connDict, masterDict)
+	if _, err = GenerateConnectionStrings(connDict, masterDict["password"]); err != nil {
+		return nil, fmt.Errorf("failed to generate master connection strings: %w", err)
+	}
+	conn, err := LoginWithSecret(ctx, connDict)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("SetSecret: Logged in with master user %v", masterDict["username"])
+	return conn, nil
+}
@@ -0,0 +1,45 @@
+// metrics.go
+package rotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// EmitMetric
+//
+// Emit a CloudWatch metric with a single dimension using the Embedded Metric Format
+//
+//	The metric is printed to stdout as an EMF JSON document, CloudWatch Logs extracts it without any API call. The
+//	namespace is taken from METRICS_NAMESPACE environment variable, default SecretsRotation.
+//
+//	Args:
+//	    metricName (string): The metric name, the value emitted is 1 (Count)
+//
+//	    dimensionName (string): The dimension name
+//
+//	    dimensionValue (string): The dimension value
+func EmitMetric(metricName string, dimensionName string, dimensionValue string) {
+	document := map[string]any{
+		"_aws": map[string]any{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]any{
+				{
+					"Namespace":  settings.MetricsNamespace,
+					"Dimensions": [][]string{{dimensionName}},
+					"Metrics":    []map[string]string{{"Name": metricName, "Unit": "Count"}},
+				},
+			},
+		},
+		dimensionName: dimensionValue,
+		metricName:    1,
+	}
+	jsonDocument, err := json.Marshal(document)
+	if err != nil {
+		log.Printf("EmitMetric: Failed to marshal metric %v: %v", metricName, err)
+		return
+	}
+	fmt.Println(string(jsonDocument))
+}
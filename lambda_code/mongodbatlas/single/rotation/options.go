@@ -0,0 +1,110 @@
+// options.go
+package rotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// GetConnectionOptions
+//
+// Get the extra connection string options of the secret
+//
+//	The optional 'options' field holds a JSON object of URI options, e.g. {"maxPoolSize": "10", "appName": "x"}.
+//	Non string values are used with their JSON text.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    map[string]string: The options, empty if the field is not present
+//	    error: Error if the field is not a JSON object
+func GetConnectionOptions(secretDict map[string]string) (map[string]string, error) {
+	optionsJson := strings.TrimSpace(secretDict["options"])
+	if optionsJson == "" {
+		return nil, nil
+	}
+	var rawOptions map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(optionsJson), &rawOptions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal options: %w", err)
+	}
+	connectionOptions := make(map[string]string, len(rawOptions))
+	for key, raw := range rawOptions {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		connectionOptions[key] = value
+	}
+	return connectionOptions, nil
+}
+
+// MergeConnectionOptions
+//
+// Merge extra options into the query of a connection string
+//
+//	Option names are compared case-insensitively, and 'ssl' as the alias of 'tls'. On conflict the option of the
+//	connection string is kept, unless override is set. The existing options keep their original encoding.
+//
+//	Args:
+//	    connString (string): The connection string
+//
+//	    connectionOptions (map[string]string): The options to merge
+//
+//	    override (bool): Let the given options replace the ones of the connection string
+//
+//	Returns:
+//	    string: The connection string with the merged options
+//	    error: Error if the connection string is not valid
+func MergeConnectionOptions(connString string, connectionOptions map[string]string, override bool) (string, error) {
+	if len(connectionOptions) == 0 {
+		return connString, nil
+	}
+	if _, _, _, _, err := SplitConnectionString(connString); err != nil {
+		return "", err
+	}
+	optionKey := func(key string) string {
+		key = strings.ToLower(key)
+		if key == "ssl" {
+			return "tls"
+		}
+		return key
+	}
+	replaced := map[string]bool{}
+	if override {
+		for key := range connectionOptions {
+			replaced[optionKey(key)] = true
+		}
+	}
+	base, query, hasQuery := strings.Cut(connString, "?")
+	var pairs []string
+	existing := map[string]bool{}
+	if query != "" {
+		for _, pair := range strings.Split(query, "&") {
+			name, _, _ := strings.Cut(pair, "=")
+			name, _ = url.QueryUnescape(name)
+			if replaced[optionKey(name)] {
+				continue
+			}
+			existing[optionKey(name)] = true
+			pairs = append(pairs, pair)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(connectionOptions)) {
+		if existing[optionKey(key)] {
+			continue
+		}
+		existing[optionKey(key)] = true
+		pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(connectionOptions[key]))
+	}
+	if !hasQuery {
+		if _, _, _, rest, _ := SplitConnectionString(connString); rest == "" {
+			base += "/"
+		}
+	}
+	return base + "?" + strings.Join(pairs, "&"), nil
+}
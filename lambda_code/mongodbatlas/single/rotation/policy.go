@@ -0,0 +1,60 @@
+// policy.go
+package rotation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// passwordPolicyVariables are the environment variables of the password complexity policy, with the character
+// class each one counts and its value in the configuration.
+var passwordPolicyVariables = []struct {
+	name    string
+	class   string
+	match   func(rune) bool
+	minimum func(Config) int
+}{
+	{"PASSWORD_MIN_UPPERCASE", "uppercase letters", unicode.IsUpper, func(c Config) int { return c.PasswordMinUppercase }},
+	{"PASSWORD_MIN_LOWERCASE", "lowercase letters", unicode.IsLower, func(c Config) int { return c.PasswordMinLowercase }},
+	{"PASSWORD_MIN_DIGITS", "digits", unicode.IsDigit, func(c Config) int { return c.PasswordMinDigits }},
+	{"PASSWORD_MIN_SYMBOLS", "symbols", func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) },
+		func(c Config) int { return c.PasswordMinSymbols }},
+}
+
+// CheckPasswordPolicy
+//
+// Check a generated password against the complexity policy
+//
+//	Guards against a misconfigured generator (e.g. EXCLUDE_PUNCTUATION set while a downstream system requires
+//	symbols) producing passwords the database or the applications reject. The policy is the minimum count of each
+//	character class, read from PASSWORD_MIN_UPPERCASE, PASSWORD_MIN_LOWERCASE, PASSWORD_MIN_DIGITS and
+//	PASSWORD_MIN_SYMBOLS environment variables, all 0 (no requirement) by default.
+//
+//	Args:
+//	    password (string): The generated password
+//
+//	Returns:
+//	    error: Error listing the classes below their minimum, nil when the password complies
+func CheckPasswordPolicy(password string) error {
+	var failures []string
+	for _, variable := range passwordPolicyVariables {
+		minimum := variable.minimum(settings)
+		if minimum <= 0 {
+			continue
+		}
+		count := 0
+		for _, r := range password {
+			if variable.match(r) {
+				count++
+			}
+		}
+		if count < minimum {
+			failures = append(failures, fmt.Sprintf("%d %v, %v requires %d", count, variable.class, variable.name, minimum))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("password does not meet the complexity policy: %v", strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// policy_test.go
+package rotation
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		policy   map[string]string
+		wantErr  []string
+	}{
+		{name: "no policy", password: "alllowercase"},
+		{name: "compliant", password: "Ab1!cd2?",
+			policy: map[string]string{"PASSWORD_MIN_UPPERCASE": "1", "PASSWORD_MIN_LOWERCASE": "3", "PASSWORD_MIN_DIGITS": "2", "PASSWORD_MIN_SYMBOLS": "2"}},
+		{name: "zero is no requirement", password: "alllowercase", policy: map[string]string{"PASSWORD_MIN_SYMBOLS": "0"}},
+		{name: "invalid minimum is no requirement", password: "alllowercase", policy: map[string]string{"PASSWORD_MIN_DIGITS": "some"}},
+		{name: "missing symbols", password: "Abcdef12", policy: map[string]string{"PASSWORD_MIN_SYMBOLS": "1"},
+			wantErr: []string{"0 symbols, PASSWORD_MIN_SYMBOLS requires 1"}},
+		{name: "every failing class listed", password: "abc", policy: map[string]string{"PASSWORD_MIN_UPPERCASE": "2", "PASSWORD_MIN_DIGITS": "1"},
+			wantErr: []string{"0 uppercase letters, PASSWORD_MIN_UPPERCASE requires 2", "0 digits, PASSWORD_MIN_DIGITS requires 1"}},
+		{name: "below the minimum", password: "aB1-", policy: map[string]string{"PASSWORD_MIN_LOWERCASE": "2"},
+			wantErr: []string{"1 lowercase letters, PASSWORD_MIN_LOWERCASE requires 2"}},
+		{name: "symbol classes", password: "$+^`", policy: map[string]string{"PASSWORD_MIN_SYMBOLS": "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "PASSWORD_MIN_UPPERCASE", "PASSWORD_MIN_LOWERCASE", "PASSWORD_MIN_DIGITS", "PASSWORD_MIN_SYMBOLS")
+			setEnv(t, tt.policy)
+			err := CheckPasswordPolicy(tt.password)
+			if (err != nil) != (len(tt.wantErr) > 0) {
+				t.Fatalf("CheckPasswordPolicy(%q) error = %v, want %v", tt.password, err, tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("CheckPasswordPolicy(%q) error = %v, want it to contain %q", tt.password, err, want)
+				}
+			}
+			if err != nil && strings.Contains(err.Error(), tt.password) {
+				t.Errorf("CheckPasswordPolicy() error = %v, want it without the password", err)
+			}
+		})
+	}
+}
+
+func TestGetNewPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts string
+		passwords   []string
+		want        string
+		wantCalls   int
+		wantErr     string
+	}{
+		{name: "compliant at once", passwords: []string{"New-Pass1"}, want: "New-Pass1", wantCalls: 1},
+		{name: "regenerated until compliant", passwords: []string{"newpass1", "NEW-PASS", "New-Pass1"}, want: "New-Pass1", wantCalls: 3},
+		{name: "never compliant", passwords: []string{"newpassword"}, wantCalls: 5, wantErr: "check the generator settings"},
+		{name: "configured attempts", maxAttempts: "2", passwords: []string{"newpassword"}, wantCalls: 2, wantErr: "after 2 attempts"},
+		{name: "last failure is the policy", maxAttempts: "2", passwords: []string{"Old-Pass1", "newpassword"}, wantCalls: 2,
+			wantErr: "PASSWORD_MIN_SYMBOLS requires 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smClient := newScriptedPasswords(t, tt.passwords...)
+			setEnv(t, map[string]string{"PASSWORD_MAX_ATTEMPTS": tt.maxAttempts, "PASSWORD_MIN_UPPERCASE": "1", "PASSWORD_MIN_DIGITS": "1",
+				"PASSWORD_MIN_SYMBOLS": "1"})
+
+			got, err := GetNewPassword(context.Background(), smClient, "mongodbatlas", "Old-Pass1", nil)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("GetNewPassword() error = %v, want %q", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetNewPassword() = %q, want %q", got, tt.want)
+			}
+			if smClient.calls != tt.wantCalls {
+				t.Errorf("GetNewPassword() generated %d passwords, want %d", smClient.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCreateSecretPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		passwords []string
+		wantErr   bool
+	}{
+		{name: "compliant password staged", passwords: []string{"newpassword", "New-Pass1"}},
+		{name: "nothing staged without a compliant password", passwords: []string{"newpassword"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "PRECHECK_CURRENT", "CLEANUP_STALE_PENDING", "KEEP_PREVIOUS_PASSWORD", "PASSWORD_MAX_ATTEMPTS")
+			smClient := newScriptedPasswords(t, tt.passwords...)
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+			setEnv(t, map[string]string{"PASSWORD_MIN_UPPERCASE": "1", "PASSWORD_MIN_SYMBOLS": "1"})
+
+			err := CreateSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			pendingString, _, staged := smClient.Version(testSecretArn, "AWSPENDING")
+			if staged == tt.wantErr {
+				t.Fatalf("AWSPENDING staged = %v, want %v", staged, !tt.wantErr)
+			}
+			if staged && !strings.Contains(pendingString, `"New-Pass1"`) {
+				t.Errorf("AWSPENDING = %q, want the compliant password", pendingString)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// privatelink.go
+package rotation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// SelectPrivateEndpoint
+//
+// Select the private connection strings of the given PrivateLink endpoint among those of a cluster
+//
+//	Args:
+//	    privateEndpoints ([]admin.ClusterDescriptionConnectionStringsPrivateEndpoint): The private endpoint connection
+//	                                                                                   strings of the cluster
+//
+//	    endpointId (string): The VPC endpoint id, e.g. vpce-0123456789abcdef0
+//
+//	Returns:
+//	    admin.ClusterDescriptionConnectionStringsPrivateEndpoint: The connection strings of the endpoint
+//	    error: Error if no connection strings are listed for the endpoint
+func SelectPrivateEndpoint(privateEndpoints []admin.ClusterDescriptionConnectionStringsPrivateEndpoint, endpointId string) (admin.ClusterDescriptionConnectionStringsPrivateEndpoint, error) {
+	var endpointIds []string
+	for _, privateEndpoint := range privateEndpoints {
+		for _, endpoint := range privateEndpoint.GetEndpoints() {
+			if strings.EqualFold(endpoint.GetEndpointId(), endpointId) {
+				return privateEndpoint, nil
+			}
+			endpointIds = append(endpointIds, endpoint.GetEndpointId())
+		}
+	}
+	return admin.ClusterDescriptionConnectionStringsPrivateEndpoint{}, fmt.Errorf("no private connection string for endpoint %v, the cluster lists [%v]",
+		endpointId, strings.Join(endpointIds, ", "))
+}
+
+// ResolvePrivateEndpointUrls
+//
+// Set the private URL fields of the secret from the Atlas connection strings of its PrivateLink endpoint
+//
+//	With PrivateLink the private hostnames are specific to each VPC endpoint, a cluster reachable through several
+//	endpoints has one private connection string per endpoint. When the secret has a 'privatelink_endpoint_id' field,
+//	the 'cluster_name' cluster of the secret project (see ResolveSecretProjectId) is looked up in Atlas and
+//	'private_url' and 'private_url_srv' are set to the connection strings of that endpoint. The private connection
+//	strings are removed so they are built again from the new URLs, see GenerateConnectionString.
+//
+//	Args:
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    secretDict (map[string]string): The secret dictionary, updated in place
+//
+//	Returns:
+//	    error: Error if the cluster could not be read or lists no connection strings for the endpoint
+func ResolvePrivateEndpointUrls(ctx context.Context, mongoAdmin *admin.APIClient, secretDict map[string]string) error {
+	endpointId := strings.TrimSpace(secretDict["privatelink_endpoint_id"])
+	if endpointId == "" {
+		return nil
+	}
+	clusterName := secretDict["cluster_name"]
+	if clusterName == "" {
+		return fmt.Errorf("privatelink_endpoint_id requires cluster_name")
+	}
+	projectDict := maps.Clone(secretDict)
+	if err := ResolveSecretProjectId(ctx, mongoAdmin, projectDict); err != nil {
+		return err
+	}
+	projectId := projectDict["project_id"]
+	cluster, resp, err := mongoAdmin.ClustersApi.GetCluster(ctx, projectId, clusterName).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to get cluster %v: %w", clusterName, AtlasProjectAccessError(err, resp, projectId))
+	}
+	connectionStrings := cluster.GetConnectionStrings()
+	privateEndpoint, err := SelectPrivateEndpoint(connectionStrings.GetPrivateEndpoint(), endpointId)
+	if err != nil {
+		return fmt.Errorf("cluster %v: %w", clusterName, err)
+	}
+	urls := map[string]string{
+		"private_connection_string":     privateEndpoint.GetConnectionString(),
+		"private_connection_string_srv": privateEndpoint.GetSrvConnectionString(),
+	}
+	for key, value := range urls {
+		if value == "" {
+			delete(secretDict, connectionStringSources[key])
+		} else {
+			secretDict[connectionStringSources[key]] = value
+		}
+		delete(secretDict, key)
+	}
+	log.Printf("ResolvePrivateEndpointUrls: Using the private connection strings of endpoint %v on cluster %v", endpointId, clusterName)
+	return nil
+}
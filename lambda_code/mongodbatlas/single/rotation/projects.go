@@ -0,0 +1,535 @@
+// projects.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// AtlasUserTarget
+//
+// Atlas database user resolved for update in a project
+type AtlasUserTarget struct {
+	projectId   string
+	projectName string
+	user        *admin.CloudDatabaseUser
+	// missing is set when the user does not exist and must be created, see CREATE_USER_IF_MISSING
+	missing bool
+}
+
+// ErrAtlasUserNotFound
+//
+// The database user to rotate does not exist in the Atlas project
+var ErrAtlasUserNotFound = errors.New("database user not found")
+
+// GetProjects
+//
+// Get the additional Atlas projects listed in the secret
+//
+//	The optional 'projects' field holds a JSON list of objects, each one with a required 'project_id' and optional
+//	'project_name', 'connection_string', 'connection_string_srv', 'private_connection_string' and
+//	'private_connection_string_srv' fields. The same username and password are rotated in every listed project.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    []map[string]string: The listed projects, empty if the field is not present
+//	    error: Error if the field could not be parsed or an entry has no project_id
+func GetProjects(secretDict map[string]string) ([]map[string]string, error) {
+	projectsJson, ok := secretDict["projects"]
+	if !ok || strings.TrimSpace(projectsJson) == "" {
+		return nil, nil
+	}
+	var projects []map[string]string
+	if err := json.Unmarshal([]byte(projectsJson), &projects); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal projects: %w", err)
+	}
+	for i, project := range projects {
+		if strings.TrimSpace(project["project_id"]) == "" {
+			return nil, fmt.Errorf("projects[%d]: project_id is required", i)
+		}
+	}
+	return projects, nil
+}
+
+// GenerateProjectsConnectionStrings
+//
+// Regenerate the connection strings of every listed project with the new password
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary, the 'projects' field is updated in place
+//
+//	    password (string): The password to use for the connection strings
+//
+//	Returns:
+//	    error: Error if the projects could not be parsed or a connection string could not be generated
+func GenerateProjectsConnectionStrings(secretDict map[string]string, password string) error {
+	projects, err := GetProjects(secretDict)
+	if err != nil || len(projects) == 0 {
+		return err
+	}
+	for _, project := range projects {
+		project["username"] = secretDict["username"]
+		if _, err = GenerateConnectionStrings(project, password); err != nil {
+			return fmt.Errorf("project %v: %w", project["project_id"], err)
+		}
+		delete(project, "username")
+	}
+	projectsJson, err := json.Marshal(projects)
+	if err != nil {
+		return fmt.Errorf("failed to marshal projects: %w", err)
+	}
+	secretDict["projects"] = string(projectsJson)
+	return nil
+}
+
+// ErrProjectNameMismatch
+//
+// The Atlas project of the secret project_id has another name than the secret project_name
+var ErrProjectNameMismatch = errors.New("atlas project name differs from project_name")
+
+// CheckProjectName
+//
+// Compare the name of the fetched Atlas project with the project_name of the secret
+//
+//	Enabled with VERIFY_PROJECT_NAME environment variable, so a wrong project_id pasted by an operator aborts the
+//	rotation instead of rotating the user of another project. Nothing is checked when project_name is empty.
+//
+//	Args:
+//	    project (*admin.Group): The Atlas project fetched by project_id
+//
+//	    projectName (string): The project_name of the secret
+//
+//	Returns:
+//	    error: Error wrapping ErrProjectNameMismatch naming both projects, nil when they match or the check is disabled
+func CheckProjectName(project *admin.Group, projectName string) error {
+	if !settings.VerifyProjectName || strings.TrimSpace(projectName) == "" {
+		return nil
+	}
+	if project.GetName() != projectName {
+		return fmt.Errorf("%w: project %v is named %v, the secret expects %v", ErrProjectNameMismatch, project.GetId(), project.GetName(), projectName)
+	}
+	return nil
+}
+
+// GetAtlasUserTargets
+//
+// Resolve the database user in the secret project and every listed project
+//
+//	All the projects and users are resolved before any update is done, so a missing project or user aborts the
+//	rotation before any project gets the new password.
+//
+//	Args:
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	    username (string): The user to resolve
+//
+//	    authDatabase (string): The authentication database of the user
+//
+//	Returns:
+//	    []AtlasUserTarget: The resolved users
+//	    error: Error if any project or user could not be resolved
+func GetAtlasUserTargets(ctx context.Context, mongoAdmin *admin.APIClient, secretDict map[string]string, username string, authDatabase string) ([]AtlasUserTarget, error) {
+	projects, err := GetProjects(secretDict)
+	if err != nil {
+		return nil, err
+	}
+	projects = append([]map[string]string{secretDict}, projects...)
+	var targets []AtlasUserTarget
+	seen := map[string]bool{}
+	for _, p := range projects {
+		projectId := p["project_id"]
+		projectName := p["project_name"]
+		if seen[projectId] {
+			continue
+		}
+		seen[projectId] = true
+		project, resp, err := mongoAdmin.ProjectsApi.GetProject(ctx, projectId).Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get project %v - %v : %w", projectId, projectName, AtlasProjectAccessError(err, resp, projectId))
+		}
+		if err = CheckProjectName(project, projectName); err != nil {
+			return nil, err
+		}
+		user, resp, err := mongoAdmin.DatabaseUsersApi.GetDatabaseUser(ctx, *project.Id, authDatabase, username).Execute()
+		missing := false
+		if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+			if !settings.CreateUserIfMissing {
+				return nil, fmt.Errorf("%w: user %v (auth database %v) does not exist in project %v - %v, it must be created before it can be rotated, or set CREATE_USER_IF_MISSING to create it",
+					ErrAtlasUserNotFound, username, authDatabase, *project.Id, projectName)
+			}
+			if user, err = NewAtlasUserFromSecret(secretDict, *project.Id, authDatabase, username); err != nil {
+				return nil, fmt.Errorf("%w: user %v - %v can't be created: %w", ErrAtlasUserNotFound, username, projectName, err)
+			}
+			missing = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user %v - %v : %w", username, projectName, AtlasProjectAccessError(err, resp, projectId))
+		}
+		targets = append(targets, AtlasUserTarget{
+			projectId:   *project.Id,
+			projectName: projectName,
+			user:        user,
+			missing:     missing,
+		})
+	}
+	return targets, nil
+}
+
+// RevertAtlasUserTargets
+//
+// Revert the users setSecret already changed when the update of a later user failed
+//
+//	The users updated in place get the AWSCURRENT password back and the users created because they were missing are
+//	deleted, so a setSecret failing midway leaves no project on the pending password while AWSCURRENT is still in
+//	use. The users created by the create-then-swap schemes are passed as missing users and deleted too; an existing
+//	alternate user that already got the pending password is not passed, the current user is untouched by them.
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    arn (string): The secret ARN or other identifier
+//
+//	    applied ([]AtlasUserTarget): The users already changed, in the order they were changed
+//
+//	    cause (error): The error of the failed update
+//
+//	Returns:
+//	    error: The cause, joined with the errors of the users that could not be reverted
+func RevertAtlasUserTargets(ctx context.Context, smClient SecretsManagerAPI, mongoAdmin *admin.APIClient, arn string, applied []AtlasUserTarget, cause error) error {
+	if len(applied) == 0 {
+		return cause
+	}
+	currentDict, err := GetSecretDict(ctx, smClient, RotationConfig{
+		arn:   &arn,
+		stage: "AWSCURRENT",
+	})
+	if err != nil {
+		return errors.Join(cause, fmt.Errorf("SetSecret: Failed to get current secret to revert %d updated users of %v: %w", len(applied), arn, err))
+	}
+	password := currentDict["password"]
+	errs := []error{cause}
+	for _, target := range applied {
+		username := target.user.Username
+		if target.missing {
+			_, resp, err := mongoAdmin.DatabaseUsersApi.DeleteDatabaseUser(ctx, target.projectId, target.user.DatabaseName, username).Execute()
+			if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+				errs = append(errs, fmt.Errorf("SetSecret: Failed to delete created user %v - %v : %w", username, target.projectName, AtlasError(err)))
+				continue
+			}
+			log.Printf("SetSecret: Deleted created user %v in project %v after the failure", username, target.projectId)
+			continue
+		}
+		target.user.Password = &password
+		_, _, err := mongoAdmin.DatabaseUsersApi.UpdateDatabaseUser(ctx, target.projectId, target.user.DatabaseName, username, target.user).Execute()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("SetSecret: Failed to revert user %v - %v to the current password: %w", username, target.projectName, AtlasError(err)))
+			continue
+		}
+		log.Printf("SetSecret: Reverted user %v in project %v to the current password after the failure", username, target.projectId)
+	}
+	return errors.Join(errs...)
+}
+
+// NewAtlasUserFromSecret
+//
+// Build the definition of a missing database user from the secret
+//
+//	The 'roles' field of the secret holds the JSON list of roles of the user, as in the Atlas API, e.g.
+//	[{"roleName": "readWrite", "databaseName": "app"}].
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	    projectId (string): The Atlas project id
+//
+//	    authDatabase (string): The authentication database of the user
+//
+//	    username (string): The username
+//
+//	Returns:
+//	    *admin.CloudDatabaseUser: The user definition, without password
+//	    error: Error if the secret has no valid 'roles' field
+func NewAtlasUserFromSecret(secretDict map[string]string, projectId string, authDatabase string, username string) (*admin.CloudDatabaseUser, error) {
+	rolesJson := strings.TrimSpace(secretDict["roles"])
+	if rolesJson == "" {
+		return nil, fmt.Errorf("the secret has no 'roles' field to create the user with")
+	}
+	var roles []admin.DatabaseUserRole
+	if err := json.Unmarshal([]byte(rolesJson), &roles); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal roles: %w", err)
+	}
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("the secret 'roles' field is empty")
+	}
+	user := admin.NewCloudDatabaseUser(authDatabase, projectId, username)
+	user.Roles = &roles
+	return user, nil
+}
+
+// ErrUnexpectedRoles
+//
+// The roles of the database user differ from the 'expected_roles' of the secret
+var ErrUnexpectedRoles = errors.New("database user roles differ from expected_roles")
+
+// CheckExpectedRoles
+//
+// Compare the roles of the database user with the 'expected_roles' field of the secret
+//
+//	The field holds a JSON list of roles in the same format as 'roles', e.g.
+//	[{"roleName": "readWrite", "databaseName": "app"}]. The comparison ignores order and duplicates, so it catches
+//	roles changed by hand in Atlas.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	    user (*admin.CloudDatabaseUser): The database user
+//
+//	Returns:
+//	    error: Error wrapping ErrUnexpectedRoles listing the differences, nil when the field is not set or the roles
+//	           match
+func CheckExpectedRoles(secretDict map[string]string, user *admin.CloudDatabaseUser) error {
+	expectedJson := strings.TrimSpace(secretDict["expected_roles"])
+	if expectedJson == "" {
+		return nil
+	}
+	var expectedRoles []admin.DatabaseUserRole
+	if err := json.Unmarshal([]byte(expectedJson), &expectedRoles); err != nil {
+		return fmt.Errorf("failed to unmarshal expected_roles: %w", err)
+	}
+	expected := roleSet(expectedRoles)
+	actual := roleSet(user.GetRoles())
+	var missing, unexpected []string
+	for role := range expected {
+		if !actual[role] {
+			missing = append(missing, role)
+		}
+	}
+	for role := range actual {
+		if !expected[role] {
+			unexpected = append(unexpected, role)
+		}
+	}
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return nil
+	}
+	slices.Sort(missing)
+	slices.Sort(unexpected)
+	return fmt.Errorf("%w for user %v: missing [%v], unexpected [%v]", ErrUnexpectedRoles, user.GetUsername(),
+		strings.Join(missing, ", "), strings.Join(unexpected, ", "))
+}
+
+// roleSet returns the roles as a set of "roleName@databaseName[.collectionName]" entries.
+func roleSet(roles []admin.DatabaseUserRole) map[string]bool {
+	set := map[string]bool{}
+	for _, role := range roles {
+		entry := role.GetRoleName() + "@" + role.GetDatabaseName()
+		if collection := role.GetCollectionName(); collection != "" {
+			entry += "." + collection
+		}
+		set[entry] = true
+	}
+	return set
+}
+
+// TestProjects
+//
+// Test the secret against every listed project
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    error: Error if the login failed for any project
+func TestProjects(ctx context.Context, secretDict map[string]string) error {
+	projects, err := GetProjects(secretDict)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		if project["connection_string"] == "" && project["connection_string_srv"] == "" &&
+			project["private_connection_string"] == "" && project["private_connection_string_srv"] == "" {
+			log.Printf("TestSecret: No connection string for project %v, skipping", project["project_id"])
+			continue
+		}
+		project["username"] = secretDict["username"]
+		project["password"] = secretDict["password"]
+		conn, err := LoginWithSecret(ctx, project)
+		if err != nil {
+			return fmt.Errorf("project %v: %w", project["project_id"], err)
+		}
+		_ = conn.Disconnect(ctx)
+		log.Printf("TestSecret: Successfully pinged MongoDB for project %v", project["project_id"])
+	}
+	return nil
+}
+
+// ResolveSecretProjectId
+//
+// Set the 'project_id' field of a secret that has none, from its organization and cluster or its project name
+//
+//	The resolved id is only set in the given dictionary, the stored secret keeps resolving it, so every step reading
+//	the secret must call this before using 'project_id'. The project is resolved from 'org_id' and 'cluster_name'
+//	when the secret has both, see ResolveProjectId, otherwise from 'project_name', scoped to 'org_id' when set, see
+//	ResolveProjectIdByName.
+//
+//	Args:
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    secretDict (map[string]string): The secret dictionary, updated in place
+//
+//	Returns:
+//	    error: Error if the secret has no way to resolve the project or the project could not be resolved
+func ResolveSecretProjectId(ctx context.Context, mongoAdmin *admin.APIClient, secretDict map[string]string) error {
+	if _, ok := secretDict["project_id"]; ok {
+		return nil
+	}
+	orgId := secretDict["org_id"]
+	clusterName := secretDict["cluster_name"]
+	projectName := secretDict["project_name"]
+	switch {
+	case orgId != "" && clusterName != "":
+		projectId, err := ResolveProjectId(ctx, mongoAdmin, orgId, clusterName)
+		if err != nil {
+			return fmt.Errorf("failed to resolve project_id: %w", err)
+		}
+		log.Printf("ResolveSecretProjectId: Resolved project %v from cluster %v", projectId, clusterName)
+		secretDict["project_id"] = projectId
+	case strings.TrimSpace(projectName) != "":
+		projectId, err := ResolveProjectIdByName(ctx, mongoAdmin, orgId, projectName)
+		if err != nil {
+			return fmt.Errorf("failed to resolve project_id: %w", err)
+		}
+		log.Printf("ResolveSecretProjectId: Resolved project %v from project name %v", projectId, projectName)
+		secretDict["project_id"] = projectId
+	default:
+		return fmt.Errorf("no project_id, and no org_id and cluster_name or project_name to resolve it, please update with proper mongodbatlas management module")
+	}
+	return nil
+}
+
+// ResolveProjectId
+//
+// Locate the Atlas project (group) that owns the named cluster in the organization
+//
+//	Args:
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    orgId (string): The Atlas organization id
+//
+//	    clusterName (string): The cluster name
+//
+//	Returns:
+//	    string: The project id owning the cluster
+//	    error: Error if no project or more than one project in the organization has a cluster with that name
+func ResolveProjectId(ctx context.Context, mongoAdmin *admin.APIClient, orgId string, clusterName string) (string, error) {
+	const itemsPerPage = 500
+	var projectIds []string
+	for pageNum := 1; ; pageNum++ {
+		page, _, err := mongoAdmin.ClustersApi.ListClustersForAllProjects(ctx).
+			ItemsPerPage(itemsPerPage).
+			PageNum(pageNum).
+			Execute()
+		if err != nil {
+			return "", fmt.Errorf("failed to list clusters: %w", AtlasError(err))
+		}
+		groups := page.GetResults()
+		for _, group := range groups {
+			if group.GetOrgId() != orgId {
+				continue
+			}
+			for _, cluster := range group.GetClusters() {
+				if cluster.GetName() == clusterName {
+					projectIds = append(projectIds, group.GetGroupId())
+				}
+			}
+		}
+		if len(groups) < itemsPerPage {
+			break
+		}
+	}
+	switch len(projectIds) {
+	case 0:
+		return "", fmt.Errorf("cluster %v not found in organization %v", clusterName, orgId)
+	case 1:
+		return projectIds[0], nil
+	default:
+		return "", fmt.Errorf("cluster name %v is used in more than one project of organization %v: %v, set project_id explicitly", clusterName, orgId, strings.Join(projectIds, ", "))
+	}
+}
+
+// ResolveProjectIdByName
+//
+// Locate the Atlas project with the given name
+//
+//	Project names are unique only inside an organization. When orgId is given the lookup is scoped to that
+//	organization, otherwise every project visible to the API key is searched and a name found in more than one
+//	organization is an error.
+//
+//	Args:
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    orgId (string): The Atlas organization id, empty to search every visible project
+//
+//	    projectName (string): The project name
+//
+//	Returns:
+//	    string: The project id
+//	    error: Error if no project or more than one project has that name
+func ResolveProjectIdByName(ctx context.Context, mongoAdmin *admin.APIClient, orgId string, projectName string) (string, error) {
+	const itemsPerPage = 500
+	var projectIds []string
+	for pageNum := 1; ; pageNum++ {
+		var page *admin.PaginatedAtlasGroup
+		var err error
+		if orgId != "" {
+			page, _, err = mongoAdmin.OrganizationsApi.ListOrganizationProjects(ctx, orgId).
+				Name(projectName).
+				ItemsPerPage(itemsPerPage).
+				PageNum(pageNum).
+				Execute()
+		} else {
+			page, _, err = mongoAdmin.ProjectsApi.ListProjects(ctx).
+				ItemsPerPage(itemsPerPage).
+				PageNum(pageNum).
+				Execute()
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to list projects: %w", AtlasError(err))
+		}
+		groups := page.GetResults()
+		for _, group := range groups {
+			if group.GetName() == projectName {
+				projectIds = append(projectIds, group.GetId())
+			}
+		}
+		if len(groups) < itemsPerPage {
+			break
+		}
+	}
+	scope := "any visible organization"
+	if orgId != "" {
+		scope = "organization " + orgId
+	}
+	switch len(projectIds) {
+	case 0:
+		return "", fmt.Errorf("project %v not found in %v", projectName, scope)
+	case 1:
+		return projectIds[0], nil
+	default:
+		return "", fmt.Errorf("project name %v is used by more than one project in %v: %v, set org_id or project_id explicitly", projectName, scope, strings.Join(projectIds, ", "))
+	}
+}
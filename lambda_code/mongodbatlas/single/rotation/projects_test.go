@@ -0,0 +1,492 @@
+// projects_test.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+const (
+	testOrgId      = "5f0a1b2c3d4e5f6071829304"
+	testOtherOrgId = "5f0a1b2c3d4e5f6071829305"
+)
+
+// seedProjects adds two organizations, both having a 'payments' project with a Cluster0 cluster.
+func seedProjects(atlas *testutil.FakeAtlas) {
+	atlas.AddOrgProject(testOrgId, testProjectId, "payments")
+	atlas.AddOrgProject(testOtherOrgId, "65a1b2c3d4e5f60718293a4c", "payments")
+	atlas.AddOrgProject(testOrgId, "65a1b2c3d4e5f60718293a4d", "ledger")
+	atlas.AddCluster(testProjectId, admin.ClusterDescription20240805{Name: admin.PtrString("Cluster0")})
+	atlas.AddCluster("65a1b2c3d4e5f60718293a4c", admin.ClusterDescription20240805{Name: admin.PtrString("Cluster0")})
+}
+
+func TestResolveProjectId(t *testing.T) {
+	tests := []struct {
+		name        string
+		orgId       string
+		clusterName string
+		// duplicate adds a Cluster0 to the 'ledger' project of the organization
+		duplicate bool
+		want      string
+		wantErr   string
+	}{
+		{name: "cluster of the org", orgId: testOrgId, clusterName: "Cluster0", want: testProjectId},
+		{name: "same cluster name in another org", orgId: testOtherOrgId, clusterName: "Cluster0", want: "65a1b2c3d4e5f60718293a4c"},
+		{name: "unknown cluster", orgId: testOrgId, clusterName: "Cluster1", wantErr: "cluster Cluster1 not found in organization"},
+		{name: "cluster name in two projects", orgId: testOrgId, clusterName: "Cluster0", duplicate: true, wantErr: "used in more than one project"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, seedProjects)
+			if tt.duplicate {
+				atlas.AddCluster("65a1b2c3d4e5f60718293a4d", admin.ClusterDescription20240805{Name: admin.PtrString("Cluster0")})
+			}
+			got, err := ResolveProjectId(context.Background(), mongoAdmin, tt.orgId, tt.clusterName)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("ResolveProjectId() error = %v, want %q", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveProjectId() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveProjectIdByName(t *testing.T) {
+	tests := []struct {
+		name        string
+		orgId       string
+		projectName string
+		want        string
+		wantErr     string
+	}{
+		{name: "unique name", projectName: "ledger", want: "65a1b2c3d4e5f60718293a4d"},
+		{name: "duplicate name scoped to org", orgId: testOrgId, projectName: "payments", want: testProjectId},
+		{name: "duplicate name scoped to other org", orgId: testOtherOrgId, projectName: "payments", want: "65a1b2c3d4e5f60718293a4c"},
+		{name: "duplicate name", projectName: "payments", wantErr: "set org_id or project_id"},
+		{name: "name of another org", orgId: testOtherOrgId, projectName: "ledger", wantErr: "not found in organization " + testOtherOrgId},
+		{name: "unknown name", projectName: "unknown", wantErr: "not found in any visible organization"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, mongoAdmin := testutil.NewFakeAtlasClient(t, seedProjects)
+			got, err := ResolveProjectIdByName(context.Background(), mongoAdmin, tt.orgId, tt.projectName)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveProjectIdByName() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveProjectIdByName() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveProjectIdByName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSecretProjectId(t *testing.T) {
+	tests := []struct {
+		name       string
+		secretDict map[string]string
+		want       string
+		wantErr    bool
+	}{
+		{"explicit project_id", map[string]string{"project_id": "explicit", "project_name": "payments"}, "explicit", false},
+		{"org and cluster", map[string]string{"org_id": testOrgId, "cluster_name": "Cluster0"}, testProjectId, false},
+		{"cluster of another org", map[string]string{"org_id": testOrgId, "cluster_name": "Cluster1"}, "", true},
+		{"cluster without org uses project_name", map[string]string{"cluster_name": "Cluster0", "project_name": "ledger"}, "65a1b2c3d4e5f60718293a4d", false},
+		{"project_name scoped to org", map[string]string{"org_id": testOrgId, "project_name": "payments"}, testProjectId, false},
+		{"project_name in several orgs", map[string]string{"project_name": "payments"}, "", true},
+		{"unknown project_name", map[string]string{"project_name": "unknown"}, "", true},
+		{"nothing to resolve from", map[string]string{"cluster_name": "Cluster0"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, mongoAdmin := testutil.NewFakeAtlasClient(t, seedProjects)
+			err := ResolveSecretProjectId(context.Background(), mongoAdmin, tt.secretDict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveSecretProjectId() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tt.secretDict["project_id"] != tt.want {
+				t.Errorf("project_id = %q, want %q", tt.secretDict["project_id"], tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSecretProjectIdSkipsAtlasWhenSet(t *testing.T) {
+	atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, seedProjects)
+	for _, operation := range []string{"ListProjects", "ListOrganizationProjects", "ListClustersForAllProjects"} {
+		atlas.FailNext(operation, http.StatusInternalServerError)
+	}
+	secretDict := map[string]string{"project_id": testProjectId, "org_id": testOrgId, "cluster_name": "Cluster0"}
+	if err := ResolveSecretProjectId(context.Background(), mongoAdmin, secretDict); err != nil {
+		t.Fatalf("ResolveSecretProjectId() error = %v", err)
+	}
+}
+
+func TestDeletePreviousAtlasUserResolvesProject(t *testing.T) {
+	atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, seedProjects)
+	atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: "admin", Username: "app_1"})
+	secretDict := map[string]string{
+		"engine":            "mongodbatlas",
+		"username":          "app_2",
+		"previous_username": "app_1",
+		"org_id":            testOrgId,
+		"project_name":      "payments",
+	}
+	if err := DeletePreviousAtlasUser(context.Background(), mongoAdmin, secretDict); err != nil {
+		t.Fatalf("DeletePreviousAtlasUser() error = %v", err)
+	}
+	if _, ok := atlas.User(testProjectId, "admin", "app_1"); ok {
+		t.Errorf("previous user app_1 was not deleted from the resolved project")
+	}
+}
+
+func TestSetSecretResolvesProjectId(t *testing.T) {
+	fields := map[string]string{"org_id": testOrgId, "cluster_name": "Cluster0"}
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", fields))
+	pendingSecret := map[string]any{}
+	if err := json.Unmarshal([]byte(atlasSecret(t, "new-password", fields)), &pendingSecret); err != nil {
+		t.Fatalf("failed to unmarshal secret: %v", err)
+	}
+	delete(pendingSecret, "project_id")
+	pendingString, err := json.Marshal(pendingSecret)
+	if err != nil {
+		t.Fatalf("failed to marshal secret: %v", err)
+	}
+	smClient.PutVersion(testSecretArn, testPendingToken, string(pendingString), "AWSPENDING")
+	atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, seedProjects)
+	atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: "admin", Username: "app"})
+
+	if err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+	if user, _ := atlas.User(testProjectId, "admin", "app"); user.GetPassword() != "new-password" {
+		t.Errorf("user password in the resolved project = %q, want the AWSPENDING password", user.GetPassword())
+	}
+}
+
+func TestGetProjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "not present"},
+		{name: "blank", value: "  "},
+		{name: "two projects", value: `[{"project_id": "p1", "project_name": "one"}, {"project_id": "p2"}]`, want: []string{"p1", "p2"}},
+		{name: "missing project_id", value: `[{"project_id": "p1"}, {"project_name": "two"}]`, wantErr: true},
+		{name: "not a list", value: `{"project_id": "p1"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretDict := map[string]string{"username": "app"}
+			if tt.value != "" {
+				secretDict["projects"] = tt.value
+			}
+			projects, err := GetProjects(secretDict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetProjects() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, project := range projects {
+				got = append(got, project["project_id"])
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("GetProjects() project ids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetSecretUpdatesEveryProject(t *testing.T) {
+	const secondProjectId = "65a1b2c3d4e5f60718293a5b"
+	tests := []struct {
+		name     string
+		projects string
+		want     []string
+	}{
+		{name: "secret project only", want: []string{testProjectId}},
+		{name: "listed project", projects: `[{"project_id": "` + secondProjectId + `"}]`, want: []string{testProjectId, secondProjectId}},
+		{name: "secret project listed again", projects: `[{"project_id": "` + testProjectId + `"}, {"project_id": "` + secondProjectId + `"}]`,
+			want: []string{testProjectId, secondProjectId}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{}
+			if tt.projects != "" {
+				fields["projects"] = tt.projects
+			}
+			smClient, atlas, mongoAdmin := newRotationFakes(t, fields, fields)
+			atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: "admin", Username: "app", Password: admin.PtrString("old-password")})
+			atlas.AddProject(secondProjectId, "payments-dr")
+			atlas.AddUser(admin.CloudDatabaseUser{GroupId: secondProjectId, DatabaseName: "admin", Username: "app", Password: admin.PtrString("old-password")})
+
+			if err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+				t.Fatalf("SetSecret() error = %v", err)
+			}
+			for _, projectId := range []string{testProjectId, secondProjectId} {
+				want := "old-password"
+				if slices.Contains(tt.want, projectId) {
+					want = "new-password"
+				}
+				if user, _ := atlas.User(projectId, "admin", "app"); user.GetPassword() != want {
+					t.Errorf("user password in project %v = %q, want %q", projectId, user.GetPassword(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetSecretMissingUser(t *testing.T) {
+	// The user of the test secret authenticates against 'app', where the fake Atlas has no user
+	fields := map[string]string{
+		"auth_database": "app",
+		"roles":         `[{"roleName": "readWrite", "databaseName": "app"}]`,
+	}
+	tests := []struct {
+		name    string
+		create  string
+		fields  map[string]string
+		wantErr string
+	}{
+		{name: "not created by default", fields: fields, wantErr: "CREATE_USER_IF_MISSING"},
+		{name: "created from the roles", create: "true", fields: fields},
+		{name: "no roles to create it", create: "true", fields: map[string]string{"auth_database": "app"}, wantErr: "'roles'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "DEFAULT_AUTH_DATABASE")
+			setEnv(t, map[string]string{"CREATE_USER_IF_MISSING": tt.create})
+			smClient, atlas, mongoAdmin := newRotationFakes(t, tt.fields, tt.fields)
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrAtlasUserNotFound) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SetSecret() error = %v, want ErrAtlasUserNotFound mentioning %v", err, tt.wantErr)
+				}
+				if _, ok := atlas.User(testProjectId, "app", "app"); ok {
+					t.Errorf("user was created although SetSecret failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetSecret() error = %v", err)
+			}
+			user, ok := atlas.User(testProjectId, "app", "app")
+			if !ok {
+				t.Fatalf("missing user was not created")
+			}
+			if user.GetPassword() != "new-password" {
+				t.Errorf("created user password = %q, want the AWSPENDING password", user.GetPassword())
+			}
+			if roles := user.GetRoles(); len(roles) != 1 || roles[0].RoleName != "readWrite" || roles[0].DatabaseName != "app" {
+				t.Errorf("created user roles = %+v, want the secret roles", roles)
+			}
+		})
+	}
+}
+
+func TestNewAtlasUserFromSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   string
+		want    int
+		wantErr bool
+	}{
+		{name: "roles", roles: `[{"roleName": "readWrite", "databaseName": "app"}, {"roleName": "read", "databaseName": "reports"}]`, want: 2},
+		{name: "no roles field", wantErr: true},
+		{name: "empty roles", roles: `[]`, wantErr: true},
+		{name: "invalid roles", roles: `{"roleName": "readWrite"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretDict := map[string]string{"username": "app"}
+			if tt.roles != "" {
+				secretDict["roles"] = tt.roles
+			}
+			user, err := NewAtlasUserFromSecret(secretDict, testProjectId, "admin", "app")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewAtlasUserFromSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if user.GroupId != testProjectId || user.DatabaseName != "admin" || user.Username != "app" {
+				t.Errorf("NewAtlasUserFromSecret() = %v/%v/%v, want the project, auth database and username", user.GroupId, user.DatabaseName, user.Username)
+			}
+			if got := len(user.GetRoles()); got != tt.want {
+				t.Errorf("NewAtlasUserFromSecret() roles = %d, want %d", got, tt.want)
+			}
+			if user.Password != nil {
+				t.Errorf("NewAtlasUserFromSecret() sets a password")
+			}
+		})
+	}
+}
+
+func TestCheckExpectedRoles(t *testing.T) {
+	roles := []admin.DatabaseUserRole{
+		{RoleName: "readWrite", DatabaseName: "app"},
+		{RoleName: "read", DatabaseName: "reports", CollectionName: admin.PtrString("daily")},
+	}
+	tests := []struct {
+		name     string
+		expected string
+		wantErr  string
+	}{
+		{name: "not set"},
+		{name: "same roles", expected: `[{"roleName": "readWrite", "databaseName": "app"}, {"roleName": "read", "databaseName": "reports", "collectionName": "daily"}]`},
+		{name: "other order and duplicates", expected: `[{"roleName": "read", "databaseName": "reports", "collectionName": "daily"}, {"roleName": "readWrite", "databaseName": "app"}, {"roleName": "readWrite", "databaseName": "app"}]`},
+		{name: "missing role", expected: `[{"roleName": "readWrite", "databaseName": "app"}, {"roleName": "read", "databaseName": "reports", "collectionName": "daily"}, {"roleName": "read", "databaseName": "audit"}]`,
+			wantErr: "missing [read@audit], unexpected []"},
+		{name: "unexpected role", expected: `[{"roleName": "readWrite", "databaseName": "app"}]`,
+			wantErr: "missing [], unexpected [read@reports.daily]"},
+		{name: "collection differs", expected: `[{"roleName": "readWrite", "databaseName": "app"}, {"roleName": "read", "databaseName": "reports"}]`,
+			wantErr: "missing [read@reports], unexpected [read@reports.daily]"},
+		{name: "invalid field", expected: `{"roleName": "readWrite"}`, wantErr: "failed to unmarshal expected_roles"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := admin.NewCloudDatabaseUser("admin", testProjectId, "app")
+			user.Roles = &roles
+			err := CheckExpectedRoles(map[string]string{"expected_roles": tt.expected}, user)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckExpectedRoles() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckExpectedRoles() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetSecretExpectedRoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		strict   string
+		wantErr  bool
+		wantWarn bool
+	}{
+		{name: "matching roles", expected: `[{"roleName": "readWrite", "databaseName": "app"}]`, strict: "true"},
+		{name: "mismatch warns", expected: `[{"roleName": "dbAdmin", "databaseName": "app"}]`, strict: "false", wantWarn: true},
+		{name: "mismatch fails when strict", expected: `[{"roleName": "dbAdmin", "databaseName": "app"}]`, strict: "true", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "CREATE_USER_IF_MISSING", "DEFAULT_AUTH_DATABASE")
+			setEnv(t, map[string]string{"EXPECTED_ROLES_STRICT": tt.strict})
+			fields := map[string]string{"expected_roles": tt.expected}
+			smClient, atlas, mongoAdmin := newRotationFakes(t, fields, fields)
+			atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: "admin", Username: "app",
+				Roles: &[]admin.DatabaseUserRole{{RoleName: "readWrite", DatabaseName: "app"}}})
+			logs := captureLog(t)
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrUnexpectedRoles) {
+				t.Errorf("SetSecret() error = %v, want ErrUnexpectedRoles", err)
+			}
+			if warned := strings.Contains(logs.String(), "WARNING: SetSecret: Roles check failed"); warned != tt.wantWarn {
+				t.Errorf("SetSecret() roles warning = %v, want %v", warned, tt.wantWarn)
+			}
+			want := "new-password"
+			if tt.wantErr {
+				want = ""
+			}
+			if user, _ := atlas.User(testProjectId, "admin", "app"); user.GetPassword() != want {
+				t.Errorf("user password = %q, want %q", user.GetPassword(), want)
+			}
+		})
+	}
+}
+
+func TestCheckProjectName(t *testing.T) {
+	project := &admin.Group{Id: admin.PtrString(testProjectId), Name: "payments"}
+	tests := []struct {
+		name        string
+		verify      string
+		projectName string
+		wantErr     bool
+	}{
+		{name: "disabled", projectName: "ledger"},
+		{name: "match", verify: "true", projectName: "payments"},
+		{name: "mismatch", verify: "true", projectName: "ledger", wantErr: true},
+		{name: "case differs", verify: "true", projectName: "Payments", wantErr: true},
+		{name: "no project_name", verify: "true", projectName: " "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"VERIFY_PROJECT_NAME": tt.verify})
+			err := CheckProjectName(project, tt.projectName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckProjectName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && (!errors.Is(err, ErrProjectNameMismatch) || !strings.Contains(err.Error(), testProjectId) || !strings.Contains(err.Error(), tt.projectName)) {
+				t.Errorf("CheckProjectName() error = %v, want ErrProjectNameMismatch naming both projects", err)
+			}
+		})
+	}
+}
+
+func TestSetSecretVerifyProjectName(t *testing.T) {
+	const secondProjectId = "65a1b2c3d4e5f60718293a5b"
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "names match", fields: map[string]string{"projects": `[{"project_id": "` + secondProjectId + `", "project_name": "payments-dr"}]`},
+			want: map[string]string{testProjectId: "new-password", secondProjectId: "new-password"}},
+		{name: "secret project mismatch", fields: map[string]string{"project_name": "ledger"},
+			want: map[string]string{testProjectId: "old-password"}, wantErr: true},
+		// The listed projects are checked before any user is updated
+		{name: "listed project mismatch", fields: map[string]string{"projects": `[{"project_id": "` + secondProjectId + `", "project_name": "ledger"}]`},
+			want: map[string]string{testProjectId: "old-password", secondProjectId: "old-password"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING", "DEFAULT_AUTH_DATABASE")
+			setEnv(t, map[string]string{"VERIFY_PROJECT_NAME": "true"})
+			smClient, atlas, mongoAdmin := newRotationFakes(t, tt.fields, tt.fields)
+			atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: "admin", Username: "app", Password: admin.PtrString("old-password")})
+			atlas.AddProject(secondProjectId, "payments-dr")
+			atlas.AddUser(admin.CloudDatabaseUser{GroupId: secondProjectId, DatabaseName: "admin", Username: "app", Password: admin.PtrString("old-password")})
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrProjectNameMismatch) {
+				t.Errorf("SetSecret() error = %v, want ErrProjectNameMismatch", err)
+			}
+			for projectId, want := range tt.want {
+				if user, _ := atlas.User(projectId, "admin", "app"); user.GetPassword() != want {
+					t.Errorf("user password in project %v = %q, want %q", projectId, user.GetPassword(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// publish.go
+package rotation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// PublishVersion
+//
+// Publish the promoted version id to the SSM parameter named by PUBLISH_VERSION_PARAMETER
+//
+//	For consumers polling a parameter to know when a new secret version is live. The module grants the Lambda role
+//	ssm:PutParameter on the parameter when PUBLISH_VERSION_PARAMETER is set in settings.environment.variables.
+//
+//	Args:
+//	    arn (string): The secret ARN or other identifier
+//
+//	    token (string): The promoted version id
+//
+//	Returns:
+//	    error: Error if the parameter could not be written, nothing is done when PUBLISH_VERSION_PARAMETER is unset
+func PublishVersion(ctx context.Context, arn string, token string) error {
+	parameterName := settings.PublishVersionParameter
+	if parameterName == "" {
+		return nil
+	}
+	ssmClient := ssm.NewFromConfig(cfg)
+	_, err := ssmClient.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:      &parameterName,
+		Value:     &token,
+		Type:      ssmtypes.ParameterTypeString,
+		Overwrite: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish version %v of %v to parameter %v: %w", token, arn, parameterName, err)
+	}
+	return nil
+}
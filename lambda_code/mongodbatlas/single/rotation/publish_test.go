@@ -0,0 +1,66 @@
+// publish_test.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+func TestFinishSecretPublishVersion(t *testing.T) {
+	accessDenied := awsResponse{status: http.StatusBadRequest, body: `{"__type": "AccessDeniedException", "message": "not authorized to perform ssm:PutParameter"}`}
+	tests := []struct {
+		name      string
+		parameter string
+		response  awsResponse
+		wantCalls int
+		wantLog   string
+	}{
+		{name: "disabled"},
+		{name: "published", parameter: "/rotation/atlas-user/version", response: awsResponse{body: `{"Version": 2}`}, wantCalls: 1},
+		{name: "put failure", parameter: "/rotation/atlas-user/version", response: accessDenied, wantCalls: 1,
+			wantLog: "failed to publish version " + testPendingToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "FINISH_DELAY_SECONDS", "ENABLE_ROLLBACK", "VERIFY_OLD_REVOKED", "EVENT_BUS_NAME", "DEFAULT_ENGINE")
+			setEnv(t, map[string]string{"PUBLISH_VERSION_PARAMETER": tt.parameter})
+			transport := fakeAWS(t, map[string]awsResponse{"PutParameter": tt.response})
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+			smClient.PutVersion(testSecretArn, testPendingToken, atlasSecret(t, "new-password", nil), "AWSPENDING")
+			logs := captureLog(t)
+
+			// A failure to publish doesn't fail the rotation
+			if err := FinishSecret(context.Background(), smClient, nil, testSecretArn, testPendingToken); err != nil {
+				t.Fatalf("FinishSecret() error = %v", err)
+			}
+			if _, currentVersion, _ := smClient.Version(testSecretArn, "AWSCURRENT"); currentVersion != testPendingToken {
+				t.Errorf("AWSCURRENT version = %q, want %q", currentVersion, testPendingToken)
+			}
+			calls := transport.Calls()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("AWS calls = %v, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantCalls > 0 {
+				var input struct {
+					Name, Value, Type string
+					Overwrite         bool
+				}
+				if err := json.Unmarshal([]byte(calls[0].body), &input); err != nil {
+					t.Fatalf("PutParameter body %q is not valid: %v", calls[0].body, err)
+				}
+				if input.Name != tt.parameter || input.Value != testPendingToken || input.Type != "String" || !input.Overwrite {
+					t.Errorf("PutParameter input = %+v, want the promoted version in %v", input, tt.parameter)
+				}
+			}
+			if tt.wantLog != "" && !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("FinishSecret() logs = %q, want %q", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
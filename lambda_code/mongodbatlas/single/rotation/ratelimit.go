@@ -0,0 +1,73 @@
+// ratelimit.go
+package rotation
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+const (
+	defaultRateLimitMinRemaining = 5
+	defaultRateLimitDelayMs      = 1000
+)
+
+// rateLimitPacingTransport delays the next Atlas API call when the rate limit headers report few calls remaining
+type rateLimitPacingTransport struct {
+	base         http.RoundTripper
+	minRemaining int
+	delay        time.Duration
+}
+
+// RoundTrip
+//
+// Send the request and pause once the response reports at most minRemaining calls left in the rate limit window
+func (t *rateLimitPacingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	remaining, parseErr := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if parseErr != nil || remaining > t.minRemaining {
+		return resp, nil
+	}
+	log.Printf("AtlasRateLimit: %d calls remaining, pausing %v", remaining, t.delay)
+	if err = sleepContext(req.Context(), t.delay); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// UseRateLimitPacing
+//
+// Atlas client modifier pacing the API calls with the X-RateLimit-Remaining response header
+//
+//	Must be applied after the authentication modifier, as it wraps the transport of the configured HTTP client.
+//
+//	Supported environment variables:
+//	    - ATLAS_RATE_LIMIT_MIN_REMAINING: remaining calls at or below which the calls are paced, default 5
+//	    - ATLAS_RATE_LIMIT_DELAY_MS: pause applied after each paced call in milliseconds, default 1000
+//
+//	Returns:
+//	    admin.ClientModifier: The client modifier
+func UseRateLimitPacing() admin.ClientModifier {
+	return func(c *admin.Configuration) error {
+		if c.HTTPClient == nil {
+			c.HTTPClient = &http.Client{}
+		}
+		base := c.HTTPClient.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		c.HTTPClient.Transport = &rateLimitPacingTransport{
+			base:         base,
+			minRemaining: settings.AtlasRateLimitMinRemaining,
+			delay:        settings.AtlasRateLimitDelay,
+		}
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+// ratelimit_test.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// rateLimitTransport answers every request with the given X-RateLimit-Remaining header and counts the calls.
+type rateLimitTransport struct {
+	remaining string
+	calls     int
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls++
+	header := http.Header{}
+	if t.remaining != "" {
+		header.Set("X-RateLimit-Remaining", t.remaining)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+// pacedClient is the HTTP client of an Atlas configuration paced by UseRateLimitPacing over the transport.
+func pacedClient(t *testing.T, transport http.RoundTripper) *http.Client {
+	t.Helper()
+	config := &admin.Configuration{HTTPClient: &http.Client{Transport: transport}}
+	if err := UseRateLimitPacing()(config); err != nil {
+		t.Fatalf("UseRateLimitPacing() error = %v", err)
+	}
+	return config.HTTPClient
+}
+
+func TestUseRateLimitPacing(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	tests := []struct {
+		name       string
+		remaining  string
+		minEnv     string
+		wantPaused bool
+	}{
+		{name: "plenty remaining", remaining: "100"},
+		{name: "at the minimum", remaining: "5", wantPaused: true},
+		{name: "exhausted", remaining: "0", wantPaused: true},
+		{name: "no header"},
+		{name: "invalid header", remaining: "many"},
+		{name: "custom minimum", remaining: "20", minEnv: "50", wantPaused: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "ATLAS_RATE_LIMIT_MIN_REMAINING")
+			setEnv(t, map[string]string{"ATLAS_RATE_LIMIT_DELAY_MS": "200"})
+			if tt.minEnv != "" {
+				setEnv(t, map[string]string{"ATLAS_RATE_LIMIT_MIN_REMAINING": tt.minEnv})
+			}
+			transport := &rateLimitTransport{remaining: tt.remaining}
+			req, err := http.NewRequest(http.MethodGet, "https://cloud.mongodb.com/api/atlas/v2/groups", nil)
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+
+			start := time.Now()
+			resp, err := pacedClient(t, transport).Do(req)
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			_ = resp.Body.Close()
+			if transport.calls != 1 {
+				t.Errorf("transport calls = %d, want 1", transport.calls)
+			}
+			if paused := elapsed >= delay; paused != tt.wantPaused {
+				t.Errorf("call took %v, want paused = %v", elapsed, tt.wantPaused)
+			}
+		})
+	}
+}
+
+func TestUseRateLimitPacingCanceled(t *testing.T) {
+	setEnv(t, map[string]string{"ATLAS_RATE_LIMIT_DELAY_MS": "60000"})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://cloud.mongodb.com/api/atlas/v2/groups", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	_, err = pacedClient(t, &rateLimitTransport{remaining: "0"}).Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
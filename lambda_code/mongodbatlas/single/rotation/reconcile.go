@@ -0,0 +1,189 @@
+// reconcile.go
+package rotation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// ReconcileStep is the operator invoked step repairing a rotation left half done, {"Step": "reconcile"}
+const ReconcileStep = "reconcile"
+
+// defaultReconcileMinAgeSeconds is the age a pending version must reach before Reconcile considers it stuck
+const defaultReconcileMinAgeSeconds = 3600
+
+// ReconcileAction
+//
+// What Reconcile did with the secret
+type ReconcileAction string
+
+const (
+	// ReconcileNothing no version is left in AWSPENDING
+	ReconcileNothing ReconcileAction = "nothing"
+	// ReconcileInProgress the pending version may still be rotated, it is too young or a rotation holds the lock
+	ReconcileInProgress ReconcileAction = "in_progress"
+	// ReconcileCompleted the database accepts the pending credential, the pending version was promoted
+	ReconcileCompleted ReconcileAction = "completed"
+	// ReconcileRolledBack the database still accepts only the current credential, the pending version was dropped
+	ReconcileRolledBack ReconcileAction = "rolled_back"
+)
+
+// Reconcile
+//
+// Detect and repair a rotation left between setSecret and finishSecret
+//
+//	A rotation can stop with the database using the AWSPENDING credential while AWSCURRENT still holds the previous
+//	one, when finishSecret kept failing until Secrets Manager gave up on the rotation. The secret must be enabled for
+//	rotation (see CheckRotationEnabled). A pending version younger than RECONCILE_MIN_AGE_SECONDS (default 3600), or
+//	any version holding a fresh ROTATION_LOCK lock, may belong to a rotation still running and is left alone. Otherwise
+//	the version left in AWSPENDING is checked against the database, holding the rotation lock with ROTATION_LOCK:
+//
+//	    - the pending credential works: the rotation is completed by running finishSecret for that version;
+//	    - only the current credential works: setSecret never took effect, the AWSPENDING stage is removed so the
+//	      next rotation starts over. For Atlas secrets the database user must first resolve in every project with
+//	      GetAtlasUserTargets and Atlas must report its changes as applied, a password change not applied yet fails
+//	      the login as well;
+//	    - neither works: an error is returned, the secret needs manual repair.
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    secret (*secretsmanager.DescribeSecretOutput): The described secret
+//
+//	Returns:
+//	    ReconcileAction: The action taken
+//	    error: Error if the state could not be determined or repaired
+func Reconcile(ctx context.Context, smClient SecretsManagerAPI, mongoAdmin *admin.APIClient, secret *secretsmanager.DescribeSecretOutput) (ReconcileAction, error) {
+	arn := aws.ToString(secret.ARN)
+	if err := CheckRotationEnabled(secret, arn); err != nil {
+		return "", fmt.Errorf("Reconcile: %w", err)
+	}
+	var token string
+	for version, stages := range secret.VersionIdsToStages {
+		if slices.Contains(stages, "AWSPENDING") && !slices.Contains(stages, "AWSCURRENT") {
+			token = version
+			break
+		}
+	}
+	if token == "" {
+		log.Printf("Reconcile: No pending version for %v, nothing to reconcile", arn)
+		return ReconcileNothing, nil
+	}
+	lock := settings.RotationLock
+	if lockToken, locked := RotationLocked(secret); lock && locked {
+		log.Printf("Reconcile: Version %v holds the rotation lock of %v, leaving the rotation to run", lockToken, arn)
+		return ReconcileInProgress, nil
+	}
+	pending, err := smClient.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &arn, VersionId: &token})
+	if err != nil {
+		return "", fmt.Errorf("Reconcile: Failed to get pending secret for %v: %w", arn, KmsAccessError(err))
+	}
+	if pending.CreatedDate == nil {
+		return "", fmt.Errorf("Reconcile: Pending version %v of %v has no creation date", token, arn)
+	}
+	minAge := settings.ReconcileMinAge
+	if age := time.Since(*pending.CreatedDate); age < minAge {
+		log.Printf("Reconcile: Pending version %v of %v was created %v ago, leaving the rotation to run", token, arn, age.Round(time.Second))
+		return ReconcileInProgress, nil
+	}
+	if lock {
+		if err = AcquireRotationLock(ctx, smClient, secret, arn, token); err != nil {
+			return "", fmt.Errorf("Reconcile: %w", err)
+		}
+		defer func() {
+			if err := ReleaseRotationLock(context.WithoutCancel(ctx), smClient, arn); err != nil {
+				log.Printf("Reconcile: %v", err)
+			}
+		}()
+	}
+	pendingDict, err := GetSecretDict(ctx, smClient, RotationConfig{arn: &arn, stage: "AWSPENDING", token: &token})
+	if err != nil {
+		return "", fmt.Errorf("Reconcile: Failed to get pending secret for %v: %w", arn, err)
+	}
+	pendingErr := testCredential(ctx, pendingDict)
+	if pendingErr != nil && GetEngine(pendingDict) != "documentdb" {
+		if err = waitAtlasUserApplied(ctx, mongoAdmin, pendingDict); err != nil {
+			return "", fmt.Errorf("Reconcile: Failed to check Atlas user of %v, not rolling back: %w", arn, err)
+		}
+		pendingErr = testCredential(ctx, pendingDict)
+	}
+	if pendingErr == nil {
+		log.Printf("Reconcile: Pending version %v of %v is in use by the database, completing the rotation", token, arn)
+		if err = FinishSecret(ctx, smClient, mongoAdmin, arn, token); err != nil {
+			return "", fmt.Errorf("Reconcile: Failed to finish rotation of %v: %w", arn, err)
+		}
+		return ReconcileCompleted, nil
+	}
+	currentDict, err := GetSecretDict(ctx, smClient, RotationConfig{arn: &arn, stage: "AWSCURRENT", permissiveEngine: true})
+	if err != nil {
+		return "", fmt.Errorf("Reconcile: Failed to get current secret for %v: %w", arn, err)
+	}
+	if currentErr := testCredential(ctx, currentDict); currentErr != nil {
+		return "", fmt.Errorf("Reconcile: Neither the pending (%v) nor the current (%v) credential of %v works, manual repair needed", pendingErr, currentErr, arn)
+	}
+	log.Printf("Reconcile: Pending version %v of %v was never set (%v), removing it", token, arn, pendingErr)
+	_, err = smClient.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            &arn,
+		VersionStage:        aws.String("AWSPENDING"),
+		RemoveFromVersionId: &token,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Reconcile: Failed to remove pending stage for %v: %w", arn, err)
+	}
+	return ReconcileRolledBack, nil
+}
+
+// waitAtlasUserApplied resolves the Atlas database user of the secret in every project and auth database with
+// GetAtlasUserTargets, then waits until Atlas applied the user changes on the clusters of each project.
+func waitAtlasUserApplied(ctx context.Context, mongoAdmin *admin.APIClient, secretDict map[string]string) error {
+	if mongoAdmin == nil {
+		return fmt.Errorf("no MongoDB Atlas API client")
+	}
+	if err := ResolveSecretProjectId(ctx, mongoAdmin, secretDict); err != nil {
+		return err
+	}
+	authDatabases, err := GetAuthDatabases(secretDict)
+	if err != nil {
+		return err
+	}
+	// A create-then-swap rotation changes no user before its new one is created
+	username := secretDict["username"]
+	if IsCreateThenSwap(secretDict) {
+		username = secretDict["previous_username"]
+	}
+	for _, authDatabase := range authDatabases {
+		targets, err := GetAtlasUserTargets(ctx, mongoAdmin, secretDict, username, authDatabase)
+		if err != nil {
+			return fmt.Errorf("failed to resolve user %v: %w", username, err)
+		}
+		for i, target := range targets {
+			clusterName := ""
+			if i == 0 {
+				clusterName = secretDict["cluster_name"]
+			}
+			if err = WaitForUserChangesApplied(ctx, mongoAdmin, target.projectId, clusterName); err != nil {
+				return fmt.Errorf("failed waiting for user %v - %v : %w", username, target.projectName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// testCredential checks the credential of the secret by logging in to the database.
+func testCredential(ctx context.Context, secretDict map[string]string) error {
+	conn, err := LoginWithSecret(ctx, secretDict)
+	if err != nil {
+		return err
+	}
+	_ = conn.Disconnect(ctx)
+	return nil
+}
@@ -0,0 +1,116 @@
+// reconcile_test.go
+package rotation
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+func TestReconcileWithoutDatabase(t *testing.T) {
+	const otherToken = "6f1e2d3c-4b5a-4978-8a9b-0c1d2e3f4a5b"
+	// An Atlas secret no credential can login with
+	unreachableAtlasFields := map[string]string{"host": "127.0.0.1", "port": "1"}
+	tests := []struct {
+		name string
+		// pending is the version staged AWSPENDING, "" for none
+		pending string
+		// age is how long ago the pending version was created
+		age time.Duration
+		// lock is the version holding a fresh rotation lock, "" for none
+		lock string
+		// failPending makes the read of the pending version fail
+		failPending bool
+		// rotationDisabled reports rotation as disabled for the secret
+		rotationDisabled bool
+		// missingUser leaves the database user out of the Atlas project
+		missingUser bool
+		// changeStatus is the user change status of the project cluster, "" for no cluster
+		changeStatus string
+		want         ReconcileAction
+		wantErr      string
+	}{
+		{name: "no pending version", want: ReconcileNothing},
+		{name: "pending stage on the current version", pending: testCurrentToken, want: ReconcileNothing},
+		{name: "rotation disabled", pending: testPendingToken, age: 2 * time.Hour, rotationDisabled: true, wantErr: "not enabled for rotation"},
+		{name: "pending version unreadable", pending: testPendingToken, age: 2 * time.Hour, failPending: true, wantErr: "Failed to get pending secret"},
+		{name: "rotation still running", pending: testPendingToken, age: time.Minute, want: ReconcileInProgress},
+		{name: "locked by the pending version", pending: testPendingToken, age: 2 * time.Hour, lock: testPendingToken, want: ReconcileInProgress},
+		{name: "locked by another version", pending: testPendingToken, age: 2 * time.Hour, lock: otherToken, want: ReconcileInProgress},
+		{name: "Atlas user missing", pending: testPendingToken, age: 2 * time.Hour, missingUser: true, wantErr: "not rolling back"},
+		{name: "Atlas changes not applied", pending: testPendingToken, age: 2 * time.Hour, changeStatus: "PENDING", wantErr: "not rolling back"},
+		{name: "no credential works", pending: testPendingToken, age: 2 * time.Hour, changeStatus: "APPLIED", wantErr: "manual repair needed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "RECONCILE_MIN_AGE_SECONDS", "ROTATION_LOCK_TTL_SECONDS", "CREATE_USER_IF_MISSING", "VERIFY_PROJECT_NAME",
+				"ALLOW_ROTATION_WHEN_UNKNOWN", "DEFAULT_AUTH_DATABASE")
+			setEnv(t, map[string]string{"CONNECT_TIMEOUT_SECONDS": "1", "RETRY_MAX_ATTEMPTS": "1", "ROTATION_LOCK": "true",
+				"USER_ACTIVE_TIMEOUT_SECONDS": "0"})
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", unreachableAtlasFields))
+			switch tt.pending {
+			case testCurrentToken:
+				_, err := smClient.UpdateSecretVersionStage(context.Background(), &secretsmanager.UpdateSecretVersionStageInput{
+					SecretId: aws.String(testSecretArn), VersionStage: aws.String("AWSPENDING"), MoveToVersionId: aws.String(testCurrentToken),
+				})
+				if err != nil {
+					t.Fatalf("UpdateSecretVersionStage() error = %v", err)
+				}
+			case testPendingToken:
+				smClient.PutVersion(testSecretArn, testPendingToken, atlasSecret(t, "new-password", unreachableAtlasFields), "AWSPENDING")
+				smClient.SetCreatedDate(testSecretArn, testPendingToken, time.Now().Add(-tt.age))
+			}
+			if tt.lock != "" {
+				tagRotationLock(t, smClient, tt.lock+"|"+time.Now().UTC().Format(time.RFC3339))
+			}
+			if tt.rotationDisabled {
+				smClient.SetRotationEnabled(testSecretArn, aws.Bool(false))
+			}
+			var users []admin.CloudDatabaseUser
+			if !tt.missingUser {
+				users = append(users, admin.CloudDatabaseUser{DatabaseName: "admin", Username: "app"})
+			}
+			atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, testutil.WithProjectUsers(testProjectId, "payments", users...))
+			if tt.changeStatus != "" {
+				atlas.AddCluster(testProjectId, admin.ClusterDescription20240805{Name: admin.PtrString("cluster0")})
+				atlas.SetClusterChangeStatus(testProjectId, "cluster0", tt.changeStatus)
+			}
+			secret := describeTestSecret(t, smClient)
+			if tt.failPending {
+				smClient.FailNext("GetSecretValue", &types.InternalServiceError{Message: aws.String("internal error")})
+			}
+
+			got, err := Reconcile(context.Background(), smClient, mongoAdmin, secret)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Reconcile() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Reconcile() = %q, want %q", got, tt.want)
+			}
+			// Nothing is repaired when the state can't be determined or the rotation may still run
+			if _, currentVersion, _ := smClient.Version(testSecretArn, "AWSCURRENT"); currentVersion != testCurrentToken {
+				t.Errorf("AWSCURRENT version = %q, want %q", currentVersion, testCurrentToken)
+			}
+			if _, pendingVersion, _ := smClient.Version(testSecretArn, "AWSPENDING"); pendingVersion != tt.pending {
+				t.Errorf("AWSPENDING version = %q, want %q", pendingVersion, tt.pending)
+			}
+			// The lock taken to reconcile is released, the lock of a running rotation is kept
+			if lock := smClient.Tags(testSecretArn)[rotationLockTag]; (lock == "") != (tt.lock == "") || !strings.HasPrefix(lock, tt.lock) {
+				t.Errorf("lock = %q, want it held by %q", lock, tt.lock)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// result.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+)
+
+// RotationResult
+//
+// Summary of a rotation step invocation, emitted as a single structured log line when RESULT_LOG is enabled
+type RotationResult struct {
+	Step       string `json:"step"`
+	SecretName string `json:"secret_name"`
+	VersionId  string `json:"version_id"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	// Fields are the secret fields allowed by LOGGABLE_FIELDS, see LoggableFields
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// rotationResultKey is the context key of the RotationResult of the invocation, see RecordResultFields
+type rotationResultKey struct{}
+
+// credentialFields are the secret fields never logged, even when listed in LOGGABLE_FIELDS
+var credentialFields = slices.Concat([]string{"password", "forced_password", "uri", "private_key", "client_secret",
+	passwordPreviousField, passwordHistoryField}, connectionStringKeys)
+
+// LoggableFields
+//
+// Get the secret fields that are safe to log
+//
+//	Only the fields listed in LOGGABLE_FIELDS environment variable (comma separated, e.g. project_name,username) are
+//	returned, every other field is treated as secret. The credential fields and the DERIVED_TOKEN_FIELD field are
+//	never returned, even when listed.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    map[string]string: The allowlisted fields present in the secret, nil when none
+func LoggableFields(secretDict map[string]string) map[string]string {
+	var fields map[string]string
+	for _, field := range settings.LoggableFields {
+		if field == settings.DerivedTokenField || slices.Contains(credentialFields, field) {
+			continue
+		}
+		if value, ok := secretDict[field]; ok {
+			if fields == nil {
+				fields = map[string]string{}
+			}
+			fields[field] = value
+		}
+	}
+	return fields
+}
+
+// RecordResultFields
+//
+// Add the loggable fields of the secret to the RotationResult of the invocation, see LoggableFields
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+func RecordResultFields(ctx context.Context, secretDict map[string]string) {
+	if result, ok := ctx.Value(rotationResultKey{}).(*RotationResult); ok {
+		result.Fields = LoggableFields(secretDict)
+	}
+}
+
+// LogRotationResult
+//
+// Log the rotation result as a single JSON line
+//
+//	Args:
+//	    result (RotationResult): The result of the step, DurationMs is computed from start
+//
+//	    start (time.Time): The time the invocation started
+//
+//	    err (error): The error returned by the step, if any
+func LogRotationResult(result RotationResult, start time.Time, err error) {
+	result.DurationMs = time.Since(start).Milliseconds()
+	result.Success = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	jsonResult, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		log.Printf("failed to marshal rotation result: %v", marshalErr)
+		return
+	}
+	log.Printf("RotationResult: %s", jsonResult)
+}
+
+// LogStepDuration
+//
+// Log the duration of the rotation step, as a warning when it exceeds SLOW_STEP_THRESHOLD_SECONDS
+//
+//	Args:
+//	    step (string): The rotation step
+//
+//	    arn (string): The secret ARN or other identifier
+//
+//	    start (time.Time): The time the step started
+func LogStepDuration(step string, arn string, start time.Time) {
+	duration := time.Since(start)
+	threshold := settings.SlowStepThreshold
+	if threshold > 0 && duration > threshold {
+		log.Printf("WARNING: Slow step %v for %v took %v, over the %v threshold", step, arn, duration.Round(time.Millisecond), threshold)
+		return
+	}
+	log.Printf("Step %v for %v took %v", step, arn, duration.Round(time.Millisecond))
+}
@@ -0,0 +1,177 @@
+// retry.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/auth"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/topology"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelayMs = 200
+	maxRetryDelay           = 5 * time.Second
+)
+
+// RetryWithBackoff
+//
+// Run the operation retrying retryable errors with exponential backoff and jitter
+//
+//	Supported environment variables:
+//	    - RETRY_MAX_ATTEMPTS: total attempts including the first one, default 3
+//	    - RETRY_BASE_DELAY_MS: delay before the first retry in milliseconds, doubled on every retry, default 200
+//
+//	The retries are also taken from the retry budget of the invocation, see RetryBudget.
+//
+//	Args:
+//	    operation (string): The operation name used for logging
+//
+//	    isRetryable (func(error) bool): Classifies the errors that must be retried
+//
+//	    fn (func() error): The operation to run
+//
+//	Returns:
+//	    error: The last error returned by the operation, or nil on success
+func RetryWithBackoff(ctx context.Context, operation string, isRetryable func(error) bool, fn func() error) error {
+	maxAttempts := settings.RetryMaxAttempts
+	delay := settings.RetryBaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= maxAttempts || !isRetryable(err) {
+			return err
+		}
+		sleep := min(delay, maxRetryDelay)
+		sleep = sleep/2 + rand.N(sleep/2+1)
+		if budgetErr := spendRetry(ctx, sleep); budgetErr != nil {
+			return fmt.Errorf("%v: %w (last error: %w)", operation, budgetErr, err)
+		}
+		log.Printf("%v: attempt %d/%d failed with retryable error, retrying in %v: %v", operation, attempt, maxAttempts, sleep, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %w (last error: %w)", operation, ctx.Err(), err)
+		case <-time.After(sleep):
+		}
+		delay *= 2
+	}
+}
+
+// AWSRetryOptions
+//
+// Get the AWS SDK retry options of AWS_RETRY_MODE and AWS_MAX_ATTEMPTS environment variables
+//
+//	The SDK reads both variables itself, they are applied explicitly so the values are validated by LoadConfig with
+//	a clear error and logged at startup. AWS_RETRY_MODE is standard (SDK default) or adaptive, the latter also rate limits
+//	the client on throttling, which helps rotations of many secrets at once. AWS_MAX_ATTEMPTS is the total attempts
+//	of every AWS API call, default 3. The SDK retries happen inside each call, RetryWithBackoff
+//	(RETRY_MAX_ATTEMPTS) retries some calls again on top of them, so the attempts of such a call can reach the
+//	product of both.
+//
+//	Returns:
+//	    []func(*config.LoadOptions) error: The options for config.LoadDefaultConfig, empty when neither is set
+func AWSRetryOptions() []func(*config.LoadOptions) error {
+	var options []func(*config.LoadOptions) error
+	if mode := settings.AWSRetryMode; mode != "" {
+		options = append(options, config.WithRetryMode(mode))
+		log.Printf("AWS SDK retry mode set to %v", mode)
+	}
+	if maxAttempts := settings.AWSMaxAttempts; maxAttempts > 0 {
+		options = append(options, config.WithRetryMaxAttempts(maxAttempts))
+		log.Printf("AWS SDK max attempts set to %d", maxAttempts)
+	}
+	return options
+}
+
+// IsAWSRetryable
+//
+// Classify the error with the AWS SDK default retryable and throttle error rules
+//
+//	Args:
+//	    err (error): The error returned by an AWS SDK call
+//
+//	Returns:
+//	    bool: True if the SDK considers the error retryable or a throttle
+func IsAWSRetryable(err error) bool {
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary {
+		return true
+	}
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// DescribeSecretWithRetry
+//
+// Describe the secret retrying throttling and transient errors
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    arn (string): The secret ARN or other identifier
+//
+//	Returns:
+//	    *secretsmanager.DescribeSecretOutput: The secret metadata
+//	    error: Error if the secret could not be described
+func DescribeSecretWithRetry(ctx context.Context, smClient SecretsManagerAPI, arn string) (*secretsmanager.DescribeSecretOutput, error) {
+	var metadata *secretsmanager.DescribeSecretOutput
+	err := RetryWithBackoff(ctx, "DescribeSecret", IsAWSRetryable, func() error {
+		var err error
+		metadata, err = smClient.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+			SecretId: &arn,
+		})
+		return err
+	})
+	return metadata, err
+}
+
+// IsResourceNotFound
+//
+// Classify Secrets Manager ResourceNotFoundException errors
+//
+//	Args:
+//	    err (error): The error returned by a Secrets Manager call
+//
+//	Returns:
+//	    bool: True if the secret or version was not found
+func IsResourceNotFound(err error) bool {
+	var notFound *types.ResourceNotFoundException
+	return errors.As(err, &notFound)
+}
+
+// IsMongoRetryable
+//
+// Classify MongoDB driver errors worth retrying
+//
+//	Args:
+//	    err (error): The error returned by a MongoDB operation
+//
+//	Returns:
+//	    bool: True for timeouts, network errors and server selection failures, false for authentication errors
+func IsMongoRetryable(err error) bool {
+	var selectionErr topology.ServerSelectionError
+	return mongo.IsTimeout(err) || mongo.IsNetworkError(err) || errors.As(err, &selectionErr)
+}
+
+// IsMongoAuthError
+//
+// Classify MongoDB driver authentication failures
+//
+//	Args:
+//	    err (error): The error returned by a MongoDB operation
+//
+//	Returns:
+//	    bool: True if the server rejected the credentials
+func IsMongoAuthError(err error) bool {
+	var authErr *auth.Error
+	return errors.As(err, &authErr)
+}
@@ -0,0 +1,200 @@
+// retry_test.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/auth"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/topology"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+var (
+	errRetryable = errors.New("retryable")
+	errPermanent = errors.New("permanent")
+)
+
+func TestRetryWithBackoff(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts string
+		failures    []error
+		wantCalls   int
+		wantErr     error
+	}{
+		{name: "success first attempt", wantCalls: 1},
+		{name: "retryable then success", failures: []error{errRetryable, errRetryable}, wantCalls: 3},
+		{name: "attempts exhausted", failures: []error{errRetryable, errRetryable, errRetryable, errRetryable}, wantCalls: 3, wantErr: errRetryable},
+		{name: "not retryable", failures: []error{errPermanent}, wantCalls: 1, wantErr: errPermanent},
+		{name: "single attempt", maxAttempts: "1", failures: []error{errRetryable}, wantCalls: 1, wantErr: errRetryable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"RETRY_MAX_ATTEMPTS": tt.maxAttempts, "RETRY_BASE_DELAY_MS": "1"})
+			calls := 0
+			err := RetryWithBackoff(context.Background(), "test", func(err error) bool { return errors.Is(err, errRetryable) }, func() error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("RetryWithBackoff() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("RetryWithBackoff() calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryWithBackoffCanceled(t *testing.T) {
+	setEnv(t, map[string]string{"RETRY_MAX_ATTEMPTS": "5", "RETRY_BASE_DELAY_MS": "60000"})
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := RetryWithBackoff(ctx, "test", func(error) bool { return true }, func() error {
+		calls++
+		cancel()
+		return errRetryable
+	})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, errRetryable) {
+		t.Errorf("RetryWithBackoff() error = %v, want the cancellation and the last error", err)
+	}
+	if calls != 1 {
+		t.Errorf("RetryWithBackoff() calls = %d, want 1", calls)
+	}
+}
+
+func TestAWSRetryOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// wantMode and wantMaxAttempts are the values of the loaded config, empty for the SDK defaults
+		wantMode        aws.RetryMode
+		wantMaxAttempts int
+		wantErr         bool
+	}{
+		{name: "sdk defaults"},
+		{name: "adaptive", env: map[string]string{"AWS_RETRY_MODE": "adaptive"}, wantMode: aws.RetryModeAdaptive},
+		{name: "max attempts", env: map[string]string{"AWS_MAX_ATTEMPTS": "8"}, wantMaxAttempts: 8},
+		{name: "both", env: map[string]string{"AWS_RETRY_MODE": "adaptive", "AWS_MAX_ATTEMPTS": "5"}, wantMode: aws.RetryModeAdaptive, wantMaxAttempts: 5},
+		{name: "unknown mode", env: map[string]string{"AWS_RETRY_MODE": "legacy"}, wantErr: true},
+		{name: "max attempts not a number", env: map[string]string{"AWS_MAX_ATTEMPTS": "many"}, wantErr: true},
+		{name: "max attempts zero", env: map[string]string{"AWS_MAX_ATTEMPTS": "0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "AWS_RETRY_MODE", "AWS_MAX_ATTEMPTS", "AWS_PROFILE")
+			setEnv(t, map[string]string{"AWS_REGION": "us-east-1", "AWS_CONFIG_FILE": filepath.Join(t.TempDir(), "config"),
+				"AWS_SHARED_CREDENTIALS_FILE": filepath.Join(t.TempDir(), "credentials")})
+			setEnv(t, tt.env)
+			// An invalid value is a configuration error, see LoadConfig
+			if err := CheckConfig(); (err != nil) != tt.wantErr {
+				t.Fatalf("CheckConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			options := AWSRetryOptions()
+			if len(options) != len(tt.env) {
+				t.Errorf("AWSRetryOptions() = %d options, want one per variable set", len(options))
+			}
+			// The options alone give the loaded config its retry settings, without the SDK reading the variables
+			unsetEnv(t, "AWS_RETRY_MODE", "AWS_MAX_ATTEMPTS")
+			awsConfig, err := config.LoadDefaultConfig(context.Background(), options...)
+			if err != nil {
+				t.Fatalf("LoadDefaultConfig() error = %v", err)
+			}
+			if awsConfig.RetryMode != tt.wantMode || awsConfig.RetryMaxAttempts != tt.wantMaxAttempts {
+				t.Errorf("config retry mode = %q, max attempts = %d, want %q, %d", awsConfig.RetryMode, awsConfig.RetryMaxAttempts, tt.wantMode, tt.wantMaxAttempts)
+			}
+		})
+	}
+}
+
+func TestIsAWSRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"throttling", &smithy.GenericAPIError{Code: "ThrottlingException"}, true},
+		{"too many requests", &smithy.GenericAPIError{Code: "TooManyRequestsException"}, true},
+		{"not found", &types.ResourceNotFoundException{Message: aws.String("not found")}, false},
+		{"invalid request", &types.InvalidRequestException{Message: aws.String("invalid")}, false},
+		{"plain error", errPermanent, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsAWSRetryable(tt.err); got != tt.want {
+				t.Errorf("IsAWSRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMongoRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"server selection", topology.ServerSelectionError{Wrapped: errors.New("connection refused")}, true},
+		{"wrapped server selection", fmt.Errorf("ping: %w", topology.ServerSelectionError{}), true},
+		{"authentication", &auth.Error{}, false},
+		{"plain error", errPermanent, false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsMongoRetryable(tt.err); got != tt.want {
+				t.Errorf("IsMongoRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribeSecretWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []error
+		wantErr  bool
+	}{
+		{name: "no failure"},
+		{name: "throttled once", failures: []error{&smithy.GenericAPIError{Code: "ThrottlingException"}}},
+		{name: "throttled on every attempt", failures: []error{
+			&smithy.GenericAPIError{Code: "ThrottlingException"},
+			&smithy.GenericAPIError{Code: "ThrottlingException"},
+			&smithy.GenericAPIError{Code: "ThrottlingException"},
+		}, wantErr: true},
+		{name: "not found", failures: []error{&types.ResourceNotFoundException{Message: aws.String("not found")}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"RETRY_MAX_ATTEMPTS": "3", "RETRY_BASE_DELAY_MS": "1"})
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+			for _, failure := range tt.failures {
+				smClient.FailNext("DescribeSecret", failure)
+			}
+			metadata, err := DescribeSecretWithRetry(context.Background(), smClient, testSecretArn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DescribeSecretWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && metadata.VersionIdsToStages[testCurrentToken] == nil {
+				t.Errorf("DescribeSecretWithRetry() versions = %v, want %v", metadata.VersionIdsToStages, testCurrentToken)
+			}
+		})
+	}
+}
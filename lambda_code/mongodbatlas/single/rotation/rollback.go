@@ -0,0 +1,88 @@
+// rollback.go
+package rotation
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// RollbackPassword
+//
+// Restore the AWSCURRENT password in the database after finishSecret failed to promote the pending version
+//
+//	SetSecret already changed the database password to the AWSPENDING one, if AWSCURRENT can't be moved to it the
+//	applications keep reading a password the database no longer accepts. This compensating action sets the
+//	password of the still current version back, in every authentication database of the secret (see
+//	GetAuthDatabases) as setSecret changed all of them. Enabled with ENABLE_ROLLBACK environment variable.
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    arn (string): The secret ARN or other identifier
+//
+//	    token (string): The ClientRequestToken of the pending version
+//
+//	Returns:
+//	    error: Error if the password could not be restored
+func RollbackPassword(ctx context.Context, smClient SecretsManagerAPI, mongoAdmin *admin.APIClient, arn string, token string) error {
+	currentDict, err := GetSecretDict(ctx, smClient, RotationConfig{
+		arn:   &arn,
+		stage: "AWSCURRENT",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get current secret: %w", err)
+	}
+	pendingDict, err := GetSecretDict(ctx, smClient, RotationConfig{
+		arn:   &arn,
+		stage: "AWSPENDING",
+		token: &token,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get pending secret: %w", err)
+	}
+	if IsCreateThenSwap(pendingDict) {
+		log.Printf("RollbackPassword: Current user %v was not changed by the rotation of %v, nothing to rollback", currentDict["username"], arn)
+		return nil
+	}
+	authDatabases, err := GetAuthDatabases(currentDict)
+	if err != nil {
+		return err
+	}
+	if GetEngine(pendingDict) == "documentdb" {
+		conn, err := LoginWithSecret(ctx, pendingDict)
+		if err != nil {
+			return fmt.Errorf("failed to login with pending secret: %w", err)
+		}
+		defer func() { _ = conn.Disconnect(ctx) }()
+		for _, authDatabase := range authDatabases {
+			if err = UpdateUserPassword(ctx, conn, authDatabase, currentDict["username"], currentDict["password"]); err != nil {
+				return fmt.Errorf("failed to restore password for user %v (auth database %v): %w", currentDict["username"], authDatabase, err)
+			}
+		}
+	} else {
+		if err = ResolveSecretProjectId(ctx, mongoAdmin, currentDict); err != nil {
+			return err
+		}
+		password := currentDict["password"]
+		for _, authDatabase := range authDatabases {
+			targets, err := GetAtlasUserTargets(ctx, mongoAdmin, currentDict, currentDict["username"], authDatabase)
+			if err != nil {
+				return err
+			}
+			for _, target := range targets {
+				target.user.Password = &password
+				_, _, err = mongoAdmin.DatabaseUsersApi.UpdateDatabaseUser(ctx, target.projectId, authDatabase, currentDict["username"], target.user).Execute()
+				if err != nil {
+					return fmt.Errorf("failed to restore password for user %v (auth database %v) - %v : %w", currentDict["username"], authDatabase, target.projectName, AtlasError(err))
+				}
+			}
+		}
+	}
+	log.Printf("RollbackPassword: Restored AWSCURRENT password for user %v of %v", currentDict["username"], arn)
+	return nil
+}
@@ -0,0 +1,43 @@
+// rollback_test.go
+package rotation
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+func TestRollbackPasswordRestoresEveryAuthDatabase(t *testing.T) {
+	tests := []struct {
+		name          string
+		fields        map[string]string
+		authDatabases []string
+	}{
+		{"default auth database", nil, []string{"admin"}},
+		{"auth_database", map[string]string{"auth_database": "app"}, []string{"app"}},
+		{"auth_databases", map[string]string{"auth_databases": `["admin", "app", "reporting"]`}, []string{"admin", "app", "reporting"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "DEFAULT_AUTH_DATABASE", "VERIFY_PROJECT_NAME", "CREATE_USER_IF_MISSING")
+			smClient, atlas, mongoAdmin := newRotationFakes(t, tt.fields, tt.fields)
+			for _, authDatabase := range tt.authDatabases {
+				atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: authDatabase, Username: "app", Password: admin.PtrString("new-password")})
+			}
+
+			if err := RollbackPassword(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+				t.Fatalf("RollbackPassword() error = %v", err)
+			}
+			for _, authDatabase := range tt.authDatabases {
+				user, ok := atlas.User(testProjectId, authDatabase, "app")
+				if !ok {
+					t.Fatalf("user app (auth database %v) was removed", authDatabase)
+				}
+				if got := user.GetPassword(); got != "old-password" {
+					t.Errorf("user app (auth database %v) password = %q, want the AWSCURRENT password", authDatabase, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+// scheme.go
+package rotation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// InPlaceScheme
+//
+// Secret 'rotation_scheme' value selecting the default scheme: the password of the secret user is changed in place.
+// An empty 'rotation_scheme' selects it too.
+const InPlaceScheme = "in_place"
+
+// RotatingUsernameScheme
+//
+// Secret 'rotation_scheme' value selecting the rotating-username scheme: every rotation creates a brand-new user
+// with a generated name and the roles of the previous user, and the previous user is deleted once the new version
+// is promoted to AWSCURRENT.
+const RotatingUsernameScheme = "rotating_username"
+
+// AlternatingUsersScheme
+//
+// Secret 'rotation_scheme' value selecting the alternating-users (create-then-swap) scheme: the rotations alternate
+// between the 'username_base' user and its '_clone' user. The alternate user is created from the current one when
+// missing, or gets the new password, and the secret swaps to it; the previous user is kept untouched, so clients
+// still holding its password keep working until the next rotation.
+const AlternatingUsersScheme = "alternating_users"
+
+// IsRotatingUsername
+//
+// Check if the secret uses the rotating-username scheme
+func IsRotatingUsername(secretDict map[string]string) bool {
+	return secretDict["rotation_scheme"] == RotatingUsernameScheme
+}
+
+// IsCreateThenSwap
+//
+// Check if the secret rotation switches to another user instead of changing the password in place
+func IsCreateThenSwap(secretDict map[string]string) bool {
+	scheme := secretDict["rotation_scheme"]
+	return scheme == RotatingUsernameScheme || scheme == AlternatingUsersScheme
+}
+
+// ValidateRotationScheme
+//
+// Check the 'rotation_scheme' of the secret is known and supported by its engine
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    error: Error if the scheme is unknown, or switches users with another engine than mongodbatlas
+func ValidateRotationScheme(secretDict map[string]string) error {
+	switch scheme := secretDict["rotation_scheme"]; scheme {
+	case "", InPlaceScheme:
+		return nil
+	case RotatingUsernameScheme, AlternatingUsersScheme:
+		if GetEngine(secretDict) != "mongodbatlas" {
+			return fmt.Errorf("%v rotation scheme is only supported for mongodbatlas engine", scheme)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown rotation_scheme %q, must be %v, %v or %v", scheme, InPlaceScheme, RotatingUsernameScheme, AlternatingUsersScheme)
+	}
+}
+
+// GenerateRotatingUsername
+//
+// Generate the username for the next rotation
+//
+//	The generated username is the 'username_base' field (initialized from the current username on the first
+//	rotation) followed by a random suffix. The current username is kept in 'previous_username' so it can be
+//	deleted when the rotation finishes.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary, updated in place
+//
+//	Returns:
+//	    error: Error if the random suffix could not be generated
+func GenerateRotatingUsername(secretDict map[string]string) error {
+	base, ok := secretDict["username_base"]
+	if !ok || base == "" {
+		base = secretDict["username"]
+		secretDict["username_base"] = base
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("failed to generate username suffix: %w", err)
+	}
+	secretDict["previous_username"] = secretDict["username"]
+	secretDict["username"] = fmt.Sprintf("%s_%s", base, hex.EncodeToString(suffix))
+	return nil
+}
+
+// GenerateAlternatingUsername
+//
+// Swap the username of an alternating-users secret for the next rotation
+//
+//	The users are the 'username_base' field (initialized from the current username on the first rotation) and the
+//	same name with a '_clone' suffix. The current username is kept in 'previous_username', the user the new one is
+//	created from.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary, updated in place
+func GenerateAlternatingUsername(secretDict map[string]string) {
+	base, ok := secretDict["username_base"]
+	if !ok || base == "" {
+		base = secretDict["username"]
+		secretDict["username_base"] = base
+	}
+	secretDict["previous_username"] = secretDict["username"]
+	if secretDict["username"] == base {
+		secretDict["username"] = base + "_clone"
+	} else {
+		secretDict["username"] = base
+	}
+}
+
+// CreateRotatedAtlasUser
+//
+// Create the new user of a rotating-username or alternating-users secret in Atlas
+//
+//	The user record of 'previous_username' is used as template, so the new user gets the same roles, scopes and
+//	labels. If the new user already exists (a retried setSecret, or the alternate user of an alternating-users
+//	secret) its password is updated instead.
+//
+//	Args:
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    target (AtlasUserTarget): The resolved template user and its project
+//
+//	    username (string): The new username
+//
+//	    password (string): The new password
+//
+//	Returns:
+//	    bool: True if the user was created, false if an existing user got the new password
+//	    error: Error if the user could not be created
+func CreateRotatedAtlasUser(ctx context.Context, mongoAdmin *admin.APIClient, target AtlasUserTarget, username string, password string) (bool, error) {
+	existing, resp, err := mongoAdmin.DatabaseUsersApi.GetDatabaseUser(ctx, target.projectId, target.user.DatabaseName, username).Execute()
+	if err == nil {
+		existing.Password = &password
+		_, _, err = mongoAdmin.DatabaseUsersApi.UpdateDatabaseUser(ctx, target.projectId, target.user.DatabaseName, username, existing).Execute()
+		if err != nil {
+			return false, fmt.Errorf("failed to update existing user %v: %w", username, AtlasError(err))
+		}
+		return false, nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		return false, fmt.Errorf("failed to get user %v: %w", username, AtlasError(err))
+	}
+	newUser := admin.NewCloudDatabaseUser(target.user.DatabaseName, target.projectId, username)
+	newUser.Password = &password
+	newUser.Roles = target.user.Roles
+	newUser.Scopes = target.user.Scopes
+	newUser.Labels = target.user.Labels
+	newUser.Description = target.user.Description
+	if _, _, err = mongoAdmin.DatabaseUsersApi.CreateDatabaseUser(ctx, target.projectId, newUser).Execute(); err != nil {
+		return false, fmt.Errorf("failed to create user %v: %w", username, AtlasError(err))
+	}
+	log.Printf("SetSecret: Created user %v from template %v in project %v", username, target.user.Username, target.projectId)
+	return true, nil
+}
+
+// DeletePreviousAtlasUser
+//
+// Delete the previous user of a rotating-username secret in every project and auth database
+//
+//	Users already gone are ignored, so the cleanup can be retried.
+//
+//	Args:
+//	    mongoAdmin (admin.APIClient): MongoDB Atlas API client
+//
+//	    secretDict (map[string]string): The promoted secret dictionary
+//
+//	Returns:
+//	    error: Error if a user could not be deleted
+func DeletePreviousAtlasUser(ctx context.Context, mongoAdmin *admin.APIClient, secretDict map[string]string) error {
+	previousUsername := secretDict["previous_username"]
+	if previousUsername == "" || previousUsername == secretDict["username"] {
+		return nil
+	}
+	if err := ResolveSecretProjectId(ctx, mongoAdmin, secretDict); err != nil {
+		return err
+	}
+	projects, err := GetProjects(secretDict)
+	if err != nil {
+		return err
+	}
+	projects = append([]map[string]string{secretDict}, projects...)
+	authDatabases, err := GetAuthDatabases(secretDict)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		for _, authDatabase := range authDatabases {
+			_, resp, err := mongoAdmin.DatabaseUsersApi.DeleteDatabaseUser(ctx, project["project_id"], authDatabase, previousUsername).Execute()
+			if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+				return fmt.Errorf("failed to delete user %v in %v of project %v: %w", previousUsername, authDatabase, project["project_id"], AtlasError(err))
+			}
+			log.Printf("FinishSecret: Deleted previous user %v in %v of project %v", previousUsername, authDatabase, project["project_id"])
+		}
+	}
+	return nil
+}
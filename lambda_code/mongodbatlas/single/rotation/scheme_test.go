@@ -0,0 +1,323 @@
+// scheme_test.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+func TestGenerateRotatingUsername(t *testing.T) {
+	tests := []struct {
+		name       string
+		secretDict map[string]string
+		wantBase   string
+	}{
+		{"first rotation", map[string]string{"username": "app"}, "app"},
+		{"later rotation", map[string]string{"username": "app_0a1b2c3d", "username_base": "app"}, "app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			currentUsername := tt.secretDict["username"]
+			if err := GenerateRotatingUsername(tt.secretDict); err != nil {
+				t.Fatalf("GenerateRotatingUsername() error = %v", err)
+			}
+			if got := tt.secretDict["username_base"]; got != tt.wantBase {
+				t.Errorf("username_base = %q, want %q", got, tt.wantBase)
+			}
+			if got := tt.secretDict["previous_username"]; got != currentUsername {
+				t.Errorf("previous_username = %q, want %q", got, currentUsername)
+			}
+			if got := tt.secretDict["username"]; !regexp.MustCompile("^"+tt.wantBase+"_[0-9a-f]{8}$").MatchString(got) || got == currentUsername {
+				t.Errorf("username = %q, want %v followed by a new random suffix", got, tt.wantBase)
+			}
+		})
+	}
+}
+
+func TestRotatingUsernameRotation(t *testing.T) {
+	unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING",
+		"DEFAULT_AUTH_DATABASE", "PRECHECK_CURRENT", "CLEANUP_STALE_PENDING", "KEEP_PREVIOUS_PASSWORD", "VERIFY_OLD_REVOKED",
+		"FINISH_DELAY_SECONDS", "PASSWORD_PREFIX", "PASSWORD_SUFFIX", "PASSWORD_LENGTH")
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", map[string]string{"rotation_scheme": RotatingUsernameScheme}))
+	roles := []admin.DatabaseUserRole{{RoleName: "readWrite", DatabaseName: "app"}}
+	atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, testutil.WithProjectUsers(testProjectId, "payments",
+		admin.CloudDatabaseUser{DatabaseName: "admin", Username: "app", Password: admin.PtrString("old-password"), Roles: &roles}))
+	ctx := context.Background()
+
+	if err := CreateSecret(ctx, smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	pendingDict := stagedDict(t, smClient, "AWSPENDING")
+	username := pendingDict["username"]
+	if username == "app" || pendingDict["previous_username"] != "app" {
+		t.Fatalf("pending secret username = %q, previous_username = %q, want a new user replacing app", username, pendingDict["previous_username"])
+	}
+
+	if err := SetSecret(ctx, smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+	newUser, ok := atlas.User(testProjectId, "admin", username)
+	if !ok {
+		t.Fatalf("SetSecret() did not create user %v", username)
+	}
+	if newUser.GetPassword() != pendingDict["password"] {
+		t.Errorf("new user password = %q, want the AWSPENDING password", newUser.GetPassword())
+	}
+	if got := newUser.GetRoles(); len(got) != 1 || got[0] != roles[0] {
+		t.Errorf("new user roles = %v, want the template roles %v", got, roles)
+	}
+	if _, ok = atlas.User(testProjectId, "admin", "app"); !ok {
+		t.Errorf("SetSecret() deleted the previous user app before the rotation finished")
+	}
+
+	if err := FinishSecret(ctx, smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+		t.Fatalf("FinishSecret() error = %v", err)
+	}
+	if _, ok = atlas.User(testProjectId, "admin", "app"); ok {
+		t.Errorf("FinishSecret() did not delete the previous user app")
+	}
+	if _, ok = atlas.User(testProjectId, "admin", username); !ok {
+		t.Errorf("FinishSecret() deleted the new user %v", username)
+	}
+}
+
+func TestDeletePreviousAtlasUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		secretDict map[string]string
+		failure    int
+		// wantGone are the auth databases the previous user app is deleted from
+		wantGone []string
+		wantErr  bool
+	}{
+		{name: "deleted", secretDict: map[string]string{"previous_username": "app"}, wantGone: []string{"admin"}},
+		{name: "every auth database", secretDict: map[string]string{"previous_username": "app", "auth_databases": `["admin", "reporting"]`},
+			wantGone: []string{"admin", "reporting"}},
+		{name: "already gone", secretDict: map[string]string{"previous_username": "gone"}},
+		{name: "no previous user", secretDict: map[string]string{}},
+		{name: "previous user is the current one", secretDict: map[string]string{"previous_username": "app_0a1b2c3d"}},
+		{name: "delete fails", secretDict: map[string]string{"previous_username": "app"}, failure: http.StatusInternalServerError, wantErr: true},
+		{name: "invalid auth_databases", secretDict: map[string]string{"previous_username": "app", "auth_databases": "reporting"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "DEFAULT_AUTH_DATABASE")
+			var users []admin.CloudDatabaseUser
+			for _, authDatabase := range []string{"admin", "reporting"} {
+				for _, username := range []string{"app", "app_0a1b2c3d"} {
+					users = append(users, admin.CloudDatabaseUser{DatabaseName: authDatabase, Username: username})
+				}
+			}
+			atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, testutil.WithProjectUsers(testProjectId, "payments", users...))
+			if tt.failure != 0 {
+				atlas.FailNext("DeleteDatabaseUser", tt.failure)
+			}
+			secretDict := map[string]string{"username": "app_0a1b2c3d", "project_id": testProjectId}
+			for key, value := range tt.secretDict {
+				secretDict[key] = value
+			}
+
+			err := DeletePreviousAtlasUser(context.Background(), mongoAdmin, secretDict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DeletePreviousAtlasUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, authDatabase := range []string{"admin", "reporting"} {
+				if _, ok := atlas.User(testProjectId, authDatabase, "app"); ok == slices.Contains(tt.wantGone, authDatabase) {
+					t.Errorf("user app exists in %v = %v, want %v", authDatabase, ok, !ok)
+				}
+				if _, ok := atlas.User(testProjectId, authDatabase, "app_0a1b2c3d"); !ok {
+					t.Errorf("DeletePreviousAtlasUser() deleted the current user in %v", authDatabase)
+				}
+			}
+		})
+	}
+}
+
+func TestSetSecretCreateThenSwapRollback(t *testing.T) {
+	const (
+		secondProjectId = "65a1b2c3d4e5f60718293a5b"
+		thirdProjectId  = "65a1b2c3d4e5f60718293a6b"
+	)
+	projects := `[{"project_id": "` + secondProjectId + `", "project_name": "payments-dr"}, {"project_id": "` + thirdProjectId + `", "project_name": "payments-eu"}]`
+	tests := []struct {
+		name string
+		// successes is the number of user creations that succeed before the failing one
+		successes int
+		// existing is the project already holding the alternate user
+		existing string
+		// wantClone are the projects left with the alternate user after the failure
+		wantClone []string
+	}{
+		{name: "first project fails", successes: 0},
+		{name: "created users are deleted", successes: 2},
+		{name: "existing alternate user is kept", successes: 1, existing: testProjectId, wantClone: []string{testProjectId}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING", "DEFAULT_AUTH_DATABASE")
+			smClient, atlas, mongoAdmin := newRotationFakes(t, map[string]string{"projects": projects}, map[string]string{"projects": projects,
+				"rotation_scheme": AlternatingUsersScheme, "username": "app_clone", "username_base": "app", "previous_username": "app"})
+			for _, projectId := range []string{secondProjectId, thirdProjectId} {
+				atlas.AddProject(projectId, "")
+				atlas.AddUser(admin.CloudDatabaseUser{GroupId: projectId, DatabaseName: "admin", Username: "app"})
+			}
+			if tt.existing != "" {
+				atlas.AddUser(admin.CloudDatabaseUser{GroupId: tt.existing, DatabaseName: "admin", Username: "app_clone"})
+			}
+			for range tt.successes {
+				atlas.SucceedNext("CreateDatabaseUser")
+			}
+			atlas.FailNext("CreateDatabaseUser", http.StatusBadRequest)
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if !errors.Is(err, ErrSetSecret) {
+				t.Fatalf("SetSecret() error = %v, want ErrSetSecret", err)
+			}
+			for _, projectId := range []string{testProjectId, secondProjectId, thirdProjectId} {
+				if _, ok := atlas.User(projectId, "admin", "app_clone"); ok != slices.Contains(tt.wantClone, projectId) {
+					t.Errorf("user app_clone exists in project %v = %v, want %v", projectId, ok, !ok)
+				}
+				if _, ok := atlas.User(projectId, "admin", "app"); !ok {
+					t.Errorf("user app of project %v was removed", projectId)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRotationScheme(t *testing.T) {
+	tests := []struct {
+		scheme  string
+		engine  string
+		wantErr bool
+	}{
+		{scheme: "", engine: "documentdb"},
+		{scheme: InPlaceScheme, engine: "documentdb"},
+		{scheme: InPlaceScheme, engine: "mongodbatlas"},
+		{scheme: AlternatingUsersScheme, engine: "mongodbatlas"},
+		{scheme: RotatingUsernameScheme, engine: "MongoDBAtlas"},
+		{scheme: AlternatingUsersScheme, engine: "documentdb", wantErr: true},
+		{scheme: RotatingUsernameScheme, engine: "documentdb", wantErr: true},
+		{scheme: "blue_green", engine: "mongodbatlas", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme+"/"+tt.engine, func(t *testing.T) {
+			unsetEnv(t, "DEFAULT_ENGINE")
+			err := ValidateRotationScheme(map[string]string{"rotation_scheme": tt.scheme, "engine": tt.engine})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRotationScheme() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsCreateThenSwap(t *testing.T) {
+	tests := []struct {
+		scheme string
+		want   bool
+	}{
+		{scheme: "", want: false},
+		{scheme: InPlaceScheme, want: false},
+		{scheme: RotatingUsernameScheme, want: true},
+		{scheme: AlternatingUsersScheme, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			if got := IsCreateThenSwap(map[string]string{"rotation_scheme": tt.scheme}); got != tt.want {
+				t.Errorf("IsCreateThenSwap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateAlternatingUsername(t *testing.T) {
+	tests := []struct {
+		name         string
+		secretDict   map[string]string
+		wantUsername string
+	}{
+		{name: "first rotation", secretDict: map[string]string{"username": "app"}, wantUsername: "app_clone"},
+		{name: "back to the base user", secretDict: map[string]string{"username": "app_clone", "username_base": "app"}, wantUsername: "app"},
+		{name: "to the clone user", secretDict: map[string]string{"username": "app", "username_base": "app"}, wantUsername: "app_clone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			currentUsername := tt.secretDict["username"]
+			GenerateAlternatingUsername(tt.secretDict)
+			if tt.secretDict["username"] != tt.wantUsername || tt.secretDict["previous_username"] != currentUsername || tt.secretDict["username_base"] != "app" {
+				t.Errorf("GenerateAlternatingUsername() = %v, want username %v replacing %v", tt.secretDict, tt.wantUsername, currentUsername)
+			}
+		})
+	}
+}
+
+func TestRotationStrategies(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		// users are the Atlas users before the rotation, all with the old password
+		users        []string
+		wantUsername string
+		// wantNewPassword are the users with the new password after the rotation, the other users keep the old one
+		wantNewPassword []string
+	}{
+		{name: "in place by default", users: []string{"app"}, wantUsername: "app", wantNewPassword: []string{"app"}},
+		{name: "in place", fields: map[string]string{"rotation_scheme": InPlaceScheme}, users: []string{"app"}, wantUsername: "app",
+			wantNewPassword: []string{"app"}},
+		{name: "create then swap creates the clone", fields: map[string]string{"rotation_scheme": AlternatingUsersScheme}, users: []string{"app"},
+			wantUsername: "app_clone", wantNewPassword: []string{"app_clone"}},
+		{name: "create then swap back to the base user",
+			fields: map[string]string{"rotation_scheme": AlternatingUsersScheme, "username": "app_clone", "username_base": "app"},
+			users:  []string{"app", "app_clone"}, wantUsername: "app", wantNewPassword: []string{"app"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING",
+				"DEFAULT_AUTH_DATABASE", "PRECHECK_CURRENT", "CLEANUP_STALE_PENDING", "KEEP_PREVIOUS_PASSWORD", "VERIFY_OLD_REVOKED",
+				"FINISH_DELAY_SECONDS", "PASSWORD_PREFIX", "PASSWORD_SUFFIX", "PASSWORD_LENGTH")
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", tt.fields))
+			roles := []admin.DatabaseUserRole{{RoleName: "readWrite", DatabaseName: "app"}}
+			var users []admin.CloudDatabaseUser
+			for _, username := range tt.users {
+				users = append(users, admin.CloudDatabaseUser{DatabaseName: "admin", Username: username, Password: admin.PtrString("old-password"), Roles: &roles})
+			}
+			atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, testutil.WithProjectUsers(testProjectId, "payments", users...))
+			ctx := context.Background()
+
+			for _, step := range []func(context.Context, SecretsManagerAPI, *admin.APIClient, string, string) error{CreateSecret, SetSecret, FinishSecret} {
+				if err := step(ctx, smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+					t.Fatalf("rotation step error = %v", err)
+				}
+			}
+			currentDict := stagedDict(t, smClient, "AWSCURRENT")
+			if currentDict["username"] != tt.wantUsername {
+				t.Errorf("AWSCURRENT username = %q, want %q", currentDict["username"], tt.wantUsername)
+			}
+			for _, username := range []string{"app", "app_clone"} {
+				user, ok := atlas.User(testProjectId, "admin", username)
+				wantExists := slices.Contains(tt.users, username) || slices.Contains(tt.wantNewPassword, username)
+				if ok != wantExists {
+					t.Errorf("user %v exists = %v, want %v", username, ok, wantExists)
+					continue
+				}
+				want := "old-password"
+				if slices.Contains(tt.wantNewPassword, username) {
+					want = currentDict["password"]
+				}
+				if ok && user.GetPassword() != want {
+					t.Errorf("user %v password = %q, want %q", username, user.GetPassword(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// selftest.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/smithy-go"
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// SelfTestStep is the operator invoked step reporting the health of the function, {"Step": "selfTest"}
+const SelfTestStep = "selfTest"
+
+// SelfTestReport
+//
+// Diagnostics of the function environment, holding no secret material
+type SelfTestReport struct {
+	Region                  string   `json:"region"`
+	ConfigValid             bool     `json:"config_valid"`
+	SecretsManagerReachable bool     `json:"secrets_manager_reachable"`
+	ApiKeySecret            string   `json:"api_key_secret"`
+	ApiKeySecretReadable    bool     `json:"api_key_secret_readable"`
+	AuthMode                string   `json:"auth_mode,omitempty"`
+	AtlasReachable          bool     `json:"atlas_reachable"`
+	AtlasOrganizations      []string `json:"atlas_organizations,omitempty"`
+	AtlasKeyRoles           []string `json:"atlas_key_roles,omitempty"`
+	Errors                  []string `json:"errors,omitempty"`
+}
+
+// RunSelfTest
+//
+// Check the function can reach its dependencies and report what it found
+//
+//	The configuration is checked first (see CheckConfig), its problems are reported and the other checks still run
+//	with the settings that did load. The step is not subject to ENABLED_STEPS, it only reads.
+//	The Atlas credentials secret is the one of the given secret ('atlas_api_key_secret', see GetApiKeySecretName) or
+//	MONGODB_ATLAS_SECRET_NAME.
+//	Secrets Manager is reachable when it answered, even with an error; Atlas likewise when it answered the
+//	organizations listing. The roles of an API key are looked up in every organization it can list, they are not
+//	reported for a service account. Errors are redacted of the credentials.
+//
+//	Args:
+//	    smClient (client): The secrets manager service client
+//
+//	    arn (string): The secret ARN or other identifier, may be empty
+//
+//	Returns:
+//	    SelfTestReport: The diagnostics
+func RunSelfTest(ctx context.Context, smClient SecretsManagerAPI, arn string) SelfTestReport {
+	report := SelfTestReport{Region: cfg.Region}
+	if err := CheckConfig(); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("config: %v", err))
+	} else {
+		report.ConfigValid = true
+	}
+	if arn != "" {
+		keySecretName, err := GetApiKeySecretName(ctx, smClient, arn)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("api key secret: %v", err))
+			return report
+		}
+		report.ApiKeySecret = keySecretName
+	}
+	if report.ApiKeySecret == "" {
+		report.ApiKeySecret = settings.AtlasSecretName
+	}
+	if report.ApiKeySecret == "" {
+		report.Errors = append(report.Errors, "MONGODB_ATLAS_SECRET_NAME environment variable is not set")
+		return report
+	}
+	secretValue, err := smClient.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &report.ApiKeySecret})
+	var apiErr smithy.APIError
+	report.SecretsManagerReachable = err == nil || errors.As(err, &apiErr)
+	var secretData map[string]string
+	if err == nil {
+		var secretString string
+		if secretString, err = GetSecretValueString(secretValue); err == nil {
+			secretData, err = ParseSecretDict(secretString)
+		}
+	}
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("api key secret: %v", err))
+		return report
+	}
+	report.ApiKeySecretReadable = true
+	credentials := []string{secretData["private_key"], secretData["client_secret"]}
+
+	var mongoAdmin *admin.APIClient
+	if IsServiceAccountSecret(secretData) {
+		report.AuthMode = "service_account"
+		mongoAdmin, err = NewAtlasServiceAccountClient(secretData["client_id"], secretData["client_secret"])
+	} else {
+		report.AuthMode = "api_key"
+		mongoAdmin, err = NewAtlasClient(secretData["public_key"], secretData["private_key"])
+	}
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("atlas client: %v", RedactError(err, credentials...)))
+		return report
+	}
+	organizations, resp, err := mongoAdmin.OrganizationsApi.ListOrganizations(ctx).Execute()
+	report.AtlasReachable = err == nil || resp != nil
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("atlas organizations: %v", RedactError(AtlasError(err), credentials...)))
+		return report
+	}
+	for _, organization := range organizations.GetResults() {
+		report.AtlasOrganizations = append(report.AtlasOrganizations, organization.GetId())
+	}
+	if report.AuthMode != "api_key" {
+		return report
+	}
+	for _, orgId := range report.AtlasOrganizations {
+		apiKeys, _, err := mongoAdmin.ProgrammaticAPIKeysApi.ListApiKeys(ctx, orgId).ItemsPerPage(500).Execute()
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("atlas api keys of %v: %v", orgId, RedactError(AtlasError(err), credentials...)))
+			continue
+		}
+		for _, apiKey := range apiKeys.GetResults() {
+			if apiKey.GetPublicKey() != secretData["public_key"] {
+				continue
+			}
+			for _, role := range apiKey.GetRoles() {
+				scope := role.GetOrgId()
+				if role.GetGroupId() != "" {
+					scope = role.GetGroupId()
+				}
+				report.AtlasKeyRoles = append(report.AtlasKeyRoles, role.GetRoleName()+"@"+scope)
+			}
+		}
+	}
+	return report
+}
+
+// LogSelfTestReport
+//
+// Log the self test report as a single JSON line
+//
+//	Args:
+//	    report (SelfTestReport): The diagnostics
+//
+//	Returns:
+//	    error: Error listing the failed checks, nil when every check passed
+func LogSelfTestReport(report SelfTestReport) error {
+	jsonReport, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal self test report: %w", err)
+	}
+	log.Printf("SelfTest: %s", jsonReport)
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("self test failed: %v", strings.Join(report.Errors, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// selftest_test.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+const (
+	testApiKeySecretArn = "arn:aws:secretsmanager:us-east-1:123456789012:secret:atlas-api-key"
+	testPrivateKey      = "6d2a1c1e-0000-4000-8000-00000000abcd"
+	testClientSecret    = "mdb_sa_sk_0123456789abcdef"
+)
+
+// newSelfTestAtlas serves the Atlas API calls of RunSelfTest, every call answering with status when it is not 200.
+func newSelfTestAtlas(t *testing.T, status int) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/oauth/token":
+			_, _ = w.Write([]byte(`{"access_token": "sa-token", "token_type": "Bearer", "expires_in": 3600}`))
+		case status != http.StatusOK:
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"error": 401, "errorCode": "NOT_ORG_GROUP_CREATOR", "detail": "Unauthorized."}`))
+		case r.URL.Path == "/api/atlas/v2/orgs":
+			_, _ = w.Write([]byte(`{"results": [{"id": "` + testOrgId + `", "name": "acme"}], "totalCount": 1}`))
+		case r.URL.Path == "/api/atlas/v2/orgs/"+testOrgId+"/apiKeys":
+			_, _ = w.Write([]byte(`{"results": [
+				{"id": "key1", "publicKey": "otherkey", "roles": [{"roleName": "ORG_OWNER", "orgId": "` + testOrgId + `"}]},
+				{"id": "key2", "publicKey": "rotator", "roles": [
+					{"roleName": "ORG_MEMBER", "orgId": "` + testOrgId + `"},
+					{"roleName": "GROUP_DATABASE_ACCESS_ADMIN", "groupId": "` + testProjectId + `"}]}
+			], "totalCount": 2}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestRunSelfTest(t *testing.T) {
+	apiKey := `{"public_key": "rotator", "private_key": "` + testPrivateKey + `"}`
+	serviceAccount := `{"client_id": "mdb_sa_id", "client_secret": "` + testClientSecret + `"}`
+	tests := []struct {
+		name string
+		// secretName is MONGODB_ATLAS_SECRET_NAME, the credentials secret when the rotated secret doesn't name one
+		secretName string
+		// apiKeyField is the 'atlas_api_key_secret' of the rotated secret, "" to run without a secret
+		apiKeyField string
+		credentials string
+		// secretErr fails the read of the credentials secret
+		secretErr   error
+		atlasStatus int
+		// atlasDown closes the Atlas server before the self test
+		atlasDown bool
+		// env is set on top of the credentials secret settings
+		env      map[string]string
+		want     SelfTestReport
+		wantErrs int
+	}{
+		{name: "api key", secretName: testApiKeySecretArn, credentials: apiKey, atlasStatus: http.StatusOK,
+			want: SelfTestReport{SecretsManagerReachable: true, ApiKeySecretReadable: true, AuthMode: "api_key", AtlasReachable: true,
+				AtlasOrganizations: []string{testOrgId}, AtlasKeyRoles: []string{"ORG_MEMBER@" + testOrgId, "GROUP_DATABASE_ACCESS_ADMIN@" + testProjectId}}},
+		{name: "api key named by the secret", apiKeyField: testApiKeySecretArn, credentials: apiKey, atlasStatus: http.StatusOK,
+			want: SelfTestReport{SecretsManagerReachable: true, ApiKeySecretReadable: true, AuthMode: "api_key", AtlasReachable: true,
+				AtlasOrganizations: []string{testOrgId}, AtlasKeyRoles: []string{"ORG_MEMBER@" + testOrgId, "GROUP_DATABASE_ACCESS_ADMIN@" + testProjectId}}},
+		{name: "api key named by the secret not listed", apiKeyField: "other-api-key", credentials: apiKey, wantErrs: 1},
+		{name: "service account", secretName: testApiKeySecretArn, credentials: serviceAccount, atlasStatus: http.StatusOK,
+			want: SelfTestReport{SecretsManagerReachable: true, ApiKeySecretReadable: true, AuthMode: "service_account", AtlasReachable: true,
+				AtlasOrganizations: []string{testOrgId}}},
+		{name: "no credentials secret", wantErrs: 1},
+		{name: "invalid configuration", secretName: testApiKeySecretArn, credentials: apiKey, atlasStatus: http.StatusOK,
+			env: map[string]string{"PASSWORD_LENGTH": "long"},
+			want: SelfTestReport{SecretsManagerReachable: true, ApiKeySecretReadable: true, AuthMode: "api_key", AtlasReachable: true,
+				AtlasOrganizations: []string{testOrgId}, AtlasKeyRoles: []string{"ORG_MEMBER@" + testOrgId, "GROUP_DATABASE_ACCESS_ADMIN@" + testProjectId}},
+			wantErrs: 1},
+		{name: "credentials secret not found", secretName: testApiKeySecretArn, credentials: apiKey,
+			secretErr: &types.ResourceNotFoundException{Message: aws.String("secret not found")},
+			want:      SelfTestReport{SecretsManagerReachable: true}, wantErrs: 1},
+		{name: "atlas rejects the key", secretName: testApiKeySecretArn, credentials: apiKey, atlasStatus: http.StatusUnauthorized,
+			want: SelfTestReport{SecretsManagerReachable: true, ApiKeySecretReadable: true, AuthMode: "api_key", AtlasReachable: true}, wantErrs: 1},
+		{name: "atlas unreachable", secretName: testApiKeySecretArn, credentials: apiKey, atlasStatus: http.StatusOK, atlasDown: true,
+			want: SelfTestReport{SecretsManagerReachable: true, ApiKeySecretReadable: true, AuthMode: "api_key"}, wantErrs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "ATLAS_RATE_LIMIT_PACING", "OTEL_EXPORTER_OTLP_ENDPOINT")
+			unsetEnv(t, "PASSWORD_LENGTH")
+			setEnv(t, map[string]string{"MONGODB_ATLAS_SECRET_NAME": tt.secretName, "ATLAS_API_KEY_SECRETS": testApiKeySecretArn})
+			setEnv(t, tt.env)
+			fakeAWS(t, nil)
+			atlasURL := newSelfTestAtlas(t, tt.atlasStatus)
+			if tt.atlasDown {
+				atlasURL = "http://127.0.0.1:1"
+			}
+			setEnv(t, map[string]string{"ATLAS_BASE_URL": atlasURL})
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testApiKeySecretArn, testCurrentToken, tt.credentials)
+			arn := ""
+			if tt.apiKeyField != "" {
+				arn = testSecretArn
+				smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", map[string]string{"atlas_api_key_secret": tt.apiKeyField}))
+			}
+			if tt.secretErr != nil {
+				smClient.FailNext("GetSecretValue", tt.secretErr)
+			}
+
+			report := RunSelfTest(context.Background(), smClient, arn)
+			tt.want.Region = "us-east-1"
+			tt.want.ConfigValid = tt.env == nil
+			if tt.want.ApiKeySecretReadable || tt.secretErr != nil {
+				tt.want.ApiKeySecret = testApiKeySecretArn
+			}
+			if len(report.Errors) != tt.wantErrs {
+				t.Errorf("RunSelfTest() errors = %q, want %d", report.Errors, tt.wantErrs)
+			}
+			if !tt.want.ConfigValid && (len(report.Errors) == 0 || !strings.HasPrefix(report.Errors[0], "config: invalid configuration")) {
+				t.Errorf("RunSelfTest() errors = %q, want the configuration error first", report.Errors)
+			}
+			if jsonReport := mustJson(t, report); strings.Contains(jsonReport, testPrivateKey) || strings.Contains(jsonReport, testClientSecret) {
+				t.Errorf("RunSelfTest() = %v, holds secret material", jsonReport)
+			}
+			report.Errors = nil
+			if got, want := mustJson(t, report), mustJson(t, tt.want); got != want {
+				t.Errorf("RunSelfTest() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLogSelfTestReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  SelfTestReport
+		wantErr bool
+	}{
+		{name: "passed", report: SelfTestReport{Region: "us-east-1", SecretsManagerReachable: true}},
+		{name: "failed", report: SelfTestReport{Region: "us-east-1", Errors: []string{"api key secret: not found", "atlas organizations: 401"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			err := LogSelfTestReport(tt.report)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LogSelfTestReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), strings.Join(tt.report.Errors, "; ")) {
+				t.Errorf("LogSelfTestReport() error = %v, want every failed check", err)
+			}
+			if !strings.Contains(logs.String(), "SelfTest: "+mustJson(t, tt.report)+"\n") {
+				t.Errorf("LogSelfTestReport() logged %q, want the JSON report on one line", logs.String())
+			}
+		})
+	}
+}
+
+// mustJson marshals the value for comparison.
+func mustJson(t *testing.T, value any) string {
+	t.Helper()
+	jsonValue, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("failed to marshal %v: %v", value, err)
+	}
+	return string(jsonValue)
+}
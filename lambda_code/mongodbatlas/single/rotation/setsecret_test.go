@@ -0,0 +1,311 @@
+// setsecret_test.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+var _ SecretsManagerAPI = (*testutil.FakeSecretsManager)(nil)
+
+const (
+	testSecretArn    = "arn:aws:secretsmanager:us-east-1:123456789012:secret:atlas-user"
+	testCurrentToken = "11111111-1111-4111-8111-111111111111"
+	testPendingToken = "22222222-2222-4222-8222-222222222222"
+	testProjectId    = "65a1b2c3d4e5f60718293a4b"
+)
+
+// atlasSecret is a mongodbatlas secret of the test project with the given password and extra fields.
+func atlasSecret(t *testing.T, password string, fields map[string]string) string {
+	t.Helper()
+	secretDict := map[string]string{
+		"engine":       "mongodbatlas",
+		"username":     "app",
+		"password":     password,
+		"project_id":   testProjectId,
+		"project_name": "payments",
+		"host":         "cluster0.abcde.mongodb.net",
+	}
+	for key, value := range fields {
+		secretDict[key] = value
+	}
+	secretString, err := json.Marshal(secretDict)
+	if err != nil {
+		t.Fatalf("failed to marshal secret: %v", err)
+	}
+	return string(secretString)
+}
+
+// newRotationFakes creates the fakes holding the current and pending versions of the test secret and its Atlas user.
+func newRotationFakes(t *testing.T, currentFields map[string]string, pendingFields map[string]string) (*testutil.FakeSecretsManager, *testutil.FakeAtlas, *admin.APIClient) {
+	t.Helper()
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", currentFields))
+	smClient.PutVersion(testSecretArn, testPendingToken, atlasSecret(t, "new-password", pendingFields), "AWSPENDING")
+	atlas, mongoAdmin := testutil.NewFakeAtlasClient(t, testutil.WithProjectUsers(testProjectId, "payments",
+		admin.CloudDatabaseUser{DatabaseName: "admin", Username: "app"}))
+	return smClient, atlas, mongoAdmin
+}
+
+func TestSetSecretUpdatesAtlasUser(t *testing.T) {
+	unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT")
+	smClient, atlas, mongoAdmin := newRotationFakes(t, nil, nil)
+
+	if err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+	user, ok := atlas.User(testProjectId, "admin", "app")
+	if !ok {
+		t.Fatalf("user app was removed from the project")
+	}
+	if got := user.GetPassword(); got != "new-password" {
+		t.Errorf("user password = %q, want the AWSPENDING password", got)
+	}
+}
+
+func TestSetSecretRevertsUpdatedProjectsOnFailure(t *testing.T) {
+	const (
+		secondProjectId = "65a1b2c3d4e5f60718293a5b"
+		thirdProjectId  = "65a1b2c3d4e5f60718293a6b"
+	)
+	projects := map[string]string{
+		"projects": `[{"project_id": "` + secondProjectId + `", "project_name": "payments-dr"}, {"project_id": "` + thirdProjectId + `", "project_name": "payments-eu"}]`,
+		"roles":    `[{"roleName": "readWrite", "databaseName": "app"}]`,
+	}
+	tests := []struct {
+		name string
+		// successes is the number of user updates or creations that succeed before the failing one
+		successes int
+		// missing is the project whose user is created by setSecret
+		missing string
+		// want is the expected password by project after the failure, "" for no user
+		want map[string]string
+	}{
+		{"first project fails", 0, "", map[string]string{testProjectId: "old-password", secondProjectId: "old-password", thirdProjectId: "old-password"}},
+		{"second project fails", 1, "", map[string]string{testProjectId: "old-password", secondProjectId: "old-password", thirdProjectId: "old-password"}},
+		{"last project fails", 2, "", map[string]string{testProjectId: "old-password", secondProjectId: "old-password", thirdProjectId: "old-password"}},
+		{"created user is deleted", 2, secondProjectId, map[string]string{testProjectId: "old-password", secondProjectId: "", thirdProjectId: "old-password"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "DEFAULT_AUTH_DATABASE")
+			setEnv(t, map[string]string{"CREATE_USER_IF_MISSING": "true"})
+			smClient, atlas, mongoAdmin := newRotationFakes(t, projects, projects)
+			atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: "admin", Username: "app", Password: admin.PtrString("old-password")})
+			for _, projectId := range []string{secondProjectId, thirdProjectId} {
+				atlas.AddProject(projectId, "")
+				if projectId != tt.missing {
+					atlas.AddUser(admin.CloudDatabaseUser{GroupId: projectId, DatabaseName: "admin", Username: "app", Password: admin.PtrString("old-password")})
+				}
+			}
+			operation := func(i int) string {
+				if []string{testProjectId, secondProjectId, thirdProjectId}[i] == tt.missing {
+					return "CreateDatabaseUser"
+				}
+				return "UpdateDatabaseUser"
+			}
+			for i := 0; i < tt.successes; i++ {
+				atlas.SucceedNext(operation(i))
+			}
+			atlas.FailNext(operation(tt.successes), http.StatusBadRequest)
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if !errors.Is(err, ErrSetSecret) {
+				t.Fatalf("SetSecret() error = %v, want ErrSetSecret", err)
+			}
+			for projectId, want := range tt.want {
+				user, ok := atlas.User(projectId, "admin", "app")
+				switch {
+				case want == "" && ok:
+					t.Errorf("user created in project %v was not deleted", projectId)
+				case want != "" && !ok:
+					t.Errorf("user of project %v was removed", projectId)
+				case want != "" && user.GetPassword() != want:
+					t.Errorf("user password in project %v = %q, want %q", projectId, user.GetPassword(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetSecretDefaultAuthDatabase(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		env    string
+		want   string
+	}{
+		{name: "admin by default", want: "admin"},
+		{name: "environment", env: "users", want: "users"},
+		{name: "secret field wins", fields: map[string]string{"auth_database": "app"}, env: "users", want: "app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING")
+			setEnv(t, map[string]string{"DEFAULT_AUTH_DATABASE": tt.env})
+			smClient, atlas, mongoAdmin := newRotationFakes(t, tt.fields, tt.fields)
+			for _, databaseName := range []string{"users", "app"} {
+				atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: databaseName, Username: "app"})
+			}
+
+			if err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+				t.Fatalf("SetSecret() error = %v", err)
+			}
+			for _, databaseName := range []string{"admin", "users", "app"} {
+				want := ""
+				if databaseName == tt.want {
+					want = "new-password"
+				}
+				if user, _ := atlas.User(testProjectId, databaseName, "app"); user.GetPassword() != want {
+					t.Errorf("user password in database %v = %q, want %q", databaseName, user.GetPassword(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetSecretMixedCaseEngine(t *testing.T) {
+	for _, engine := range []string{"MongoDBAtlas", "MONGODBATLAS"} {
+		t.Run(engine, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING", "DEFAULT_AUTH_DATABASE")
+			fields := map[string]string{"engine": engine}
+			smClient, atlas, mongoAdmin := newRotationFakes(t, fields, fields)
+
+			if err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken); err != nil {
+				t.Fatalf("SetSecret() error = %v", err)
+			}
+			if user, _ := atlas.User(testProjectId, "admin", "app"); user.GetPassword() != "new-password" {
+				t.Errorf("user password = %q, want the AWSPENDING password set through the Atlas API", user.GetPassword())
+			}
+		})
+	}
+}
+
+func TestSetSecretRetriesPendingNotFound(t *testing.T) {
+	tests := []struct {
+		name     string
+		notFound int
+		wantErr  bool
+	}{
+		{name: "visible at once"},
+		{name: "not found once", notFound: 1},
+		{name: "not found until the retries are exhausted", notFound: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING")
+			setEnv(t, map[string]string{"RETRY_MAX_ATTEMPTS": "3", "RETRY_BASE_DELAY_MS": "1"})
+			smClient, atlas, mongoAdmin := newRotationFakes(t, nil, nil)
+			for range tt.notFound {
+				smClient.FailNext("GetSecretValue", &types.ResourceNotFoundException{Message: aws.String("version not found")})
+			}
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			want := "new-password"
+			if tt.wantErr {
+				want = ""
+			}
+			if user, _ := atlas.User(testProjectId, "admin", "app"); user.GetPassword() != want {
+				t.Errorf("user password = %q, want %q", user.GetPassword(), want)
+			}
+		})
+	}
+}
+
+func TestGetSecretStringNotFoundWithoutRetry(t *testing.T) {
+	setEnv(t, map[string]string{"RETRY_MAX_ATTEMPTS": "3", "RETRY_BASE_DELAY_MS": "1"})
+	smClient := testutil.NewFakeSecretsManager()
+	smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+	smClient.FailNext("GetSecretValue", &types.ResourceNotFoundException{Message: aws.String("version not found")})
+
+	// Only reads of a version staged by a previous step retry a missing version
+	_, err := GetSecretString(context.Background(), smClient, RotationConfig{arn: aws.String(testSecretArn), stage: "AWSCURRENT"})
+	if !IsResourceNotFound(err) {
+		t.Errorf("GetSecretString() error = %v, want ResourceNotFoundException", err)
+	}
+}
+
+func TestSetSecretAuthDatabases(t *testing.T) {
+	tests := []struct {
+		name          string
+		authDatabases string
+		users         []string
+		want          map[string]string
+		wantErr       bool
+	}{
+		{name: "every auth database", authDatabases: `["admin", "app", "reporting"]`, users: []string{"app", "reporting"},
+			want: map[string]string{"admin": "new-password", "app": "new-password", "reporting": "new-password"}},
+		{name: "only the listed auth databases", authDatabases: `["app"]`, users: []string{"app", "reporting"},
+			want: map[string]string{"admin": "old-password", "app": "new-password", "reporting": "old-password"}},
+		// Every user is resolved before any update, a missing one changes nothing
+		{name: "missing user", authDatabases: `["admin", "app", "reporting"]`, users: []string{"app"},
+			want: map[string]string{"admin": "old-password", "app": "old-password"}, wantErr: true},
+		{name: "invalid list", authDatabases: `{"admin": true}`, users: []string{"app"},
+			want: map[string]string{"admin": "old-password", "app": "old-password"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "WAIT_FOR_USER_ACTIVE", "VERIFY_PROJECT_NAME", "EXPECTED_ROLES_STRICT", "CREATE_USER_IF_MISSING", "DEFAULT_AUTH_DATABASE")
+			fields := map[string]string{"auth_databases": tt.authDatabases}
+			smClient, atlas, mongoAdmin := newRotationFakes(t, fields, fields)
+			for _, authDatabase := range append([]string{"admin"}, tt.users...) {
+				atlas.AddUser(admin.CloudDatabaseUser{GroupId: testProjectId, DatabaseName: authDatabase, Username: "app", Password: admin.PtrString("old-password")})
+			}
+
+			err := SetSecret(context.Background(), smClient, mongoAdmin, testSecretArn, testPendingToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for authDatabase, want := range tt.want {
+				if user, _ := atlas.User(testProjectId, authDatabase, "app"); user.GetPassword() != want {
+					t.Errorf("user password in auth database %v = %q, want %q", authDatabase, user.GetPassword(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetSecretDictEngineMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]string
+		permissive bool
+		wantErr    bool
+	}{
+		{name: "strict supported engine"},
+		{name: "strict unsupported engine", fields: map[string]string{"engine": "postgres"}, wantErr: true},
+		{name: "strict no engine", fields: map[string]string{"engine": ""}, wantErr: true},
+		{name: "permissive unsupported engine", fields: map[string]string{"engine": "postgres"}, permissive: true},
+		{name: "permissive no engine", fields: map[string]string{"engine": ""}, permissive: true},
+		// The connection string schemes are checked in both modes
+		{name: "permissive swapped scheme", fields: map[string]string{"engine": "postgres", "connection_string_srv": "mongodb://cluster0.abcde.mongodb.net/"},
+			permissive: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "DEFAULT_ENGINE")
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", tt.fields))
+
+			secretDict, err := GetSecretDict(context.Background(), smClient, RotationConfig{arn: aws.String(testSecretArn), stage: "AWSCURRENT", permissiveEngine: tt.permissive})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecretDict() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && secretDict["password"] != "old-password" {
+				t.Errorf("GetSecretDict() password = %q, want the AWSCURRENT password", secretDict["password"])
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// steps.go
+package rotation
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Sentinel errors wrapped by the error of each rotation step, so callers can tell the failed step with errors.Is.
+var (
+	ErrCreateSecret = errors.New("createSecret failed")
+	ErrSetSecret    = errors.New("setSecret failed")
+	ErrTestSecret   = errors.New("testSecret failed")
+	ErrFinishSecret = errors.New("finishSecret failed")
+)
+
+// ErrStepDisabled
+//
+// The requested step is not listed in ENABLED_STEPS
+var ErrStepDisabled = errors.New("step disabled")
+
+// StepError
+//
+// Error of a rotation step
+//
+//	The message is the one of the underlying error, both the step sentinel and the underlying error are unwrapped
+//	for errors.Is and errors.As.
+type StepError struct {
+	Step error
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StepError) Unwrap() []error {
+	return []error{e.Step, e.Err}
+}
+
+// wrapStepError wraps err, if any, in a StepError for the given step sentinel.
+func wrapStepError(step error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StepError{Step: step, Err: err}
+}
+
+// CheckStepEnabled
+//
+// Refuse the steps not listed in ENABLED_STEPS
+//
+//	ENABLED_STEPS environment variable holds a comma separated list of the steps the function may run, so it can be
+//	rolled out a step at a time. No rotation step is read only: createSecret stages a new AWSPENDING version with
+//	PutSecretValue, setSecret changes the database password and finishSecret moves AWSCURRENT. Enabling createSecret
+//	alone first leaves the database and AWSCURRENT untouched, setSecret, testSecret and finishSecret are enabled
+//	together afterwards since testSecret fails until setSecret ran. The selfTest step is not subject to the list.
+//	Every step is enabled when it is not set.
+//
+//	Args:
+//	    step (string): The requested step
+//
+//	Returns:
+//	    error: Error wrapping ErrStepDisabled if the step is not enabled
+func CheckStepEnabled(step string) error {
+	if settings.EnabledSteps == nil || slices.ContainsFunc(settings.EnabledSteps, func(enabled string) bool { return strings.EqualFold(enabled, step) }) {
+		return nil
+	}
+	return fmt.Errorf("%w: %v is not in ENABLED_STEPS %v", ErrStepDisabled, step, strings.Join(settings.EnabledSteps, ","))
+}
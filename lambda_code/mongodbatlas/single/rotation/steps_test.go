@@ -0,0 +1,141 @@
+// steps_test.go
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+func TestRunRotationStepErrors(t *testing.T) {
+	stepErrors := []error{ErrCreateSecret, ErrSetSecret, ErrTestSecret, ErrFinishSecret}
+	tests := []struct {
+		step      string
+		operation string
+		want      error
+	}{
+		{"createSecret", "GetSecretValue", ErrCreateSecret},
+		{"setSecret", "GetSecretValue", ErrSetSecret},
+		{"testSecret", "GetSecretValue", ErrTestSecret},
+		{"finishSecret", "UpdateSecretVersionStage", ErrFinishSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			unsetEnv(t, "ROTATION_LOCK", "ENABLED_STEPS", "SKIP_TEST_SECRET", "ENABLE_ROLLBACK", "FINISH_DELAY_SECONDS", "DEFAULT_ENGINE")
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+			smClient.PutVersion(testSecretArn, testPendingToken, atlasSecret(t, "new-password", nil), "AWSPENDING")
+			cause := errors.New("injected " + tt.operation + " failure")
+			smClient.FailNext(tt.operation, cause)
+
+			err := RunRotationStep(context.Background(), smClient, nil, describeTestSecret(t, smClient), tt.step, testSecretArn, testPendingToken)
+			if err == nil {
+				t.Fatalf("%s error = nil, want %v", tt.step, tt.want)
+			}
+			for _, stepErr := range stepErrors {
+				if got := errors.Is(err, stepErr); got != (stepErr == tt.want) {
+					t.Errorf("errors.Is(err, %v) = %v, want %v", stepErr, got, stepErr == tt.want)
+				}
+			}
+			if !strings.Contains(err.Error(), cause.Error()) {
+				t.Errorf("%s error = %v, want it to carry %v", tt.step, err, cause)
+			}
+			var stepError *StepError
+			if !errors.As(err, &stepError) || stepError.Step != tt.want {
+				t.Errorf("errors.As(err, *StepError) = %v, want a StepError of %v", stepError, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunRotationStepVersionsStaged(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions map[string][]string
+		wantErr  string
+	}{
+		{name: "nil versions", wantErr: "has no versions staged"},
+		{name: "empty versions", versions: map[string][]string{}, wantErr: "has no versions staged"},
+		{name: "version not found", versions: map[string][]string{testCurrentToken: {"AWSCURRENT"}}, wantErr: "secret version " + testPendingToken + " not found"},
+		{name: "version not pending", versions: map[string][]string{testPendingToken: {"AWSPREVIOUS"}}, wantErr: "not in pending state"},
+		{name: "version already current", versions: map[string][]string{testPendingToken: {"AWSCURRENT"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "ROTATION_LOCK", "ALLOW_ROTATION_WHEN_UNKNOWN")
+			secret := &secretsmanager.DescribeSecretOutput{ARN: aws.String(testSecretArn), RotationEnabled: aws.Bool(true), VersionIdsToStages: tt.versions}
+
+			err := RunRotationStep(context.Background(), testutil.NewFakeSecretsManager(), nil, secret, "createSecret", testSecretArn, testPendingToken)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("RunRotationStep() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWrapStepError(t *testing.T) {
+	if err := wrapStepError(ErrCreateSecret, nil); err != nil {
+		t.Errorf("wrapStepError(nil) = %v, want nil", err)
+	}
+	cause := errors.New("boom")
+	err := wrapStepError(ErrSetSecret, cause)
+	if err.Error() != cause.Error() {
+		t.Errorf("wrapStepError() message = %q, want %q", err.Error(), cause.Error())
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("wrapStepError() = %v, want it to wrap %v", err, cause)
+	}
+}
+
+func TestCheckStepEnabled(t *testing.T) {
+	tests := []struct {
+		name         string
+		enabledSteps string
+		step         string
+		wantErr      bool
+	}{
+		{name: "unset", step: "finishSecret"},
+		{name: "blank", enabledSteps: " ", step: "setSecret"},
+		{name: "listed", enabledSteps: "createSecret,testSecret", step: "testSecret"},
+		{name: "listed with spaces and case", enabledSteps: " createsecret , TESTSECRET ", step: "createSecret"},
+		{name: "createSecret rollout refuses setSecret", enabledSteps: "createSecret", step: "setSecret", wantErr: true},
+		{name: "createSecret rollout refuses finishSecret", enabledSteps: "createSecret", step: "finishSecret", wantErr: true},
+		{name: "reconcile must be listed", enabledSteps: "createSecret,setSecret,testSecret,finishSecret", step: ReconcileStep, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"ENABLED_STEPS": tt.enabledSteps})
+			err := CheckStepEnabled(tt.step)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckStepEnabled(%q) error = %v, wantErr %v", tt.step, err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrStepDisabled) {
+				t.Errorf("CheckStepEnabled(%q) error = %v, want ErrStepDisabled", tt.step, err)
+			}
+		})
+	}
+}
+
+func TestHandleRequestStepDisabled(t *testing.T) {
+	setEnv(t, map[string]string{"ENABLED_STEPS": "createSecret,testSecret"})
+	transport := fakeAWS(t, nil)
+	event, err := json.Marshal(SecretsManagerEvent{SecretId: testSecretArn, ClientRequestToken: testPendingToken, Step: "setSecret"})
+	if err != nil {
+		t.Fatalf("failed to marshal event: %v", err)
+	}
+
+	err = HandleRequest(context.Background(), event)
+	if !errors.Is(err, ErrStepDisabled) {
+		t.Fatalf("HandleRequest() error = %v, want ErrStepDisabled", err)
+	}
+	if calls := transport.Calls(); len(calls) != 0 {
+		t.Errorf("HandleRequest() made AWS calls %v for a disabled step", calls)
+	}
+}
@@ -0,0 +1,134 @@
+// teststrategy.go
+package rotation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// TestStrategy
+//
+// Validation of a pending secret for one engine, run by TestSecret
+type TestStrategy interface {
+	// Test validates the credential of the pending secret dictionary
+	Test(ctx context.Context, smClient SecretsManagerAPI, mongoAdmin *admin.APIClient, secretDict map[string]string) error
+}
+
+// testStrategies are the registered strategies keyed by engine, see GetTestStrategy.
+var testStrategies = map[string]TestStrategy{
+	"mongodbatlas": connectionTestStrategy{ephemeralUser: true, awaitPropagation: true},
+	"documentdb":   connectionTestStrategy{},
+}
+
+// GetTestStrategy
+//
+// Get the test strategy of the engine
+//
+//	Args:
+//	    engine (string): The secret engine
+//
+//	Returns:
+//	    TestStrategy: The strategy registered for the engine
+//	    error: Error if no strategy is registered for the engine
+func GetTestStrategy(engine string) (TestStrategy, error) {
+	strategy, ok := testStrategies[engine]
+	if !ok {
+		return nil, fmt.Errorf("no test strategy for engine %v", engine)
+	}
+	return strategy, nil
+}
+
+// connectionTestStrategy logs into the database with the secret and pings it, then does the same for the listed
+// projects. With awaitPropagation, authentication failures are retried while the new password propagates, see
+// WaitForCredentialPropagation. With ephemeralUser, USE_EPHEMERAL_TEST_USER also tests with temporary users once
+// the pending credential passed, see TestWithEphemeralUser.
+type connectionTestStrategy struct {
+	ephemeralUser    bool
+	awaitPropagation bool
+}
+
+func (s connectionTestStrategy) Test(ctx context.Context, smClient SecretsManagerAPI, mongoAdmin *admin.APIClient, secretDict map[string]string) error {
+	var err error
+	if s.awaitPropagation {
+		err = WaitForCredentialPropagation(ctx, "TestSecret", func() error { return testConnections(ctx, secretDict) })
+	} else {
+		err = testConnections(ctx, secretDict)
+	}
+	if err != nil {
+		return err
+	}
+	if s.ephemeralUser && settings.UseEphemeralTestUser {
+		if err = TestWithEphemeralUser(ctx, smClient, mongoAdmin, secretDict); err != nil {
+			return fmt.Errorf("failed to test with temporary user: %w", err)
+		}
+		log.Printf("TestSecret: Successfully tested with temporary user")
+	}
+	return nil
+}
+
+// testConnections pings the database with the secret in every authentication database, then the listed projects.
+func testConnections(ctx context.Context, secretDict map[string]string) error {
+	authDatabases, err := GetAuthDatabases(secretDict)
+	if err != nil {
+		return err
+	}
+	if len(authDatabases) == 1 {
+		if err = pingWithSecret(ctx, secretDict); err != nil {
+			return err
+		}
+	} else {
+		for _, authDatabase := range authDatabases {
+			authDict, err := WithAuthSource(secretDict, authDatabase)
+			if err != nil {
+				return err
+			}
+			if err = pingWithSecret(ctx, authDict); err != nil {
+				return fmt.Errorf("auth database %v: %w", authDatabase, err)
+			}
+		}
+	}
+	return TestProjects(ctx, secretDict)
+}
+
+// pingWithSecret connects with the connection strings of the secret, GetConnection pings the database.
+func pingWithSecret(ctx context.Context, secretDict map[string]string) error {
+	conn, err := GetConnection(ctx, secretDict)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	_ = conn.Disconnect(ctx)
+	log.Printf("TestSecret: Successfully pinged MongoDB with pending secret")
+	return nil
+}
+
+// WithAuthSource
+//
+// Copy the secret with the authSource option of every connection string set to the given database
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	    authDatabase (string): The authentication database
+//
+//	Returns:
+//	    map[string]string: The copy of the secret
+//	    error: Error if a connection string could not be updated
+func WithAuthSource(secretDict map[string]string, authDatabase string) (map[string]string, error) {
+	authDict := maps.Clone(secretDict)
+	for _, key := range connectionStringKeys {
+		if strings.TrimSpace(authDict[key]) == "" {
+			continue
+		}
+		connString, err := MergeConnectionOptions(authDict[key], map[string]string{"authSource": authDatabase}, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set authSource of %v: %w", key, err)
+		}
+		authDict[key] = connString
+	}
+	return authDict, nil
+}
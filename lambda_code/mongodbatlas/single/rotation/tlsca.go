@@ -0,0 +1,75 @@
+// tlsca.go
+package rotation
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// tlsCaSecretPrefix marks a 'tls_ca' value referencing another secret instead of holding the PEM inline.
+const tlsCaSecretPrefix = "secretsmanager:"
+
+// ResolveTlsCa
+//
+// Get the PEM of the 'tls_ca' secret field
+//
+//	The value is either the PEM encoded CA certificates, or 'secretsmanager:<secret arn or name>' to read them from
+//	the AWSCURRENT version of another secret, whose secret string is the PEM. The referenced secret must be listed
+//	in TLS_CA_SECRETS, the secrets the module grants the function read access to.
+//
+//	Args:
+//	    value (string): The 'tls_ca' field value
+//
+//	Returns:
+//	    string: The PEM encoded CA certificates
+//	    error: Error if the referenced secret is not listed in TLS_CA_SECRETS or could not be read
+func ResolveTlsCa(ctx context.Context, value string) (string, error) {
+	reference, ok := strings.CutPrefix(strings.TrimSpace(value), tlsCaSecretPrefix)
+	if !ok {
+		return value, nil
+	}
+	if !slices.Contains(settings.TlsCaSecrets, reference) {
+		return "", fmt.Errorf("tls_ca references %v, not listed in TLS_CA_SECRETS", reference)
+	}
+	smClient := secretsmanager.NewFromConfig(cfg)
+	caPem, err := GetSecretString(ctx, smClient, RotationConfig{
+		arn:   &reference,
+		stage: "AWSCURRENT",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read tls_ca from %v: %w", reference, err)
+	}
+	return caPem, nil
+}
+
+// GetTlsConfig
+//
+// Get the TLS configuration trusting the 'tls_ca' of the secret
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary
+//
+//	Returns:
+//	    *tls.Config: The TLS configuration, nil when the secret has no 'tls_ca' so the driver defaults apply
+//	    error: Error if the CA could not be resolved or holds no certificate
+func GetTlsConfig(ctx context.Context, secretDict map[string]string) (*tls.Config, error) {
+	value := strings.TrimSpace(secretDict["tls_ca"])
+	if value == "" {
+		return nil, nil
+	}
+	caPem, err := ResolveTlsCa(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caPem)) {
+		return nil, fmt.Errorf("tls_ca holds no PEM certificate")
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
@@ -0,0 +1,98 @@
+// tlsca_test.go
+package rotation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCaPem is a self-signed CA certificate generated for the test.
+func testCaPem(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rotation test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// getSecretValueResponse is the GetSecretValue answer holding the secret string.
+func getSecretValueResponse(t *testing.T, secretString string) awsResponse {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"ARN":           "arn:aws:secretsmanager:us-east-1:123456789012:secret:mongodb-ca",
+		"SecretString":  secretString,
+		"VersionId":     testCurrentToken,
+		"VersionStages": []string{"AWSCURRENT"},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+	return awsResponse{body: string(body)}
+}
+
+func TestGetTlsConfig(t *testing.T) {
+	caPem := testCaPem(t)
+	notFound := awsResponse{status: http.StatusBadRequest, body: `{"__type": "ResourceNotFoundException", "message": "secret not found"}`}
+	tests := []struct {
+		name      string
+		tlsCa     string
+		response  awsResponse
+		wantCa    bool
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "no tls_ca"},
+		{name: "inline PEM", tlsCa: caPem, wantCa: true},
+		{name: "inline garbage", tlsCa: "not a certificate", wantErr: true},
+		{name: "secret reference", tlsCa: "secretsmanager:mongodb-ca", response: getSecretValueResponse(t, caPem), wantCa: true, wantCalls: 1},
+		{name: "referenced secret without PEM", tlsCa: "secretsmanager:mongodb-ca", response: getSecretValueResponse(t, "not a certificate"), wantErr: true, wantCalls: 1},
+		{name: "missing referenced secret", tlsCa: " secretsmanager:mongodb-ca ", response: notFound, wantErr: true, wantCalls: 1},
+		{name: "unlisted referenced secret", tlsCa: "secretsmanager:other-ca", response: getSecretValueResponse(t, caPem), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"TLS_CA_SECRETS": "mongodb-ca"})
+			transport := fakeAWS(t, map[string]awsResponse{"GetSecretValue": tt.response})
+
+			tlsConfig, err := GetTlsConfig(context.Background(), map[string]string{"tls_ca": tt.tlsCa})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetTlsConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := tlsConfig != nil && tlsConfig.RootCAs != nil; got != tt.wantCa {
+				t.Errorf("GetTlsConfig() has a CA pool = %v, want %v", got, tt.wantCa)
+			}
+			calls := transport.Calls()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("AWS calls = %v, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantCalls > 0 && !strings.Contains(calls[0].body, `"SecretId":"mongodb-ca"`) {
+				t.Errorf("GetSecretValue body = %s, want the referenced secret", calls[0].body)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// tracing.go
+package rotation
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "mongodb-pwd-rotation-lambda"
+
+var (
+	tracingOnce     sync.Once
+	tracingProvider *sdktrace.TracerProvider
+)
+
+// TracingEnabled
+//
+// Check if OpenTelemetry traces are exported, that is when OTEL_EXPORTER_OTLP_ENDPOINT environment variable is set
+func TracingEnabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != ""
+}
+
+// InitTracing
+//
+// Initialize the OpenTelemetry tracer provider once per Lambda environment
+//
+//	When OTEL_EXPORTER_OTLP_ENDPOINT environment variable is set the spans are exported with OTLP over HTTP (the
+//	standard OTEL_EXPORTER_OTLP_* variables apply) and the Secrets Manager calls get a span each. Otherwise the
+//	global no-op tracer is kept.
+func InitTracing() {
+	if !TracingEnabled() {
+		return
+	}
+	tracingOnce.Do(func() {
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			log.Printf("InitTracing: Failed to create OTLP exporter, tracing disabled: %v", err)
+			return
+		}
+		tracingProvider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(resource.NewSchemaless(
+				semconv.ServiceName(os.Getenv("AWS_LAMBDA_FUNCTION_NAME")),
+			)),
+		)
+		otel.SetTracerProvider(tracingProvider)
+		cfg.APIOptions = append(cfg.APIOptions, AddTracingMiddleware)
+	})
+}
+
+// FlushTracing
+//
+// Export the pending spans before the invocation returns, as the Lambda environment may be frozen afterwards
+func FlushTracing(ctx context.Context) {
+	if tracingProvider == nil {
+		return
+	}
+	if err := tracingProvider.ForceFlush(ctx); err != nil {
+		log.Printf("FlushTracing: Failed to flush spans: %v", err)
+	}
+}
+
+// StartSpan
+//
+// Start a span with the rotation tracer
+//
+//	Args:
+//	    name (string): The span name
+//
+//	    attributes (...attribute.KeyValue): The span attributes
+//
+//	Returns:
+//	    context.Context: The context holding the span
+//	    trace.Span: The span, to be ended by the caller
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// EndSpan
+//
+// Record the error, if any, on the span and end it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// SetSpanEngine
+//
+// Add the secret engine to the current span
+func SetSpanEngine(ctx context.Context, secretDict map[string]string) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("rotation.engine", GetEngine(secretDict)))
+}
+
+// AddTracingMiddleware
+//
+// AWS SDK middleware starting a client span for every AWS API call
+func AddTracingMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OTelSpan", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		ctx, span := otel.Tracer(tracerName).Start(ctx, awsmiddleware.GetServiceID(ctx)+"."+awsmiddleware.GetOperationName(ctx),
+			trace.WithSpanKind(trace.SpanKindClient))
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		EndSpan(span, err)
+		return out, metadata, err
+	}), middleware.After)
+}
+
+// tracingTransport starts a client span for every Atlas API call
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip
+//
+// Send the request inside a client span
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := otel.Tracer(tracerName).Start(req.Context(), "Atlas "+req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.request.method", req.Method)))
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	EndSpan(span, err)
+	return resp, err
+}
+
+// UseTracing
+//
+// Atlas client modifier starting a span for every API call, applied after the authentication modifier
+//
+//	Returns:
+//	    admin.ClientModifier: The client modifier
+func UseTracing() admin.ClientModifier {
+	return func(c *admin.Configuration) error {
+		if c.HTTPClient == nil {
+			c.HTTPClient = &http.Client{}
+		}
+		base := c.HTTPClient.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		c.HTTPClient.Transport = &tracingTransport{base: base}
+		return nil
+	}
+}
@@ -0,0 +1,179 @@
+// tracing_test.go
+package rotation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/smithy-go/middleware"
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"mongodb-pwd-rotation-lambda/testutil"
+)
+
+// recordSpans sets an in-memory tracer provider as the global one until the test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// spanAttribute returns the value of the span attribute with the given key.
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) (string, bool) {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.Emit(), true
+		}
+	}
+	return "", false
+}
+
+func TestStepSpans(t *testing.T) {
+	tests := []struct {
+		step    string
+		fail    bool
+		wantErr bool
+	}{
+		{step: "createSecret"},
+		{step: "setSecret", fail: true, wantErr: true},
+		{step: "testSecret", fail: true, wantErr: true},
+		{step: "finishSecret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			unsetEnv(t, "ROTATION_LOCK", "ENABLED_STEPS", "SKIP_TEST_SECRET", "FINISH_DELAY_SECONDS", "PRECHECK_CURRENT", "DEFAULT_ENGINE")
+			recorder := recordSpans(t)
+			smClient := testutil.NewFakeSecretsManager()
+			smClient.AddSecret(testSecretArn, testCurrentToken, atlasSecret(t, "old-password", nil))
+			if tt.step == "createSecret" {
+				smClient.StageVersion(testSecretArn, testPendingToken, "AWSPENDING")
+			} else {
+				smClient.PutVersion(testSecretArn, testPendingToken, atlasSecret(t, "new-password", nil), "AWSPENDING")
+			}
+			if tt.fail {
+				smClient.FailNext("GetSecretValue", errors.New("injected failure"))
+			}
+
+			ctx, span := StartSpan(context.Background(), "rotation."+tt.step,
+				attribute.String("rotation.step", tt.step),
+				attribute.String("rotation.secret_id", testSecretArn))
+			err := RunRotationStep(ctx, smClient, nil, describeTestSecret(t, smClient), tt.step, testSecretArn, testPendingToken)
+			SetSpanEngine(ctx, map[string]string{"engine": "MongoDBAtlas"})
+			EndSpan(span, err)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunRotationStep() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			spans := recorder.Ended()
+			if len(spans) != 1 || spans[0].Name() != "rotation."+tt.step {
+				t.Fatalf("ended spans = %v, want a single rotation.%v span", spans, tt.step)
+			}
+			for key, want := range map[string]string{"rotation.step": tt.step, "rotation.secret_id": testSecretArn, "rotation.engine": "mongodbatlas"} {
+				if got, _ := spanAttribute(spans[0], key); got != want {
+					t.Errorf("span attribute %v = %q, want %q", key, got, want)
+				}
+			}
+			if got := spans[0].Status().Code == codes.Error; got != tt.wantErr {
+				t.Errorf("span error status = %v, want %v", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUseTracing(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantStatus string
+		wantErr    bool
+	}{
+		{name: "success", wantStatus: "200"},
+		{name: "server error", status: http.StatusInternalServerError, wantStatus: "500", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := recordSpans(t)
+			atlas := testutil.NewFakeAtlas()
+			atlas.AddProject(testProjectId, "payments")
+			if tt.status != 0 {
+				atlas.FailNext("GetProject", tt.status)
+			}
+			mongoAdmin, err := admin.NewClient(
+				admin.UseBaseURL("https://atlas.fake.invalid"),
+				admin.UseHTTPClient(&http.Client{Transport: atlas}),
+				UseTracing(),
+			)
+			if err != nil {
+				t.Fatalf("failed to create Atlas client: %v", err)
+			}
+
+			ctx, parent := StartSpan(context.Background(), "rotation.setSecret")
+			_, _, err = mongoAdmin.ProjectsApi.GetProject(ctx, testProjectId).Execute()
+			parent.End()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetProject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			spans := recorder.Ended()
+			if len(spans) != 2 {
+				t.Fatalf("ended spans = %d, want the Atlas call and its parent", len(spans))
+			}
+			call := spans[0]
+			if !strings.HasPrefix(call.Name(), "Atlas GET /api/atlas/v2/groups/"+testProjectId) {
+				t.Errorf("Atlas span name = %q", call.Name())
+			}
+			if call.Parent().SpanID() != spans[1].SpanContext().SpanID() {
+				t.Errorf("Atlas span is not a child of the step span")
+			}
+			if got, _ := spanAttribute(call, "http.response.status_code"); got != tt.wantStatus {
+				t.Errorf("Atlas span status code = %q, want %q", got, tt.wantStatus)
+			}
+			if got := call.Status().Code == codes.Error; got != tt.wantErr {
+				t.Errorf("Atlas span error status = %v, want %v", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+// secretsManagerTransport answers every Secrets Manager request with an empty JSON document.
+type secretsManagerTransport struct{}
+
+func (secretsManagerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+func TestAddTracingMiddleware(t *testing.T) {
+	recorder := recordSpans(t)
+	smClient := secretsmanager.New(secretsmanager.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		HTTPClient:  &http.Client{Transport: secretsManagerTransport{}},
+		APIOptions:  []func(*middleware.Stack) error{AddTracingMiddleware},
+	})
+	if _, err := smClient.DescribeSecret(context.Background(), &secretsmanager.DescribeSecretInput{SecretId: aws.String(testSecretArn)}); err != nil {
+		t.Fatalf("DescribeSecret() error = %v", err)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "Secrets Manager.DescribeSecret" {
+		t.Fatalf("ended spans = %v, want a single Secrets Manager.DescribeSecret span", spans)
+	}
+}
@@ -0,0 +1,89 @@
+// uri.go
+package rotation
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ExpandUriSecret
+//
+// Derive the structured fields of a secret holding only a 'uri' connection string
+//
+//	Secrets with a 'uri' field and no 'username' field get 'username', 'password' and 'host' from the URI, and the
+//	URI as 'connection_string' when no connection string is present, so the rotation steps can work with them as
+//	with any other secret. The derived fields are removed again by CollapseUriSecret before the secret is stored.
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary, updated in place
+//
+//	Returns:
+//	    []string: The derived fields, empty if the secret is not a single URI secret
+//	    error: Error if the URI has no valid credentials
+func ExpandUriSecret(secretDict map[string]string) ([]string, error) {
+	uri := strings.TrimSpace(secretDict["uri"])
+	if _, hasUsername := secretDict["username"]; uri == "" || hasUsername {
+		return nil, nil
+	}
+	_, userinfo, hosts, _, err := SplitConnectionString(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri: %w", err)
+	}
+	encodedUsername, encodedPassword, ok := strings.Cut(userinfo, ":")
+	if !ok || encodedUsername == "" {
+		return nil, fmt.Errorf("invalid uri: no username and password embedded")
+	}
+	username, err := url.PathUnescape(encodedUsername)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri username: %w", err)
+	}
+	password, err := url.PathUnescape(encodedPassword)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri password: %w", err)
+	}
+	derived := []string{"username", "password"}
+	secretDict["username"] = username
+	secretDict["password"] = password
+	if _, ok = secretDict["host"]; !ok {
+		secretDict["host"] = hosts
+		derived = append(derived, "host")
+	}
+	hasConnectionString := false
+	for _, key := range connectionStringKeys {
+		if _, ok = secretDict[key]; ok {
+			hasConnectionString = true
+		}
+	}
+	if !hasConnectionString {
+		secretDict["connection_string"] = uri
+		derived = append(derived, "connection_string")
+	}
+	return derived, nil
+}
+
+// CollapseUriSecret
+//
+// Rebuild the 'uri' of a single URI secret and remove the fields derived by ExpandUriSecret
+//
+//	Args:
+//	    secretDict (map[string]string): The secret dictionary, updated in place
+//
+//	    derived ([]string): The fields derived by ExpandUriSecret, nothing is done when empty
+//
+//	Returns:
+//	    error: Error if the URI could not be rebuilt
+func CollapseUriSecret(secretDict map[string]string, derived []string) error {
+	if len(derived) == 0 {
+		return nil
+	}
+	uri, err := ReplaceConnectionStringCredentials(secretDict["uri"], url.PathEscape(secretDict["username"]), secretDict["password"])
+	if err != nil {
+		return fmt.Errorf("invalid uri: %w", err)
+	}
+	secretDict["uri"] = uri
+	for _, key := range derived {
+		delete(secretDict, key)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// warmup.go
+package rotation
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+var (
+	warmupOnce  sync.Once
+	warmupDone  = make(chan struct{})
+	warmupUsed  atomic.Bool
+	warmupAdmin *admin.APIClient
+	warmupErr   error
+)
+
+// Client initializations of the warmup, variables so tests don't reach AWS and Atlas.
+var (
+	initAtlasClient     = InitMongoDBAtlas
+	retrieveCredentials = func(ctx context.Context) error {
+		_, err := cfg.Credentials.Retrieve(ctx)
+		return err
+	}
+)
+
+// StartWarmup
+//
+// Initialize the clients in the background on cold start
+//
+//	The Atlas API client is initialized while the AWS credentials are resolved, concurrently and only once per
+//	Lambda environment, so the first rotation step doesn't pay for both in sequence. Enabled with WARMUP_CLIENTS
+//	environment variable.
+func StartWarmup() {
+	if !settings.WarmupClients {
+		return
+	}
+	warmupOnce.Do(func() {
+		go func() {
+			defer close(warmupDone)
+			start := time.Now()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := retrieveCredentials(context.Background()); err != nil {
+					log.Printf("Warmup: Failed to retrieve AWS credentials: %v", err)
+				}
+			}()
+			warmupAdmin, warmupErr = initAtlasClient()
+			wg.Wait()
+			log.Printf("Warmup: Clients initialized in %v", time.Since(start))
+		}()
+	})
+}
+
+// GetAtlasClient
+//
+// Get the MongoDB Atlas API client for the invocation
+//
+//	With WARMUP_CLIENTS enabled the first invocation uses the client initialized by StartWarmup, the following ones
+//	initialize a fresh client as usual so a rotated Atlas API key is always picked up.
+//
+//	Returns:
+//	    admin.APIClient: MongoDB Atlas API client
+//	    error: Error if the MongoDB Atlas API client could not be initialized
+func GetAtlasClient() (*admin.APIClient, error) {
+	if !settings.WarmupClients {
+		return initAtlasClient()
+	}
+	start := time.Now()
+	StartWarmup()
+	<-warmupDone
+	if warmupUsed.CompareAndSwap(false, true) {
+		log.Printf("GetAtlasClient: Cold start, waited %v for warmup", time.Since(start))
+		return warmupAdmin, warmupErr
+	}
+	mongoAdmin, err := initAtlasClient()
+	log.Printf("GetAtlasClient: Warm start, client initialized in %v", time.Since(start))
+	return mongoAdmin, err
+}
@@ -0,0 +1,106 @@
+// warmup_test.go
+package rotation
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+// fakeWarmup resets the warmup state and records the client initializations, each one returning a new client.
+func fakeWarmup(t *testing.T) (*[]*admin.APIClient, *atomic.Int32) {
+	t.Helper()
+	initAtlas, retrieve := initAtlasClient, retrieveCredentials
+	var mu sync.Mutex
+	var clients []*admin.APIClient
+	var retrieveCalls atomic.Int32
+	initAtlasClient = func() (*admin.APIClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		client := &admin.APIClient{}
+		clients = append(clients, client)
+		return client, nil
+	}
+	retrieveCredentials = func(context.Context) error {
+		retrieveCalls.Add(1)
+		return nil
+	}
+	resetWarmup := func() {
+		warmupOnce = sync.Once{}
+		warmupDone = make(chan struct{})
+		warmupUsed.Store(false)
+		warmupAdmin, warmupErr = nil, nil
+	}
+	resetWarmup()
+	t.Cleanup(func() {
+		initAtlasClient, retrieveCredentials = initAtlas, retrieve
+		resetWarmup()
+	})
+	return &clients, &retrieveCalls
+}
+
+func TestGetAtlasClientWarmup(t *testing.T) {
+	tests := []struct {
+		name          string
+		warmup        string
+		wantRetrieves int32
+	}{
+		{name: "disabled", warmup: "false"},
+		{name: "enabled", warmup: "true", wantRetrieves: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients, retrieveCalls := fakeWarmup(t)
+			setEnv(t, map[string]string{"WARMUP_CLIENTS": tt.warmup})
+
+			StartWarmup()
+			StartWarmup()
+			var got []*admin.APIClient
+			for range 3 {
+				mongoAdmin, err := GetAtlasClient()
+				if err != nil {
+					t.Fatalf("GetAtlasClient() error = %v", err)
+				}
+				got = append(got, mongoAdmin)
+			}
+			if !slices.Equal(got, *clients) {
+				t.Errorf("GetAtlasClient() clients = %v, want one new client per call %v", got, *clients)
+			}
+			if got := retrieveCalls.Load(); got != tt.wantRetrieves {
+				t.Errorf("AWS credentials retrieved %d times, want %d", got, tt.wantRetrieves)
+			}
+		})
+	}
+}
+
+func TestGetAtlasClientWarmupConcurrent(t *testing.T) {
+	clients, retrieveCalls := fakeWarmup(t)
+	setEnv(t, map[string]string{"WARMUP_CLIENTS": "true"})
+
+	var wg sync.WaitGroup
+	var coldStarts atomic.Int32
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			StartWarmup()
+			if mongoAdmin, err := GetAtlasClient(); err == nil && mongoAdmin == warmupAdmin {
+				coldStarts.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := retrieveCalls.Load(); got != 1 {
+		t.Errorf("warmup ran %d times, want once", got)
+	}
+	if got := coldStarts.Load(); got != 1 {
+		t.Errorf("warmup client returned %d times, want once", got)
+	}
+	if got := len(*clients); got != 8 {
+		t.Errorf("Atlas client initialized %d times, want 8", got)
+	}
+}
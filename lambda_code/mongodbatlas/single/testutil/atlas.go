@@ -0,0 +1,420 @@
+// atlas.go
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/atlas-sdk/v20250312001/admin"
+)
+
+const fakeBaseURL = "https://atlas.fake.invalid"
+
+// FakeAtlas
+//
+// In-memory fake of the Atlas Admin API subset used by the rotation
+//
+//	The fake is an http.RoundTripper serving the projects (list, list by organization and get), clusters (list for
+//	all projects, list, get and status) and database users (create, get, update and delete) endpoints from maps, so
+//	the real SDK client built by Client() can be used without network. Errors can be injected per operation with
+//	FailNext.
+type FakeAtlas struct {
+	mu       sync.Mutex
+	projects map[string]admin.Group
+	clusters map[string]admin.ClusterDescription20240805
+	statuses map[string][]string
+	users    map[string]admin.CloudDatabaseUser
+	failures map[string][]int
+}
+
+// NewFakeAtlas
+//
+// Create an empty fake Atlas
+func NewFakeAtlas() *FakeAtlas {
+	return &FakeAtlas{
+		projects: map[string]admin.Group{},
+		clusters: map[string]admin.ClusterDescription20240805{},
+		statuses: map[string][]string{},
+		users:    map[string]admin.CloudDatabaseUser{},
+		failures: map[string][]int{},
+	}
+}
+
+// Client
+//
+// Create an Atlas API client served by the fake
+//
+//	Returns:
+//	    admin.APIClient: MongoDB Atlas API client
+//	    error: Error if the client could not be created
+func (f *FakeAtlas) Client() (*admin.APIClient, error) {
+	return admin.NewClient(
+		admin.UseBaseURL(fakeBaseURL),
+		admin.UseHTTPClient(&http.Client{Transport: f}),
+	)
+}
+
+// NewFakeAtlasClient
+//
+// Create a fake Atlas filled by the seed functions, in order, and an Atlas API client served by it
+//
+//	The test fails when the client could not be created.
+//
+//	Returns:
+//	    FakeAtlas: The seeded fake
+//	    admin.APIClient: MongoDB Atlas API client
+func NewFakeAtlasClient(t testing.TB, seeds ...func(*FakeAtlas)) (*FakeAtlas, *admin.APIClient) {
+	t.Helper()
+	f := NewFakeAtlas()
+	for _, seed := range seeds {
+		seed(f)
+	}
+	mongoAdmin, err := f.Client()
+	if err != nil {
+		t.Fatalf("failed to create fake Atlas client: %v", err)
+	}
+	return f, mongoAdmin
+}
+
+// WithProjectUsers
+//
+// Seed a project and its database users, users without a GroupId are added to the project
+func WithProjectUsers(projectId string, name string, users ...admin.CloudDatabaseUser) func(*FakeAtlas) {
+	return func(f *FakeAtlas) {
+		f.AddProject(projectId, name)
+		for _, user := range users {
+			if user.GroupId == "" {
+				user.GroupId = projectId
+			}
+			f.AddUser(user)
+		}
+	}
+}
+
+// AddProject
+//
+// Add a project to the fake
+func (f *FakeAtlas) AddProject(projectId string, name string) {
+	f.AddOrgProject("", projectId, name)
+}
+
+// AddOrgProject
+//
+// Add a project of an organization to the fake
+func (f *FakeAtlas) AddOrgProject(orgId string, projectId string, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.projects[projectId] = admin.Group{Id: admin.PtrString(projectId), Name: name, OrgId: orgId}
+}
+
+// AddCluster
+//
+// Add a cluster to a project of the fake, the cluster Name identifies it
+func (f *FakeAtlas) AddCluster(projectId string, cluster admin.ClusterDescription20240805) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cluster.GroupId = admin.PtrString(projectId)
+	f.clusters[clusterKey(projectId, cluster.GetName())] = cluster
+}
+
+// SetClusterChangeStatus
+//
+// Set the user change statuses returned by the status of a cluster, one per call
+//
+//	The last status is returned by every following call, a cluster without statuses reports APPLIED.
+func (f *FakeAtlas) SetClusterChangeStatus(projectId string, clusterName string, statuses ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statuses[clusterKey(projectId, clusterName)] = statuses
+}
+
+// AddUser
+//
+// Add a database user to the fake, the user GroupId, DatabaseName and Username identify it
+func (f *FakeAtlas) AddUser(user admin.CloudDatabaseUser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[userKey(user.GroupId, user.DatabaseName, user.Username)] = user
+}
+
+// User
+//
+// Get a database user stored in the fake
+//
+//	Returns:
+//	    admin.CloudDatabaseUser: The user, including the last password set
+//	    bool: False if the user does not exist
+func (f *FakeAtlas) User(projectId string, databaseName string, username string) (admin.CloudDatabaseUser, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userKey(projectId, databaseName, username)]
+	return user, ok
+}
+
+// Users
+//
+// Get every database user stored in the fake, ordered by project, auth database and username
+func (f *FakeAtlas) Users() []admin.CloudDatabaseUser {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var users []admin.CloudDatabaseUser
+	for _, key := range slices.Sorted(maps.Keys(f.users)) {
+		users = append(users, f.users[key])
+	}
+	return users
+}
+
+// FailNext
+//
+// Make the next call of an operation fail with the given HTTP status
+//
+//	Operations are ListProjects, ListOrganizationProjects, GetProject, ListClustersForAllProjects, ListClusters,
+//	GetCluster, GetClusterStatus, GetDatabaseUser, CreateDatabaseUser, UpdateDatabaseUser and DeleteDatabaseUser.
+//	Every call queues one failure.
+func (f *FakeAtlas) FailNext(operation string, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[operation] = append(f.failures[operation], status)
+}
+
+// SucceedNext
+//
+// Let the next call of an operation succeed before the failures queued after it with FailNext
+func (f *FakeAtlas) SucceedNext(operation string) {
+	f.FailNext(operation, 0)
+}
+
+// RoundTrip
+//
+// Serve an Atlas API request from the fake state
+func (f *FakeAtlas) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if resp, ok := f.listRoundTrip(req); ok {
+		return resp, nil
+	}
+	path := strings.Split(strings.TrimPrefix(req.URL.EscapedPath(), "/api/atlas/v2/groups/"), "/")
+	for i, segment := range path {
+		path[i], _ = url.PathUnescape(segment)
+	}
+	switch {
+	case len(path) == 1 && req.Method == http.MethodGet:
+		if resp := f.failure(req, "GetProject"); resp != nil {
+			return resp, nil
+		}
+		project, ok := f.projects[path[0]]
+		if !ok {
+			return errorResponse(req, http.StatusNotFound, "GROUP_NOT_FOUND"), nil
+		}
+		return jsonResponse(req, http.StatusOK, project)
+	case len(path) == 3 && path[1] == "clusters" && req.Method == http.MethodGet:
+		if resp := f.failure(req, "GetCluster"); resp != nil {
+			return resp, nil
+		}
+		cluster, ok := f.clusters[clusterKey(path[0], path[2])]
+		if !ok {
+			return errorResponse(req, http.StatusNotFound, "CLUSTER_NOT_FOUND"), nil
+		}
+		return jsonResponse(req, http.StatusOK, cluster)
+	case len(path) == 4 && path[1] == "clusters" && path[3] == "status" && req.Method == http.MethodGet:
+		if resp := f.failure(req, "GetClusterStatus"); resp != nil {
+			return resp, nil
+		}
+		key := clusterKey(path[0], path[2])
+		if _, ok := f.clusters[key]; !ok {
+			return errorResponse(req, http.StatusNotFound, "CLUSTER_NOT_FOUND"), nil
+		}
+		status := "APPLIED"
+		if statuses := f.statuses[key]; len(statuses) > 0 {
+			status = statuses[0]
+			if len(statuses) > 1 {
+				f.statuses[key] = statuses[1:]
+			}
+		}
+		return jsonResponse(req, http.StatusOK, admin.ClusterStatus{ChangeStatus: admin.PtrString(status)})
+	case len(path) == 2 && path[1] == "databaseUsers" && req.Method == http.MethodPost:
+		if resp := f.failure(req, "CreateDatabaseUser"); resp != nil {
+			return resp, nil
+		}
+		var user admin.CloudDatabaseUser
+		if err := decodeBody(req, &user); err != nil {
+			return nil, err
+		}
+		user.GroupId = path[0]
+		key := userKey(user.GroupId, user.DatabaseName, user.Username)
+		if _, ok := f.users[key]; ok {
+			return errorResponse(req, http.StatusConflict, "USER_ALREADY_EXISTS"), nil
+		}
+		f.users[key] = user
+		return jsonResponse(req, http.StatusCreated, user)
+	case len(path) == 4 && path[1] == "databaseUsers":
+		key := userKey(path[0], path[2], path[3])
+		user, ok := f.users[key]
+		switch req.Method {
+		case http.MethodGet:
+			if resp := f.failure(req, "GetDatabaseUser"); resp != nil {
+				return resp, nil
+			}
+			if !ok {
+				return errorResponse(req, http.StatusNotFound, "USERNAME_NOT_FOUND"), nil
+			}
+			return jsonResponse(req, http.StatusOK, user)
+		case http.MethodPatch:
+			if resp := f.failure(req, "UpdateDatabaseUser"); resp != nil {
+				return resp, nil
+			}
+			if !ok {
+				return errorResponse(req, http.StatusNotFound, "USERNAME_NOT_FOUND"), nil
+			}
+			if err := decodeBody(req, &user); err != nil {
+				return nil, err
+			}
+			f.users[key] = user
+			return jsonResponse(req, http.StatusOK, user)
+		case http.MethodDelete:
+			if resp := f.failure(req, "DeleteDatabaseUser"); resp != nil {
+				return resp, nil
+			}
+			if !ok {
+				return errorResponse(req, http.StatusNotFound, "USERNAME_NOT_FOUND"), nil
+			}
+			delete(f.users, key)
+			return &http.Response{StatusCode: http.StatusNoContent, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+		}
+	}
+	return errorResponse(req, http.StatusNotImplemented, "NOT_IMPLEMENTED_BY_FAKE"), nil
+}
+
+// listRoundTrip serves the list endpoints, false when the request is not one of them. All the results are returned
+// in the first page.
+func (f *FakeAtlas) listRoundTrip(req *http.Request) (*http.Response, bool) {
+	if req.Method != http.MethodGet {
+		return nil, false
+	}
+	path := strings.TrimPrefix(req.URL.EscapedPath(), "/api/atlas/v2/")
+	switch {
+	case path == "groups":
+		if resp := f.failure(req, "ListProjects"); resp != nil {
+			return resp, true
+		}
+		return f.projectsPage(req, func(admin.Group) bool { return true }), true
+	case strings.HasPrefix(path, "orgs/") && strings.HasSuffix(path, "/groups"):
+		if resp := f.failure(req, "ListOrganizationProjects"); resp != nil {
+			return resp, true
+		}
+		orgId, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "orgs/"), "/groups"))
+		name := req.URL.Query().Get("name")
+		return f.projectsPage(req, func(project admin.Group) bool {
+			return project.OrgId == orgId && (name == "" || project.Name == name)
+		}), true
+	case path == "clusters":
+		if resp := f.failure(req, "ListClustersForAllProjects"); resp != nil {
+			return resp, true
+		}
+		var results []admin.OrgGroup
+		for _, project := range f.projects {
+			var clusters []admin.CloudCluster
+			for _, cluster := range f.clusters {
+				if cluster.GetGroupId() == project.GetId() {
+					clusters = append(clusters, admin.CloudCluster{Name: cluster.Name})
+				}
+			}
+			results = append(results, admin.OrgGroup{
+				GroupId:   project.Id,
+				GroupName: admin.PtrString(project.Name),
+				OrgId:     admin.PtrString(project.OrgId),
+				Clusters:  &clusters,
+			})
+		}
+		resp, _ := jsonResponse(req, http.StatusOK, admin.PaginatedOrgGroup{Results: &results})
+		return resp, true
+	case strings.HasPrefix(path, "groups/") && strings.HasSuffix(path, "/clusters"):
+		if resp := f.failure(req, "ListClusters"); resp != nil {
+			return resp, true
+		}
+		projectId, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "groups/"), "/clusters"))
+		var results []admin.ClusterDescription20240805
+		for _, cluster := range f.clusters {
+			if cluster.GetGroupId() == projectId {
+				results = append(results, cluster)
+			}
+		}
+		resp, _ := jsonResponse(req, http.StatusOK, admin.PaginatedClusterDescription20240805{Results: &results})
+		return resp, true
+	}
+	return nil, false
+}
+
+// projectsPage is the page of the projects matching the filter.
+func (f *FakeAtlas) projectsPage(req *http.Request, filter func(admin.Group) bool) *http.Response {
+	var results []admin.Group
+	for _, project := range f.projects {
+		if filter(project) {
+			results = append(results, project)
+		}
+	}
+	resp, _ := jsonResponse(req, http.StatusOK, admin.PaginatedAtlasGroup{Results: &results})
+	return resp
+}
+
+// failure pops the next queued failure of the operation, nil when there is none.
+func (f *FakeAtlas) failure(req *http.Request, operation string) *http.Response {
+	queued := f.failures[operation]
+	if len(queued) == 0 {
+		return nil
+	}
+	f.failures[operation] = queued[1:]
+	if queued[0] == 0 {
+		return nil
+	}
+	return errorResponse(req, queued[0], "INJECTED_FAILURE")
+}
+
+func clusterKey(projectId string, clusterName string) string {
+	return projectId + "/" + clusterName
+}
+
+func userKey(projectId string, databaseName string, username string) string {
+	return projectId + "/" + databaseName + "/" + username
+}
+
+func decodeBody(req *http.Request, v any) error {
+	if req.Body == nil {
+		return fmt.Errorf("fake atlas: %v %v has no body", req.Method, req.URL.Path)
+	}
+	defer req.Body.Close()
+	return json.NewDecoder(req.Body).Decode(v)
+}
+
+func jsonResponse(req *http.Request, status int, v any) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func errorResponse(req *http.Request, status int, errorCode string) *http.Response {
+	resp, _ := jsonResponse(req, status, admin.ApiError{
+		Error:     status,
+		ErrorCode: errorCode,
+		Detail:    admin.PtrString(errorCode),
+		Reason:    admin.PtrString(http.StatusText(status)),
+	})
+	return resp
+}
@@ -0,0 +1,400 @@
+// secretsmanager.go
+package testutil
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// fakeSecret is a secret of the fake, its versions by id with their staging labels and creation time
+type fakeSecret struct {
+	rotationEnabled *bool
+	values          map[string]string
+	stages          map[string][]string
+	created         map[string]time.Time
+	tags            map[string]string
+}
+
+// FakeSecretsManager
+//
+// In-memory fake of the Secrets Manager operations used by the rotation
+//
+//	Secrets are identified by the SecretId given to AddSecret, ARNs are not parsed. Errors can be injected per
+//	operation with FailNext.
+type FakeSecretsManager struct {
+	mu       sync.Mutex
+	secrets  map[string]*fakeSecret
+	failures map[string][]error
+}
+
+// NewFakeSecretsManager
+//
+// Create an empty fake Secrets Manager
+func NewFakeSecretsManager() *FakeSecretsManager {
+	return &FakeSecretsManager{
+		secrets:  map[string]*fakeSecret{},
+		failures: map[string][]error{},
+	}
+}
+
+// AddSecret
+//
+// Add a secret with rotation enabled and a first version staged AWSCURRENT
+func (f *FakeSecretsManager) AddSecret(secretId string, versionId string, secretString string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.secrets[secretId] = &fakeSecret{
+		rotationEnabled: aws.Bool(true),
+		values:          map[string]string{versionId: secretString},
+		stages:          map[string][]string{versionId: {"AWSCURRENT"}},
+		created:         map[string]time.Time{versionId: time.Now()},
+		tags:            map[string]string{},
+	}
+}
+
+// PutVersion
+//
+// Add a version to a secret, moving the given staging labels to it
+func (f *FakeSecretsManager) PutVersion(secretId string, versionId string, secretString string, stages ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret := f.secrets[secretId]
+	secret.values[versionId] = secretString
+	secret.created[versionId] = time.Now()
+	for _, stage := range stages {
+		secret.moveStage(stage, versionId)
+	}
+}
+
+// StageVersion
+//
+// Stage a version without a value, as rotate-secret does with the token of a new rotation before createSecret
+func (f *FakeSecretsManager) StageVersion(secretId string, versionId string, stages ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret := f.secrets[secretId]
+	for _, stage := range stages {
+		secret.moveStage(stage, versionId)
+	}
+}
+
+// SetCreatedDate
+//
+// Set the CreatedDate returned by GetSecretValue for a version of a secret
+func (f *FakeSecretsManager) SetCreatedDate(secretId string, versionId string, created time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.secrets[secretId].created[versionId] = created
+}
+
+// SetRotationEnabled
+//
+// Set the RotationEnabled reported by DescribeSecret, nil for a secret not reporting it
+func (f *FakeSecretsManager) SetRotationEnabled(secretId string, enabled *bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.secrets[secretId].rotationEnabled = enabled
+}
+
+// Version
+//
+// Get the secret string of the version holding the staging label
+//
+//	Returns:
+//	    string: The secret string
+//	    string: The version id
+//	    bool: False if no version holds the label
+func (f *FakeSecretsManager) Version(secretId string, stage string) (string, string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret, ok := f.secrets[secretId]
+	if !ok {
+		return "", "", false
+	}
+	versionId := secret.stagedVersion(stage)
+	if versionId == "" {
+		return "", "", false
+	}
+	return secret.values[versionId], versionId, true
+}
+
+// Tags
+//
+// Get a copy of the tags of a secret
+func (f *FakeSecretsManager) Tags(secretId string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tags := map[string]string{}
+	for key, value := range f.secrets[secretId].tags {
+		tags[key] = value
+	}
+	return tags
+}
+
+// FailNext
+//
+// Make the next call of an operation fail with the given error
+//
+//	Operations are the method names, e.g. PutSecretValue. Every call queues one failure.
+func (f *FakeSecretsManager) FailNext(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[operation] = append(f.failures[operation], err)
+}
+
+// DescribeSecret
+//
+// Describe a secret of the fake
+func (f *FakeSecretsManager) DescribeSecret(_ context.Context, params *secretsmanager.DescribeSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("DescribeSecret"); err != nil {
+		return nil, err
+	}
+	secret, err := f.secret(params.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	output := &secretsmanager.DescribeSecretOutput{
+		ARN:                params.SecretId,
+		Name:               params.SecretId,
+		RotationEnabled:    secret.rotationEnabled,
+		VersionIdsToStages: map[string][]string{},
+	}
+	for versionId, stages := range secret.stages {
+		if len(stages) > 0 {
+			output.VersionIdsToStages[versionId] = slices.Clone(stages)
+		}
+	}
+	for key, value := range secret.tags {
+		output.Tags = append(output.Tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return output, nil
+}
+
+// GetSecretValue
+//
+// Get a version of a secret of the fake by id, staging label or both
+func (f *FakeSecretsManager) GetSecretValue(_ context.Context, params *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("GetSecretValue"); err != nil {
+		return nil, err
+	}
+	secret, err := f.secret(params.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	stage := aws.ToString(params.VersionStage)
+	versionId := aws.ToString(params.VersionId)
+	if versionId == "" {
+		if stage == "" {
+			stage = "AWSCURRENT"
+		}
+		versionId = secret.stagedVersion(stage)
+	}
+	value, ok := secret.values[versionId]
+	if !ok || (stage != "" && !slices.Contains(secret.stages[versionId], stage)) {
+		return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("version %v %v of %v not found", versionId, stage, aws.ToString(params.SecretId)))}
+	}
+	return &secretsmanager.GetSecretValueOutput{
+		ARN:           params.SecretId,
+		Name:          params.SecretId,
+		CreatedDate:   aws.Time(secret.created[versionId]),
+		SecretString:  aws.String(value),
+		VersionId:     aws.String(versionId),
+		VersionStages: slices.Clone(secret.stages[versionId]),
+	}, nil
+}
+
+// PutSecretValue
+//
+// Add a version to a secret of the fake, staged AWSCURRENT unless other labels are given
+func (f *FakeSecretsManager) PutSecretValue(_ context.Context, params *secretsmanager.PutSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("PutSecretValue"); err != nil {
+		return nil, err
+	}
+	secret, err := f.secret(params.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	versionId := aws.ToString(params.ClientRequestToken)
+	if existing, ok := secret.values[versionId]; ok && existing != aws.ToString(params.SecretString) {
+		return nil, &types.ResourceExistsException{Message: aws.String(fmt.Sprintf("version %v already exists", versionId))}
+	}
+	if _, ok := secret.values[versionId]; !ok {
+		secret.created[versionId] = time.Now()
+	}
+	secret.values[versionId] = aws.ToString(params.SecretString)
+	stages := params.VersionStages
+	if len(stages) == 0 {
+		stages = []string{"AWSCURRENT"}
+	}
+	for _, stage := range stages {
+		secret.moveStage(stage, versionId)
+	}
+	return &secretsmanager.PutSecretValueOutput{
+		ARN:           params.SecretId,
+		Name:          params.SecretId,
+		VersionId:     aws.String(versionId),
+		VersionStages: slices.Clone(secret.stages[versionId]),
+	}, nil
+}
+
+// UpdateSecretVersionStage
+//
+// Move or remove a staging label of a secret of the fake
+func (f *FakeSecretsManager) UpdateSecretVersionStage(_ context.Context, params *secretsmanager.UpdateSecretVersionStageInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("UpdateSecretVersionStage"); err != nil {
+		return nil, err
+	}
+	secret, err := f.secret(params.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	stage := aws.ToString(params.VersionStage)
+	if removeFrom := aws.ToString(params.RemoveFromVersionId); removeFrom != "" {
+		if !slices.Contains(secret.stages[removeFrom], stage) {
+			return nil, &types.InvalidParameterException{Message: aws.String(fmt.Sprintf("%v is not attached to version %v", stage, removeFrom))}
+		}
+		secret.stages[removeFrom] = slices.DeleteFunc(secret.stages[removeFrom], func(s string) bool { return s == stage })
+	}
+	if moveTo := aws.ToString(params.MoveToVersionId); moveTo != "" {
+		if _, ok := secret.values[moveTo]; !ok {
+			return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("version %v not found", moveTo))}
+		}
+		secret.moveStage(stage, moveTo)
+		// Secrets Manager stages the version that lost AWSCURRENT as AWSPREVIOUS
+		if removeFrom := aws.ToString(params.RemoveFromVersionId); stage == "AWSCURRENT" && removeFrom != "" {
+			secret.moveStage("AWSPREVIOUS", removeFrom)
+		}
+	}
+	return &secretsmanager.UpdateSecretVersionStageOutput{ARN: params.SecretId, Name: params.SecretId}, nil
+}
+
+// GetRandomPassword
+//
+// Generate a random password honouring the length and the excluded characters
+func (f *FakeSecretsManager) GetRandomPassword(_ context.Context, params *secretsmanager.GetRandomPasswordInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetRandomPasswordOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("GetRandomPassword"); err != nil {
+		return nil, err
+	}
+	var alphabet []rune
+	for _, r := range "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$%&*+-.:=?@^_~" {
+		if !strings.ContainsRune(aws.ToString(params.ExcludeCharacters), r) {
+			alphabet = append(alphabet, r)
+		}
+	}
+	length := aws.ToInt64(params.PasswordLength)
+	if length == 0 {
+		length = 32
+	}
+	password := make([]rune, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return nil, err
+		}
+		password[i] = alphabet[n.Int64()]
+	}
+	return &secretsmanager.GetRandomPasswordOutput{RandomPassword: aws.String(string(password))}, nil
+}
+
+// TagResource
+//
+// Set tags of a secret of the fake
+func (f *FakeSecretsManager) TagResource(_ context.Context, params *secretsmanager.TagResourceInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("TagResource"); err != nil {
+		return nil, err
+	}
+	secret, err := f.secret(params.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range params.Tags {
+		secret.tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return &secretsmanager.TagResourceOutput{}, nil
+}
+
+// UntagResource
+//
+// Remove tags of a secret of the fake
+func (f *FakeSecretsManager) UntagResource(_ context.Context, params *secretsmanager.UntagResourceInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.UntagResourceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("UntagResource"); err != nil {
+		return nil, err
+	}
+	secret, err := f.secret(params.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range params.TagKeys {
+		delete(secret.tags, key)
+	}
+	return &secretsmanager.UntagResourceOutput{}, nil
+}
+
+// secret gets a secret of the fake, a ResourceNotFoundException when there is none.
+func (f *FakeSecretsManager) secret(secretId *string) (*fakeSecret, error) {
+	secret, ok := f.secrets[aws.ToString(secretId)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("secret %v not found", aws.ToString(secretId)))}
+	}
+	return secret, nil
+}
+
+// failure pops the next queued failure of the operation, nil when there is none.
+func (f *FakeSecretsManager) failure(operation string) error {
+	queued := f.failures[operation]
+	if len(queued) == 0 {
+		return nil
+	}
+	f.failures[operation] = queued[1:]
+	return queued[0]
+}
+
+// stagedVersion is the version holding the staging label, empty when there is none.
+func (s *fakeSecret) stagedVersion(stage string) string {
+	for versionId, stages := range s.stages {
+		if slices.Contains(stages, stage) {
+			return versionId
+		}
+	}
+	return ""
+}
+
+// moveStage attaches the staging label to the version, removing it from the version holding it. Moving AWSCURRENT
+// also moves AWSPREVIOUS to the version that was current, as Secrets Manager does.
+func (s *fakeSecret) moveStage(stage string, versionId string) {
+	previous := s.stagedVersion(stage)
+	if previous == versionId {
+		return
+	}
+	if previous != "" {
+		s.stages[previous] = slices.DeleteFunc(s.stages[previous], func(label string) bool { return label == stage })
+	}
+	s.stages[versionId] = append(s.stages[versionId], stage)
+	if stage == "AWSCURRENT" && previous != "" {
+		s.moveStage("AWSPREVIOUS", previous)
+	}
+}